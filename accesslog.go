@@ -0,0 +1,58 @@
+package tftp
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogEntry is the JSON object of a request in an access log
+type accessLogEntry struct {
+	Time     string            `json:"time"`
+	Peer     string            `json:"peer,omitempty"`
+	Op       string            `json:"op"`
+	Filename string            `json:"filename,omitempty"`
+	Mode     string            `json:"mode,omitempty"`
+	Result   string            `json:"result"`
+	Error    string            `json:"error,omitempty"`
+	Bytes    int64             `json:"bytes"`
+	Duration float64           `json:"duration"`
+	Options  map[string]string `json:"options,omitempty"`
+}
+
+// AccessLog returns an Audit function writing the requests reported to it
+// to w as an access log of one JSON object per line, with the time in RFC
+// 3339 format, the duration in seconds, the result in lower case, as
+// "completed" or "refused", and the options acknowledged to the client,
+// for log shippers to parse. The lines are written by a single call to
+// w.Write each, errors writing them are ignored.
+func AccessLog(w io.Writer) func(AuditRecord) {
+	var mu sync.Mutex
+	return func(rec AuditRecord) {
+		e := accessLogEntry{
+			Time:     rec.Time.Format(time.RFC3339Nano),
+			Op:       rec.Op.String(),
+			Filename: rec.Filename,
+			Mode:     rec.Mode.wireName(),
+			Result:   strings.ToLower(strings.TrimPrefix(rec.Result.String(), "Audit")),
+			Bytes:    rec.Bytes,
+			Duration: rec.Duration.Seconds(),
+			Options:  rec.Options,
+		}
+		if rec.Peer != nil {
+			e.Peer = rec.Peer.String()
+		}
+		if rec.Err != nil {
+			e.Error = rec.Err.Error()
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(line, '\n'))
+	}
+}
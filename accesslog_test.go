@@ -0,0 +1,77 @@
+package tftp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// lineWriter sends the lines written to it to a channel
+type lineWriter chan []byte
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestAccessLog(t *testing.T) {
+	lines := make(lineWriter, 10)
+	m := &MemFS{}
+	m.Set("file", make([]byte, 1000))
+	addr := startServer(t, &Server{
+		Handler: m,
+		Authorize: func(peer net.Addr, op Opcode, filename string, mode Mode) error {
+			if filename == "secret" {
+				return ErrAccessViolation
+			}
+			return nil
+		},
+		Audit: AccessLog(lines),
+	})
+	next := func() map[string]any {
+		t.Helper()
+		select {
+		case line := <-lines:
+			if len(line) == 0 || line[len(line)-1] != '\n' {
+				t.Errorf("line %q not terminated", line)
+			}
+			var e map[string]any
+			if err := json.Unmarshal(line, &e); err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("request not logged")
+			return nil
+		}
+	}
+
+	c := &Client{Timeout: time.Second, BlockSize: 600}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	e := next()
+	if e["op"] != "RRQ" || e["filename"] != "file" || e["mode"] != "octet" || e["result"] != "completed" || e["bytes"] != 1000.0 || e["error"] != nil || e["peer"] == nil {
+		t.Errorf("served: got %v", e)
+	}
+	if ts, _ := e["time"].(string); ts == "" {
+		t.Errorf("served: no time in %v", e)
+	} else if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+		t.Errorf("served: %v", err)
+	}
+	if d, ok := e["duration"].(float64); !ok || d < 0 {
+		t.Errorf("served: got duration %v", e["duration"])
+	}
+	if options, _ := e["options"].(map[string]any); options["blksize"] != "600" {
+		t.Errorf("served: got options %v", e["options"])
+	}
+
+	c.Get(context.Background(), addr.String(), "secret", io.Discard)
+	e = next()
+	if e["result"] != "refused" || e["filename"] != "secret" || e["error"] == nil || e["options"] != nil {
+		t.Errorf("refused: got %v", e)
+	}
+}
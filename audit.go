@@ -0,0 +1,80 @@
+package tftp
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// AuditRecord describes a request received by a server and its outcome
+type AuditRecord struct {
+	Time     time.Time         // time the request was received
+	Peer     net.Addr          // address of the client
+	Op       Opcode            // RRQ or WRQ
+	Filename string            // requested file, empty if the request is malformed
+	Mode     Mode              // requested mode, zero if the request is malformed
+	Result   AuditResult       // outcome of the request
+	Err      error             // reason the request failed, was refused or dropped
+	Bytes    int64             // file data transferred
+	Duration time.Duration     // time from request to completion
+	Options  map[string]string // options acknowledged in the OACK, nil if none was sent
+}
+
+// AuditResult is the outcome of a request
+type AuditResult int
+
+const (
+	AuditCompleted AuditResult = iota // the file was transferred
+	AuditFailed                       // the transfer failed
+	AuditRefused                      // refused with an ERROR packet before the transfer started
+	AuditDropped                      // ignored without a reply
+)
+
+// errRateLimited and errBusy are the reasons requests are dropped or
+// refused before they are served
+var (
+	errRateLimited = errors.New("tftp: rate limit exceeded")
+	errBusy        = errors.New("tftp: server busy")
+)
+
+// audit reports a request to Audit
+func (s *Server) audit(rec AuditRecord) {
+	if audit := s.Audit; audit != nil {
+		audit(rec)
+	}
+}
+
+// auditRequest reports a request refused or dropped before it is served
+func (s *Server) auditRequest(peer net.Addr, req packet, result AuditResult, err error) {
+	audit := s.Audit
+	if audit == nil {
+		return
+	}
+	rec := AuditRecord{Time: time.Now(), Peer: peer, Op: req.opcode(), Result: result, Err: err}
+	if filename, mode, _, perr := unmarshalRequest(req, req.opcode()); perr == nil {
+		rec.Filename, rec.Mode = filename, mode
+	}
+	audit(rec)
+}
+
+// auditStats reports a request served or refused by serve
+func (s *Server) auditStats(t *transfer, r *Request, st Stats, result AuditResult) {
+	if s.Audit == nil {
+		return
+	}
+	if result == AuditCompleted && st.Err != nil {
+		result = AuditFailed
+	}
+	s.Audit(AuditRecord{
+		Time:     t.start,
+		Peer:     st.Peer,
+		Op:       st.Op,
+		Filename: st.Filename,
+		Mode:     r.Mode,
+		Result:   result,
+		Err:      st.Err,
+		Bytes:    st.Bytes,
+		Duration: st.Duration,
+		Options:  t.acked,
+	})
+}
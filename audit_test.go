@@ -0,0 +1,76 @@
+package tftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerAudit(t *testing.T) {
+	records := make(chan AuditRecord, 10)
+	m := &MemFS{}
+	m.Set("file", []byte("data"))
+	s := &Server{
+		Handler: m,
+		Authorize: func(peer net.Addr, op Opcode, filename string, mode Mode) error {
+			if filename == "secret" {
+				return ErrAccessViolation
+			}
+			return nil
+		},
+		Audit: func(rec AuditRecord) { records <- rec },
+	}
+	addr := startServer(t, s)
+	c := &Client{Timeout: time.Second}
+	next := func() AuditRecord {
+		select {
+		case rec := <-records:
+			return rec
+		case <-time.After(5 * time.Second):
+			t.Fatal("request not audited")
+			return AuditRecord{}
+		}
+	}
+
+	c.Get(context.Background(), addr.String(), "file", io.Discard)
+	if rec := next(); rec.Result != AuditCompleted || rec.Filename != "file" || rec.Op != RRQ || rec.Mode != Octet || rec.Bytes != 4 || rec.Err != nil || rec.Peer == nil {
+		t.Errorf("served: got %+v", rec)
+	}
+	c.Get(context.Background(), addr.String(), "missing", io.Discard)
+	if rec := next(); rec.Result != AuditFailed || rec.Filename != "missing" || rec.Err == nil {
+		t.Errorf("missing: got %+v", rec)
+	}
+	c.Get(context.Background(), addr.String(), "secret", io.Discard)
+	if rec := next(); rec.Result != AuditRefused || rec.Filename != "secret" || rec.Err == nil {
+		t.Errorf("unauthorized: got %+v", rec)
+	}
+	conn := dialServer(t)
+	conn.WriteTo([]byte("\x00\x01file\x00octet\x00blksize\x00large\x00"), addr)
+	if rec := next(); rec.Result != AuditRefused || rec.Filename != "file" || rec.Err == nil {
+		t.Errorf("malformed: got %+v", rec)
+	}
+
+	// requests beyond the rate limit are dropped, while the first one is
+	// still being served
+	addr = startServer(t, &Server{
+		Handler:   m,
+		RateLimit: &RateLimit{Requests: 0.001},
+		Audit:     func(rec AuditRecord) { records <- rec },
+	})
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	if rec := next(); rec.Result != AuditDropped || rec.Filename != "file" || rec.Err != errRateLimited {
+		t.Errorf("rate limited: got %+v", rec)
+	}
+}
+
+func TestAuditResultString(t *testing.T) {
+	if got := AuditRefused.String(); got != "AuditRefused" {
+		t.Errorf("got %s", got)
+	}
+	if got := AuditResult(9).String(); got != "AuditResult(9)" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,72 @@
+package tftp
+
+import (
+	"context"
+	"io"
+	"io/fs"
+)
+
+// Backend is a store of files served by a server, for example object
+// storage. Names are the filenames requested by clients cleaned with
+// CleanPath. Errors wrapping fs.ErrNotExist and fs.ErrPermission are reported
+// to clients as FileNotFound and AccessViolation.
+type Backend interface {
+	Open(ctx context.Context, name string) (io.ReadCloser, error)    // opens a file for reading
+	Create(ctx context.Context, name string) (io.WriteCloser, error) // creates or replaces a file, complete when closed
+	Stat(ctx context.Context, name string) (fs.FileInfo, error)      // describes a file
+	Remove(ctx context.Context, name string) error                   // removes a file
+}
+
+// WriteAborter is implemented by the writers of Backend.Create that can
+// abandon a file, so that a failed upload leaves the file it was to
+// replace intact
+type WriteAborter interface {
+	io.WriteCloser
+	Abort() error // discards the file written, instead of Close
+}
+
+// BackendHandler returns a Handler serving reads from b and storing writes
+// in b. When an upload fails, the file written is discarded with Abort if
+// the writer is a WriteAborter, or else closed and removed, so that no
+// partially written file is left. A file is not removed when Close fails,
+// as it was not replaced.
+func BackendHandler(b Backend) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) error {
+		ctx := r.Context()
+		name, err := CleanPath(r.Filename)
+		if err != nil {
+			return err
+		}
+		if r.Op == WRQ {
+			wc, err := b.Create(ctx, name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(wc, r.Body); err != nil {
+				if a, ok := wc.(WriteAborter); ok {
+					a.Abort()
+				} else if wc.Close() == nil {
+					// the partially written file replaced the file
+					b.Remove(context.WithoutCancel(ctx), name)
+				}
+				return err
+			}
+			return wc.Close()
+		}
+		fi, err := b.Stat(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrPermission}
+		}
+		rc, err := b.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		w.SetSize(fi.Size())
+		_, err = io.Copy(w, rc)
+		return err
+	})
+}
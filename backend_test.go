@@ -0,0 +1,120 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+)
+
+// mapBackend is a Backend storing files in a map
+type mapBackend struct {
+	sync.Mutex
+	files   fstest.MapFS
+	removed chan string
+	aborted chan string
+}
+
+func (b *mapBackend) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.files.Open(name)
+}
+
+func (b *mapBackend) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &mapFile{name: name, b: b}, nil
+}
+
+func (b *mapBackend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.files.Stat(name)
+}
+
+func (b *mapBackend) Remove(ctx context.Context, name string) error {
+	b.removed <- name
+	return nil
+}
+
+// mapFile is a file stored in a mapBackend on Close
+type mapFile struct {
+	bytes.Buffer
+	name string
+	b    *mapBackend
+}
+
+func (f *mapFile) Abort() error {
+	f.b.aborted <- f.name
+	return nil
+}
+
+func (f *mapFile) Close() error {
+	if f.Len() > 100 {
+		return errors.New("disk full")
+	}
+	f.b.Lock()
+	f.b.files[f.name] = &fstest.MapFile{Data: f.Bytes()}
+	f.b.Unlock()
+	return nil
+}
+
+func TestBackendHandler(t *testing.T) {
+	b := &mapBackend{
+		files:   fstest.MapFS{"dir/file": {Data: []byte("contents")}},
+		removed: make(chan string, 1),
+		aborted: make(chan string, 1),
+	}
+	addr := startServer(t, &Server{Backend: b}).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+	var got bytes.Buffer
+	if err := c.Get(ctx, addr, "/dir/file", &got); err != nil || got.String() != "contents" {
+		t.Errorf("got %q, %v", got.String(), err)
+	}
+	if err := c.Get(ctx, addr, "dir", &got); err == nil {
+		t.Error("directory served")
+	}
+	if err := c.Put(ctx, addr, "dir/upload", bytes.NewReader([]byte("upload"))); err != nil {
+		t.Fatal(err)
+	}
+	// the file is stored before the final ACK
+	b.Lock()
+	f := b.files["dir/upload"]
+	b.Unlock()
+	if f == nil || string(f.Data) != "upload" {
+		t.Errorf("stored %v", f)
+	}
+	if err := c.Put(ctx, addr, "dir/large", bytes.NewReader(make([]byte, 101))); err == nil {
+		t.Error("failed write succeeded")
+	}
+	select {
+	case name := <-b.removed:
+		t.Errorf("removed %q not replaced by a failed Close", name)
+	default:
+	}
+
+	// an upload failing over an existing file is aborted, the file is
+	// intact
+	r := io.MultiReader(bytes.NewReader(make([]byte, 600)), iotest.ErrReader(errors.New("read failed")))
+	if err := c.Put(ctx, addr, "dir/file", r); err == nil {
+		t.Error("failed upload succeeded")
+	}
+	if name := <-b.aborted; name != "dir/file" {
+		t.Errorf("aborted %q, want dir/file", name)
+	}
+	got.Reset()
+	if err := c.Get(ctx, addr, "dir/file", &got); err != nil || got.String() != "contents" {
+		t.Errorf("after a failed upload: got %q, %v", got.String(), err)
+	}
+	select {
+	case name := <-b.removed:
+		t.Errorf("removed %q", name)
+	default:
+	}
+}
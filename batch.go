@@ -0,0 +1,116 @@
+package tftp
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batch limits
+const (
+	maxBatch      = 16         // datagrams read or written per system call
+	maxBatchBytes = 256 * 1024 // size of the buffers for batched reads
+)
+
+// batchConn reads and writes several datagrams per system call, with
+// recvmmsg and sendmmsg on Linux and one datagram at a time elsewhere
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// newBatchConn returns conn for batched I/O with peer, or nil if conn is
+// not a UDP socket of the family of peer. A dual stack IPv6 socket cannot
+// batch datagrams to IPv4 peers as their addresses would be IPv4 socket
+// addresses, unless it is connected and datagrams carry no address.
+func newBatchConn(conn net.PacketConn, peer net.Addr, connected bool) batchConn {
+	c, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	local, _ := c.LocalAddr().(*net.UDPAddr)
+	remote, _ := peer.(*net.UDPAddr)
+	if local == nil || remote == nil {
+		return nil
+	}
+	switch v4 := local.IP.To4() != nil; {
+	case v4 && remote.IP.To4() != nil:
+		return ipv4.NewPacketConn(c)
+	case !v4 && (remote.IP.To4() == nil || connected):
+		return ipv6.NewPacketConn(c)
+	}
+	return nil
+}
+
+// sendBatch sends packets to the peer, in as few system calls as possible
+// with batched I/O
+func (t *transfer) sendBatch(ps []packet) error {
+	if t.gso {
+		if ok, err := t.sendSegments(ps); ok {
+			return err
+		}
+	}
+	if t.batch == nil || len(ps) == 1 {
+		for _, p := range ps {
+			if err := t.send(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	t.sent = t.now()
+	for len(ps) > 0 {
+		ms := t.messages(min(len(ps), maxBatch))
+		for i := range ms {
+			t.traceSend(ps[i], t.peer)
+			ms[i].Buffers[0] = ps[i]
+			ms[i].Addr = t.peer
+			if t.connected {
+				ms[i].Addr = nil
+			}
+		}
+		n, err := t.batch.WriteBatch(ms, 0)
+		if err != nil {
+			return err
+		}
+		ps = ps[n:]
+	}
+	return nil
+}
+
+// messages returns n messages of one buffer each for writing
+func (t *transfer) messages(n int) []ipv4.Message {
+	for len(t.wmsgs) < n {
+		t.wmsgs = append(t.wmsgs, ipv4.Message{Buffers: make([][]byte, 1)})
+	}
+	return t.wmsgs[:n]
+}
+
+// read reads the next datagram from any peer, reading as many datagrams
+// as the window holds at once with batched I/O
+func (t *transfer) read() ([]byte, net.Addr, error) {
+	if len(t.queue) > 0 {
+		m := t.queue[0]
+		t.queue = t.queue[1:]
+		return m.Buffers[0][:m.N], m.Addr, nil
+	}
+	n := min(t.window, maxBatch, maxBatchBytes/len(t.buf))
+	if t.batch == nil || n <= 1 {
+		n, addr, err := t.conn.ReadFrom(t.buf)
+		return t.buf[:n], addr, err
+	}
+	if len(t.rmsgs) != n || len(t.rmsgs[0].Buffers[0]) != len(t.buf) {
+		t.rmsgs = make([]ipv4.Message, n)
+		for i := range t.rmsgs {
+			t.rmsgs[i].Buffers = [][]byte{make([]byte, len(t.buf))}
+		}
+	}
+	n, err := t.batch.ReadBatch(t.rmsgs, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := t.rmsgs[0]
+	t.queue = t.rmsgs[1:n]
+	return m.Buffers[0][:m.N], m.Addr, nil
+}
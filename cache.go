@@ -0,0 +1,179 @@
+package tftp
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+)
+
+// defaultCacheBytes is the file data kept by a Cache with no MaxBytes
+const defaultCacheBytes = 64 << 20
+
+// Cache is a Handler keeping the files read from Handler in memory, so
+// that the files requested by many clients at once, such as boot loaders
+// and kernels, are read once. Once the cached files exceed MaxBytes, the
+// least recently requested files are evicted. Files are cached by the name
+// cleaned with CleanPath, Handler must serve the same file to every
+// client. A WRQ is passed to Handler and evicts the file. It is safe for
+// concurrent use.
+type Cache struct {
+	Handler     Handler
+	MaxBytes    int64 // budget of cached file data, 64 MiB if zero
+	MaxFileSize int64 // largest cached file, MaxBytes if zero
+
+	mu    sync.Mutex
+	files map[string]*list.Element // of *cached
+	lru   list.List                // most recently requested first
+	size  int64                    // cached file data
+	gen   uint64                   // incremented by each invalidation
+}
+
+// cached is a file held by a Cache
+type cached struct {
+	name string
+	data []byte
+}
+
+// ServeTFTP serves a RRQ from memory or from Handler, caching the file
+func (c *Cache) ServeTFTP(w ResponseWriter, r *Request) error {
+	name, err := CleanPath(r.Filename)
+	if err != nil || r.Op == WRQ {
+		if err == nil {
+			defer c.Invalidate(name)
+		}
+		return c.Handler.ServeTFTP(w, r)
+	}
+	if data, ok := c.get(name); ok {
+		w.SetSize(int64(len(data)))
+		_, err := io.Copy(w, bytes.NewReader(data))
+		return err
+	}
+	c.mu.Lock()
+	gen := c.gen
+	c.mu.Unlock()
+	rec := &recorder{ResponseWriter: w, max: c.maxFileSize(), data: []byte{}}
+	if err := c.Handler.ServeTFTP(rec, r); err != nil {
+		return err
+	}
+	if rec.data != nil {
+		c.put(name, rec.data, gen)
+	}
+	return nil
+}
+
+// maxBytes returns the budget of cached file data
+func (c *Cache) maxBytes() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return defaultCacheBytes
+}
+
+// maxFileSize returns the size of the largest cached file
+func (c *Cache) maxFileSize() int64 {
+	if c.MaxFileSize > 0 && c.MaxFileSize < c.maxBytes() {
+		return c.MaxFileSize
+	}
+	return c.maxBytes()
+}
+
+// get returns the cached file name, marking it recently requested
+func (c *Cache) get(name string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.files[name]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cached).data, true
+}
+
+// put caches data as the file name unless a file was invalidated since gen,
+// evicting the least recently requested files over the budget
+func (c *Cache) put(name string, data []byte, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		// the file may have changed while it was read
+		return
+	}
+	if c.files == nil {
+		c.files = make(map[string]*list.Element)
+	}
+	if e, ok := c.files[name]; ok {
+		c.remove(e)
+	}
+	c.files[name] = c.lru.PushFront(&cached{name, data})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes() {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove evicts the file of e
+func (c *Cache) remove(e *list.Element) {
+	f := c.lru.Remove(e).(*cached)
+	delete(c.files, f.name)
+	c.size -= int64(len(f.data))
+}
+
+// Invalidate evicts the file name, which is read from Handler when next
+// requested
+func (c *Cache) Invalidate(name string) {
+	name, err := CleanPath(name)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if e, ok := c.files[name]; ok {
+		c.remove(e)
+	}
+}
+
+// Clear evicts all files
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	clear(c.files)
+	c.lru.Init()
+	c.size = 0
+}
+
+// Size returns the number of cached files and their total size
+func (c *Cache) Size() (files int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.files), c.size
+}
+
+// recorder is a ResponseWriter keeping a copy of the file written, up to
+// max bytes
+type recorder struct {
+	ResponseWriter
+	max  int64
+	data []byte // file written, nil if larger than max
+}
+
+// SetSize announces the file size and reserves the copy of the file
+func (r *recorder) SetSize(size int64) {
+	r.ResponseWriter.SetSize(size)
+	if r.data != nil && len(r.data) == 0 && size <= r.max {
+		r.data = make([]byte, 0, size)
+	}
+}
+
+// Write sends p and appends it to the copy of the file
+func (r *recorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	if r.data != nil && int64(len(r.data)+n) <= r.max {
+		r.data = append(r.data, p[:n]...)
+	} else {
+		r.data = nil
+	}
+	return n, err
+}
@@ -0,0 +1,89 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	m := &MemFS{Writable: true}
+	m.Set("small", []byte("small file"))
+	m.Set("large", bytes.Repeat([]byte("x"), 3000))
+	m.Set("other", bytes.Repeat([]byte("y"), 1500))
+	var reads atomic.Int32
+	cache := &Cache{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			if r.Op == RRQ {
+				reads.Add(1)
+			}
+			return m.ServeTFTP(w, r)
+		}),
+		MaxBytes:    2000,
+		MaxFileSize: 1600,
+	}
+	addr := startServer(t, &Server{Handler: cache}).String()
+	ctx := context.Background()
+	c := &Client{Timeout: time.Second}
+	get := func(name string) string {
+		t.Helper()
+		var got bytes.Buffer
+		if err := c.Get(ctx, addr, name, &got); err != nil {
+			t.Fatal(err)
+		}
+		return got.String()
+	}
+
+	for range 3 {
+		if got := get("/small"); got != "small file" {
+			t.Errorf("got %q", got)
+		}
+	}
+	if n := reads.Load(); n != 1 {
+		t.Errorf("small file read %d times, want once", n)
+	}
+	// files over MaxFileSize are not cached
+	get("large")
+	get("large")
+	if n := reads.Load(); n != 3 {
+		t.Errorf("%d reads, want large file read twice", n)
+	}
+	if files, size := cache.Size(); files != 1 || size != 10 {
+		t.Errorf("cached %d files of %d bytes", files, size)
+	}
+	// the least recently requested file is evicted over MaxBytes
+	get("other")
+	get("small")
+	get("other")
+	if n := reads.Load(); n != 4 {
+		t.Errorf("%d reads, want other file cached", n)
+	}
+	m.Set("another", bytes.Repeat([]byte("z"), 495))
+	get("another")
+	get("other")
+	get("small")
+	if n := reads.Load(); n != 6 {
+		t.Errorf("%d reads, want small file evicted", n)
+	}
+
+	// uploads evict the file
+	get("small")
+	if err := c.Put(ctx, addr, "small", strings.NewReader("new small file")); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("small"); got != "new small file" {
+		t.Errorf("after upload got %q", got)
+	}
+	cache.Invalidate("/small")
+	m.Set("small", []byte("newer"))
+	if got := get("small"); got != "newer" {
+		t.Errorf("after invalidation got %q", got)
+	}
+	cache.Clear()
+	if files, size := cache.Size(); files != 0 || size != 0 {
+		t.Errorf("cleared cache holds %d files of %d bytes", files, size)
+	}
+}
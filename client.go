@@ -0,0 +1,625 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Client is a TFTP client
+type Client struct {
+	Mode       Mode          // transfer mode, Octet if zero
+	Timeout    time.Duration // initial retransmission interval, 5 seconds if zero, negotiated and fixed if whole seconds, doubled for each retransmission
+	Retries    int           // retransmissions of a block or acknowledgement before giving up, 5 if zero
+	BlockSize  int           // block size to negotiate, 512 if zero
+	WindowSize int           // window size to negotiate, 1 if zero
+	Newline    Newline       // line ending of the local text of files received in netascii, LF if zero
+
+	RequestRetries   int           // retransmissions of the RRQ or WRQ before giving up, Retries if zero
+	HandshakeTimeout time.Duration // longest wait for the first reply of the server over all retransmissions, unlimited if zero
+	IdleTimeout      time.Duration // longest time a transfer makes no progress before it fails, unlimited if zero
+	MaxBandwidth     float64       // file data bytes per second of each transfer, or shared by the transfers of a GetAll, unlimited if zero
+
+	// BlockSizeFallback transfers the file again with the default block
+	// size when no block of a larger negotiated block size gets through,
+	// as when packets exceeding the path MTU are dropped or refused with
+	// EMSGSIZE. The file of a Put is sent again only from an io.Seeker.
+	BlockSizeFallback bool
+
+	// VerifyRetries is the number of times GetVerified reads a file again
+	// whose digest is not the expected one, none if zero
+	VerifyRetries int
+
+	// OnProgress is called with the file data transferred so far and the
+	// file size, -1 if unknown, as blocks are received or acknowledged
+	OnProgress func(transferred, size int64)
+
+	// OnComplete is called with the statistics of each Get or Put
+	OnComplete func(Stats)
+
+	// OnTransferStart is called with the operation, filename and server
+	// address of each transfer of a Get or Put once its request is about
+	// to be sent. OnTransferComplete or OnTransferError is then called
+	// with the statistics of the transfer once it succeeds or fails. A Get
+	// or Put falling back to a smaller block size makes two transfers.
+	OnTransferStart    func(st Stats)
+	OnTransferComplete func(st Stats)
+	OnTransferError    func(st Stats)
+
+	GSO       bool      // send windows of DATA packets with UDP segmentation offload on Linux, ignored elsewhere
+	Transport Transport // transport of the conns of transfers, UDP sockets if nil
+
+	// Clock is the clock of timeouts and retransmissions, the system clock
+	// if nil. Read deadlines of conns are set in its time, so another
+	// clock suits conns of a Loopback with the same Clock only.
+	Clock Clock
+
+	Logger  *slog.Logger // logger for transfers and errors, nothing is logged if nil
+	Capture *PcapWriter  // capture of all packets sent and received, nothing is captured if nil
+
+	Multicast          bool           // request RFC 2090 multicast for Get
+	MulticastInterface *net.Interface // interface to join multicast groups on, system default if nil
+
+	shaper *shaper // bandwidth shared by transfers, of each transfer if nil
+}
+
+// Get reads filename from the server at addr into w
+func (c *Client) Get(ctx context.Context, addr, filename string, w io.Writer) error {
+	return c.GetOffset(ctx, addr, filename, w, 0)
+}
+
+// GetOffset reads filename from the server at addr into w from the byte
+// offset off, resuming an interrupted Get whose first off bytes w holds,
+// as a partial file opened for appending. The offset is requested in
+// octet mode with the nonstandard x-offset option of a Server with
+// Resume, the file data before it is otherwise received and skipped. It
+// fails if the file ends before the offset.
+func (c *Client) GetOffset(ctx context.Context, addr, filename string, w io.Writer, off int64) error {
+	err := c.get(ctx, addr, filename, w, off)
+	var lost *blocksLostError
+	if errors.As(err, &lost) {
+		return c.fallback(lost, addr, filename).get(ctx, addr, filename, w, off)
+	}
+	return err
+}
+
+// get reads filename from the server at addr into w from offset off
+func (c *Client) get(ctx context.Context, addr, filename string, w io.Writer, off int64) (err error) {
+	var t *transfer
+	defer func() {
+		c.complete(ctx, t, RRQ, filename, err)
+		err = c.blocksLost(t, err)
+	}()
+	t, err = c.dial(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer t.conn.Close()
+	c.logRequest(t, RRQ, filename)
+	c.begin(t, RRQ, filename)
+	defer t.watch()()
+	mode := c.mode()
+	var skip *skipWriter
+	if off > 0 {
+		skip = &skipWriter{w: w, n: off}
+		w = skip
+	}
+	var d *NetasciiWriter
+	if mode == Netascii {
+		d = NewNetasciiWriter(w)
+		d.Newline = c.Newline
+		w = d
+	}
+	options := c.options(RRQ)
+	if off > 0 && mode == Octet {
+		options[offset] = int(off)
+	}
+	if c.OnProgress != nil && mode == Octet {
+		options[tsize] = 0
+	}
+	rrq, err := (&ReadRequest{Filename: filename, Mode: mode, Options: optionStrings(options)}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	r := newReceiver(t, rrq)
+	if len(options) > 0 {
+		t.negotiateStart()
+		p, err := t.exchange(rrq, func(p packet) bool {
+			return p.opcode() == OACK || p.opcode() == DATA && p.block() == 1
+		})
+		t.negotiateDone(err)
+		if err != nil {
+			return err
+		}
+		if p.opcode() == DATA {
+			// the server ignored the options, RFC 1350 defaults apply
+			t.log.Debug("options not acknowledged")
+			r.accept(p)
+		} else {
+			oack := p.options()
+			if err := c.accept(t, options, p); err != nil {
+				t.abort(err)
+				return err
+			}
+			if n, ok := oack[tsize]; ok {
+				t.size = int64(n)
+			}
+			if _, ok := oack[offset]; ok {
+				// the server sends the file from the offset
+				skip.n = 0
+			}
+			if v, ok := p.multicast(); ok && c.Multicast {
+				err := c.getMulticast(t, v, w)
+				if err == nil && d != nil {
+					err = d.Flush()
+				}
+				if err == nil {
+					err = skip.short()
+				}
+				return err
+			}
+			r.ack = newACKPacket(0)
+		}
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		if r.err == nil {
+			t.abort(err)
+		}
+		return err
+	}
+	if d != nil {
+		if err := d.Flush(); err != nil {
+			t.abort(err)
+			return err
+		}
+	}
+	if err := r.Close(); err != nil {
+		return err
+	}
+	return skip.short()
+}
+
+// skipWriter skips the first n bytes written to w
+type skipWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	n := min(int64(len(p)), s.n)
+	s.n -= n
+	if int(n) < len(p) {
+		if _, err := s.w.Write(p[n:]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// short returns errOffset if fewer bytes than skipped were written, nil
+// for a nil s
+func (s *skipWriter) short() error {
+	if s != nil && s.n > 0 {
+		return errOffset
+	}
+	return nil
+}
+
+// GetTo reads filename from the server at addr into w as it is received,
+// as into a hash or a decompressor, and returns the number of bytes
+// written to w
+func (c *Client) GetTo(ctx context.Context, addr, filename string, w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := c.Get(ctx, addr, filename, cw)
+	return cw.n, err
+}
+
+// Put writes the contents of r to filename on the server at addr
+func (c *Client) Put(ctx context.Context, addr, filename string, r io.Reader) error {
+	_, err := c.PutFrom(ctx, addr, filename, r, -1)
+	return err
+}
+
+// ErrSizeMismatch is returned by PutFrom when the reader holds fewer or
+// more bytes than the size announced
+var ErrSizeMismatch = errors.New("tftp: size of the data differs from the size announced")
+
+// PutFrom writes the size bytes of r to filename on the server at addr as
+// they are read, as from a pipe, and returns the number of bytes read from
+// r. The size is announced to the server with the tsize option in octet
+// mode, and the transfer fails with ErrSizeMismatch if r holds fewer or
+// more bytes. With a negative size the contents of r are written as by
+// Put, its size announced only if r tells it.
+func (c *Client) PutFrom(ctx context.Context, addr, filename string, r io.Reader, size int64) (int64, error) {
+	seeker, _ := r.(io.Seeker)
+	var start int64
+	if c.BlockSizeFallback && seeker != nil {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	n, err := c.put(ctx, addr, filename, r, size)
+	var lost *blocksLostError
+	if errors.As(err, &lost) {
+		if seeker == nil {
+			return n, lost.err
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return n, lost.err
+		}
+		return c.fallback(lost, addr, filename).put(ctx, addr, filename, r, size)
+	}
+	return n, err
+}
+
+// put writes the contents of r to filename on the server at addr, size
+// bytes if not negative, and returns the number of bytes read from r
+func (c *Client) put(ctx context.Context, addr, filename string, r io.Reader, size int64) (n int64, err error) {
+	var t *transfer
+	defer func() {
+		c.complete(ctx, t, WRQ, filename, err)
+		err = c.blocksLost(t, err)
+	}()
+	t, err = c.dial(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer t.conn.Close()
+	c.logRequest(t, WRQ, filename)
+	c.begin(t, WRQ, filename)
+	defer t.watch()()
+	mode := c.mode()
+	ok := size >= 0
+	if !ok {
+		size, ok = transferSize(r)
+	} else {
+		r = &sizedReader{r: r, left: size}
+	}
+	cr := &countingReader{r: r}
+	r = cr
+	if mode == Netascii {
+		r = NewNetasciiReader(r)
+	}
+	options := c.options(WRQ)
+	if ok && mode == Octet {
+		options[tsize] = int(size)
+		t.size = size
+	}
+	wrq, err := (&WriteRequest{Filename: filename, Mode: mode, Options: optionStrings(options)}).MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	t.negotiateStart()
+	p, err := t.exchange(wrq, func(p packet) bool {
+		return p.opcode() == ACK && p.block() == 0 || p.opcode() == OACK && len(options) > 0
+	})
+	t.negotiateDone(err)
+	if err != nil {
+		return 0, err
+	}
+	if p.opcode() == OACK {
+		if err := c.accept(t, options, p); err != nil {
+			t.abort(err)
+			return 0, err
+		}
+	} else if len(options) > 0 {
+		// the server ignored the options, RFC 1350 defaults apply
+		t.log.Debug("options not acknowledged")
+	}
+	w := newSender(t)
+	if _, err := io.Copy(w, r); err != nil {
+		if w.err == nil {
+			t.abort(err)
+		}
+		return cr.n, err
+	}
+	return cr.n, w.Close()
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sizedReader reads the left bytes of r, failing with ErrSizeMismatch if
+// r ends before or holds more
+type sizedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.left <= 0 {
+		// r must end with the size announced
+		var b [1]byte
+		n, err := io.ReadFull(s.r, b[:])
+		if n > 0 {
+			return 0, ErrSizeMismatch
+		}
+		return 0, err
+	}
+	if int64(len(p)) > s.left {
+		p = p[:s.left]
+	}
+	n, err := s.r.Read(p)
+	s.left -= int64(n)
+	if err == io.EOF && s.left > 0 {
+		err = ErrSizeMismatch
+	}
+	return n, err
+}
+
+// blocksLostError is the error of a transfer with BlockSizeFallback that
+// failed before any block of a larger block size got through
+type blocksLostError struct {
+	err     error
+	blksize int
+}
+
+func (e *blocksLostError) Error() string {
+	return e.err.Error()
+}
+
+func (e *blocksLostError) Unwrap() error {
+	return e.err
+}
+
+// blocksLost returns a *blocksLostError for err if the transfer t failed
+// before any block of a larger block size got through, with
+// BlockSizeFallback
+func (c *Client) blocksLost(t *transfer, err error) error {
+	if !c.BlockSizeFallback || t == nil || t.blksize <= defaultBlockSize || t.transferred > 0 {
+		return err
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, syscall.EMSGSIZE) {
+		return &blocksLostError{err: err, blksize: t.blksize}
+	}
+	return err
+}
+
+// fallback returns a copy of c transferring with the default block size
+// after lost blocks
+func (c *Client) fallback(lost *blocksLostError, addr, filename string) *Client {
+	if c.Logger != nil {
+		c.Logger.Warn("blocks lost, transferring again with the default block size", "peer", addr, "filename", filename, "blksize", lost.blksize, "err", lost.err)
+	}
+	c2 := *c
+	c2.BlockSize = defaultBlockSize
+	c2.BlockSizeFallback = false
+	return &c2
+}
+
+// ErrSizeUnknown is returned by Stat when the server does not report the
+// file size
+var ErrSizeUnknown = errors.New("tftp: transfer size unknown")
+
+// Stat returns the size of filename on the server at addr, requested with
+// the tsize option of a RRQ that is aborted once the server acknowledges it
+func (c *Client) Stat(ctx context.Context, addr, filename string) (size int64, err error) {
+	t, err := c.dial(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer t.conn.Close()
+	c.logRequest(t, RRQ, filename)
+	defer t.watch()()
+	rrq, err := (&ReadRequest{Filename: filename, Mode: Octet, Options: optionStrings(map[option]int{tsize: 0})}).MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	p, err := t.exchange(rrq, func(p packet) bool {
+		return p.opcode() == OACK || p.opcode() == DATA && p.block() == 1
+	})
+	if err != nil {
+		return 0, err
+	}
+	if p.opcode() == DATA {
+		// the server ignored the option, the size is known only if the
+		// file fits in the first block
+		if n := len(p.data()); n < defaultBlockSize {
+			t.send(newACKPacket(1))
+			return int64(n), nil
+		}
+		t.abort(ErrSizeUnknown)
+		return 0, ErrSizeUnknown
+	}
+	n, ok := p.options()[tsize]
+	if !ok {
+		t.abort(ErrSizeUnknown)
+		return 0, ErrSizeUnknown
+	}
+	t.send(newERRORPacket(NotDefined, "transfer size queried"))
+	return int64(n), nil
+}
+
+// options returns the options to request for a RRQ or WRQ
+func (c *Client) options(op Opcode) map[option]int {
+	options := make(map[option]int)
+	if c.BlockSize > 0 && c.BlockSize != defaultBlockSize {
+		options[blksize] = c.BlockSize
+	}
+	multicastRead := c.Multicast && op == RRQ
+	if c.WindowSize > 1 && !multicastRead {
+		options[windowsize] = c.WindowSize
+	}
+	if multicastRead {
+		options[multicast] = 0
+	}
+	if c.Timeout%time.Second == 0 {
+		if n := int(c.Timeout / time.Second); n >= minTimeout && n <= maxTimeout {
+			options[timeout] = n
+		}
+	}
+	return options
+}
+
+// accept validates the options acknowledged by the server in the OACK p
+// and applies them to t
+func (c *Client) accept(t *transfer, requested map[option]int, p packet) error {
+	oack := p.options()
+	for name, value := range p.rawOptions() {
+		o, known := optionNamed(name)
+		if _, ok := requested[o]; !known || !ok {
+			return fmt.Errorf("%w: unrequested option %s acknowledged", ErrOptionNegotiation, name)
+		}
+		if _, ok := oack[o]; !ok && o != multicast {
+			return fmt.Errorf("%w: invalid %s %q acknowledged", ErrOptionNegotiation, name, value)
+		}
+	}
+	for o, v := range oack {
+		switch o {
+		case blksize:
+			if v < minBlockSize || v > requested[blksize] {
+				return fmt.Errorf("%w: invalid blksize %d acknowledged", ErrOptionNegotiation, v)
+			}
+		case timeout:
+			if v != requested[timeout] {
+				return fmt.Errorf("%w: invalid timeout %d acknowledged", ErrOptionNegotiation, v)
+			}
+		case windowsize:
+			if v < 1 || v > requested[windowsize] {
+				return fmt.Errorf("%w: invalid windowsize %d acknowledged", ErrOptionNegotiation, v)
+			}
+		case offset:
+			if v != requested[offset] {
+				return fmt.Errorf("%w: invalid x-offset %d acknowledged", ErrOptionNegotiation, v)
+			}
+		}
+	}
+	t.apply(oack)
+	return nil
+}
+
+// logRequest sets up logging for a transfer
+func (c *Client) logRequest(t *transfer, op Opcode, filename string) {
+	if c.Logger != nil {
+		t.log = c.Logger.With("peer", t.peer.String(), "op", op.String(), "filename", filename)
+		t.log.Info("request", "mode", c.mode().String())
+	}
+}
+
+// begin reports the start of a transfer to OnTransferStart
+func (c *Client) begin(t *transfer, op Opcode, filename string) {
+	if c.OnTransferStart != nil {
+		c.OnTransferStart(Stats{Op: op, Filename: filename, Peer: t.peer, Start: t.start})
+	}
+}
+
+// complete reports the statistics of a transfer to OnComplete, and to
+// OnTransferComplete or OnTransferError if it started, t is nil if the
+// transfer failed to start
+func (c *Client) complete(ctx context.Context, t *transfer, op Opcode, filename string, err error) {
+	started := t != nil
+	if t == nil {
+		t = newTransfer(ctx, nil, nil, false)
+	}
+	st := t.stats(op, filename, err)
+	logStats(t.log, st)
+	t.done(st)
+	if c.OnComplete != nil {
+		c.OnComplete(st)
+	}
+	switch {
+	case !started:
+	case err == nil && c.OnTransferComplete != nil:
+		c.OnTransferComplete(st)
+	case err != nil && c.OnTransferError != nil:
+		c.OnTransferError(st)
+	}
+}
+
+// mode returns the transfer mode
+func (c *Client) mode() Mode {
+	if c.Mode == 0 {
+		return Octet
+	}
+	return c.Mode
+}
+
+// serverAddr returns addr with port 69 if it has no port. IPv6 addresses
+// may be given without brackets, and with a zone.
+func serverAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), "69")
+}
+
+// listen returns the address of the server addr and a new conn for a
+// transfer with it
+func (c *Client) listen(ctx context.Context, addr string) (net.Addr, net.PacketConn, error) {
+	if c.Transport != nil {
+		raddr, err := c.Transport.ResolveAddr(ctx, addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := c.Transport.ListenPacket(ctx, nil, raddr)
+		return raddr, conn, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", serverAddr(addr))
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return raddr, conn, nil
+}
+
+// dial returns a transfer with the server at addr on a new socket,
+// port 69 is used if addr has no port, or on a new conn of Transport
+func (c *Client) dial(ctx context.Context, addr string) (*transfer, error) {
+	raddr, conn, err := c.listen(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	t := newTransfer(ctx, capture(conn, c.Capture), raddr, false)
+	t.setClock(c.Clock)
+	t.onProgress = c.OnProgress
+	t.gso = c.GSO
+	if c.Timeout > 0 {
+		t.timeout = c.Timeout
+	}
+	if c.Retries > 0 {
+		t.retries, t.requestRetries = c.Retries, c.Retries
+	}
+	if c.RequestRetries > 0 {
+		t.requestRetries = c.RequestRetries
+	}
+	t.handshake, t.idle = c.HandshakeTimeout, c.IdleTimeout
+	if rate := c.MaxBandwidth; rate > 0 {
+		s := c.shaper
+		if s == nil {
+			s = new(shaper)
+		}
+		t.throttle = func(ctx context.Context, n int) { s.wait(ctx, rate, n) }
+	}
+	return t, nil
+}
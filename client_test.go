@@ -0,0 +1,588 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFiles is a set of in-memory files for testing
+type memFiles struct {
+	sync.Mutex
+	m    map[string][]byte
+	done chan string // receives the name of each written file
+}
+
+// get returns the contents of a file
+func (f *memFiles) get(name string) ([]byte, bool) {
+	f.Lock()
+	defer f.Unlock()
+	b, ok := f.m[name]
+	return b, ok
+}
+
+// server returns a server reading and writing the files
+func (f *memFiles) server() *Server {
+	return &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			b, ok := f.get(filename)
+			if !ok {
+				return nil, errors.New("file not found")
+			}
+			return nopReadCloser{bytes.NewReader(b)}, nil
+		},
+		WriteHandler: func(ctx context.Context, filename string, mode Mode) (io.WriteCloser, error) {
+			return &memFile{name: filename, files: f}, nil
+		},
+	}
+}
+
+// memFile is a file stored in memFiles on Close
+type memFile struct {
+	bytes.Buffer
+	name  string
+	files *memFiles
+}
+
+func (f *memFile) Close() error {
+	f.files.Lock()
+	f.files.m[f.name] = f.Bytes()
+	f.files.Unlock()
+	f.files.done <- f.name
+	return nil
+}
+
+// newMemFiles returns an empty memFiles
+func newMemFiles() *memFiles {
+	return &memFiles{m: map[string][]byte{}, done: make(chan string, 1)}
+}
+
+func TestClientGetPut(t *testing.T) {
+	files := newMemFiles()
+	addr := startServer(t, files.server()).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+	for _, size := range []int{0, 1, 511, 512, 513, 1024, 5000} {
+		content := bytes.Repeat([]byte{byte(size)}, size)
+		if err := c.Put(ctx, addr, "file", bytes.NewReader(content)); err != nil {
+			t.Fatalf("put %d bytes: %v", size, err)
+		}
+		<-files.done
+		if b, _ := files.get("file"); !bytes.Equal(b, content) {
+			t.Errorf("put %d bytes: server got %d bytes", size, len(b))
+		}
+		var got bytes.Buffer
+		if err := c.Get(ctx, addr, "file", &got); err != nil {
+			t.Fatalf("get %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got.Bytes(), content) {
+			t.Errorf("get %d bytes: got %d bytes", size, got.Len())
+		}
+	}
+}
+
+func TestClientBlockSize(t *testing.T) {
+	files := newMemFiles()
+	s := files.server()
+	s.MaxBlockSize = 1024
+	addr := startServer(t, s).String()
+	ctx := context.Background()
+	for _, blksize := range []int{8, 100, 1024, 1468, 8192} {
+		c := &Client{BlockSize: blksize, Timeout: time.Second}
+		for _, size := range []int{0, 8, 1000, 1024, 4096, 10000} {
+			content := bytes.Repeat([]byte{byte(size)}, size)
+			if err := c.Put(ctx, addr, "file", bytes.NewReader(content)); err != nil {
+				t.Fatalf("blksize %d: put %d bytes: %v", blksize, size, err)
+			}
+			<-files.done
+			var got bytes.Buffer
+			if err := c.Get(ctx, addr, "file", &got); err != nil {
+				t.Fatalf("blksize %d: get %d bytes: %v", blksize, size, err)
+			}
+			if !bytes.Equal(got.Bytes(), content) {
+				t.Errorf("blksize %d: got %d bytes, want %d", blksize, got.Len(), size)
+			}
+		}
+	}
+}
+
+func TestClientWindowSize(t *testing.T) {
+	files := newMemFiles()
+	addr := startServer(t, files.server()).String()
+	ctx := context.Background()
+	for _, window := range []int{2, 4, 16, 100} {
+		c := &Client{BlockSize: 1024, WindowSize: window, Timeout: time.Second}
+		for _, size := range []int{0, 1024, 4096, 100000} {
+			content := bytes.Repeat([]byte{byte(size)}, size)
+			if err := c.Put(ctx, addr, "file", bytes.NewReader(content)); err != nil {
+				t.Fatalf("windowsize %d: put %d bytes: %v", window, size, err)
+			}
+			<-files.done
+			var got bytes.Buffer
+			if err := c.Get(ctx, addr, "file", &got); err != nil {
+				t.Fatalf("windowsize %d: get %d bytes: %v", window, size, err)
+			}
+			if !bytes.Equal(got.Bytes(), content) {
+				t.Errorf("windowsize %d: got %d bytes, want %d", window, got.Len(), size)
+			}
+		}
+	}
+}
+
+func TestClientNetascii(t *testing.T) {
+	files := newMemFiles()
+	addr := startServer(t, files.server()).String()
+	c := &Client{Mode: Netascii, Timeout: time.Second}
+	ctx := context.Background()
+	text := strings.Repeat("line\nwith\rcarriage return\n", 100)
+	if err := c.Put(ctx, addr, "text", strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	<-files.done
+	if b, _ := files.get("text"); string(b) != text {
+		t.Errorf("server got %q, want %q", b, text)
+	}
+	var got bytes.Buffer
+	if err := c.Get(ctx, addr, "text", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != text {
+		t.Errorf("got %q, want %q", got.String(), text)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	addr := startServer(t, newMemFiles().server()).String()
+	c := &Client{Timeout: time.Second}
+	err := c.Get(context.Background(), addr, "missing", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "file not found") {
+		t.Errorf("got %v, want file not found", err)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	conn := dialServer(t) // a server that never answers
+	c := &Client{Timeout: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.Get(ctx, conn.LocalAddr().String(), "file", io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("cancellation took %v", time.Since(start))
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	conn := dialServer(t) // a server that never answers
+	c := &Client{Timeout: 20 * time.Millisecond, Retries: 2}
+	start := time.Now()
+	err := c.Get(context.Background(), conn.LocalAddr().String(), "file", io.Discard)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want timeout", err)
+	}
+	// 20ms, 40ms and 80ms plus jitter
+	if d := time.Since(start); d < 140*time.Millisecond || d > time.Second {
+		t.Errorf("timed out after %v, want about 140ms", d)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = bytes.Repeat([]byte("x"), 1300)
+	type report struct{ transferred, size int64 }
+	reports := make(chan report, 3)
+	s := files.server()
+	s.OnProgress = func(r *Request, transferred, size int64) {
+		reports <- report{transferred, size}
+	}
+	addr := startServer(t, s).String()
+	var client []report
+	c := &Client{
+		Timeout: time.Second,
+		OnProgress: func(transferred, size int64) {
+			client = append(client, report{transferred, size})
+		},
+	}
+	if err := c.Get(context.Background(), addr, "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	want := []report{{512, 1300}, {1024, 1300}, {1300, 1300}}
+	if fmt.Sprint(client) != fmt.Sprint(want) {
+		t.Errorf("client got %v, want %v", client, want)
+	}
+	// the server reports the final block once the final ACK arrives
+	server := []report{<-reports, <-reports, <-reports}
+	if fmt.Sprint(server) != fmt.Sprint(want) {
+		t.Errorf("server got %v, want %v", server, want)
+	}
+}
+
+func TestClientStat(t *testing.T) {
+	m := &MemFS{}
+	m.Set("file", bytes.Repeat([]byte("x"), 1500))
+	completed := make(chan Stats, 2)
+	s := &Server{Handler: m, OnComplete: func(st Stats) { completed <- st }}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second}
+	if size, err := c.Stat(context.Background(), addr, "file"); err != nil || size != 1500 {
+		t.Errorf("got %d, %v, want 1500", size, err)
+	}
+	// the server sees the transfer aborted after the OACK
+	if st := <-completed; !errors.Is(st.Err, ErrTransferAborted) {
+		t.Errorf("server: got %v, want ErrTransferAborted", st.Err)
+	}
+	if _, err := c.Stat(context.Background(), addr, "missing"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("missing file: got %v, want ErrFileNotFound", err)
+	}
+
+	// a server ignoring the option
+	conn := dialServer(t)
+	go func() {
+		buf := make([]byte, 1024)
+		_, peer, err := conn.ReadFrom(buf)
+		if err == nil {
+			d, _ := (&Data{Block: 1, Data: []byte("small")}).MarshalBinary()
+			conn.WriteTo(d, peer)
+		}
+	}()
+	if size, err := c.Stat(context.Background(), conn.LocalAddr().String(), "file"); err != nil || size != 5 {
+		t.Errorf("options ignored: got %d, %v, want 5", size, err)
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	for _, test := range []struct{ addr, want string }{
+		{"host", "host:69"},
+		{"host:1069", "host:1069"},
+		{"192.0.2.1", "192.0.2.1:69"},
+		{"::1", "[::1]:69"},
+		{"[::1]", "[::1]:69"},
+		{"[::1]:1069", "[::1]:1069"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:69"},
+		{"[fe80::1%eth0]:1069", "[fe80::1%eth0]:1069"},
+	} {
+		if got := serverAddr(test.addr); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.addr, got, test.want)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	addr := startServer(t, &Server{Handler: &MemFS{}}).String()
+	c := &Client{Timeout: time.Second}
+	err := c.Get(context.Background(), addr, "missing", io.Discard)
+	for _, target := range []error{ErrFileNotFound, fs.ErrNotExist, ErrTransferAborted} {
+		if !errors.Is(err, target) {
+			t.Errorf("missing file: got %v, want %v", err, target)
+		}
+	}
+	err = c.Put(context.Background(), addr, "file", strings.NewReader("data"))
+	if !errors.Is(err, ErrAccessViolation) || !errors.Is(err, fs.ErrPermission) || errors.Is(err, ErrFileNotFound) {
+		t.Errorf("write: got %v, want ErrAccessViolation", err)
+	}
+
+	// a server acknowledging a larger block size than requested
+	conn := dialServer(t)
+	go func() {
+		buf := make([]byte, 1024)
+		_, peer, err := conn.ReadFrom(buf)
+		if err == nil {
+			conn.WriteTo(newOACKPacket(map[option]int{blksize: 4096}), peer)
+		}
+	}()
+	c = &Client{Timeout: time.Second, BlockSize: 1024}
+	if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", io.Discard); !errors.Is(err, ErrOptionNegotiation) {
+		t.Errorf("got %v, want ErrOptionNegotiation", err)
+	}
+}
+
+func TestClientOptionNegotiationFailed(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"blksize": "4096"},
+		{"blksize": "1024", "unknown": "1"},
+		{"blksize": "1024", "tsize": "4"},
+		{"blksize": "large"},
+	} {
+		conn := dialServer(t)
+		code := make(chan ErrorCode, 1)
+		go func() {
+			buf := make([]byte, 1024)
+			_, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			oack, _ := (&OptionAck{Options: options}).MarshalBinary()
+			conn.WriteTo(oack, peer)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				close(code)
+				return
+			}
+			if p, err := DecodePacket(buf[:n]); err == nil {
+				if e, ok := p.(*Error); ok {
+					code <- e.Code
+				}
+			}
+			close(code)
+		}()
+		c := &Client{Timeout: time.Second, BlockSize: 1024}
+		if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", io.Discard); !errors.Is(err, ErrOptionNegotiation) {
+			t.Errorf("%v: got %v, want ErrOptionNegotiation", options, err)
+		}
+		if got := <-code; got != OptionNegotiationFailed {
+			t.Errorf("%v: got ERROR %v, want %v", options, got, OptionNegotiationFailed)
+		}
+	}
+}
+
+func TestClientFallback(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 40) // 640 bytes, two RFC 1350 blocks
+	c := &Client{Timeout: time.Second, BlockSize: 1024, WindowSize: 4, OnProgress: func(int64, int64) {}}
+
+	// a server answering a RRQ with DATA rather than an OACK
+	conn := dialServer(t)
+	go func() {
+		buf := make([]byte, 1024)
+		_, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for block, data := uint16(1), content; ; block++ {
+			n := min(len(data), defaultBlockSize)
+			d, _ := (&Data{Block: block, Data: data[:n]}).MarshalBinary()
+			conn.WriteTo(d, peer)
+			if _, _, err := conn.ReadFrom(buf); err != nil || n < defaultBlockSize {
+				return
+			}
+			data = data[n:]
+		}
+	}()
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", &got); err != nil || !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("get: got %d bytes, %v, want %d bytes", got.Len(), err, len(content))
+	}
+
+	// a server answering a WRQ with ACK 0 rather than an OACK
+	wconn := dialServer(t)
+	received := make(chan []byte, 1)
+	go func() {
+		defer close(received)
+		buf := make([]byte, 2048)
+		_, peer, err := wconn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var file []byte
+		for block := uint16(0); ; block++ {
+			ack, _ := (&Ack{Block: block}).MarshalBinary()
+			wconn.WriteTo(ack, peer)
+			if block > 0 && len(file)%defaultBlockSize != 0 {
+				received <- file
+				return
+			}
+			n, _, err := wconn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			p, err := DecodePacket(buf[:n])
+			d, ok := p.(*Data)
+			if err != nil || !ok || d.Block != block+1 || len(d.Data) > defaultBlockSize {
+				return
+			}
+			file = append(file, d.Data...)
+		}
+	}()
+	if err := c.Put(context.Background(), wconn.LocalAddr().String(), "file", bytes.NewReader(content)); err != nil {
+		t.Errorf("put: %v", err)
+	}
+	if file := <-received; !bytes.Equal(file, content) {
+		t.Errorf("put: server received %d bytes, want %d", len(file), len(content))
+	}
+}
+
+// mtuConn drops the packets larger than mtu it sends or receives
+type mtuConn struct {
+	net.PacketConn
+	mtu int
+}
+
+func (c *mtuConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || n <= c.mtu {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *mtuConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if len(p) > c.mtu {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func TestClientBlockSizeFallback(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &MemFS{Writable: true}
+	s := &Server{Handler: m, SinglePort: true, Timeout: time.Second / 10, Retries: 1}
+	go s.Serve(&mtuConn{PacketConn: conn, mtu: 600})
+	t.Cleanup(func() { s.Close() })
+	addr := conn.LocalAddr().String()
+	content := bytes.Repeat([]byte("0123456789"), 300)
+
+	c := &Client{Timeout: time.Second / 10, Retries: 1, BlockSize: 1024}
+	if err := c.Put(context.Background(), addr, "file", bytes.NewReader(content)); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("put without fallback: got %v, want ErrTimeout", err)
+	}
+	c.BlockSizeFallback = true
+	if err := c.Put(context.Background(), addr, "file", bytes.NewReader(content)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if got, _ := m.Get("file"); !bytes.Equal(got, content) {
+		t.Errorf("put: server got %d bytes, want %d", len(got), len(content))
+	}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr, "file", &got); err != nil || !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("get: got %d bytes, %v, want %d bytes", got.Len(), err, len(content))
+	}
+	// a reader that cannot be sent again
+	if err := c.Put(context.Background(), addr, "other", io.MultiReader(bytes.NewReader(content))); !errors.Is(err, ErrTimeout) {
+		t.Errorf("put from a reader: got %v, want ErrTimeout", err)
+	}
+}
+
+func TestClientTransferHooks(t *testing.T) {
+	m := &MemFS{}
+	m.Set("image", []byte("data"))
+	addr := startServer(t, &Server{Handler: m}).String()
+	var events []string
+	c := &Client{
+		Timeout: time.Second,
+		OnTransferStart: func(st Stats) {
+			if st.Peer == nil || st.Start.IsZero() {
+				t.Errorf("start: got %+v", st)
+			}
+			events = append(events, "start "+st.Op.String()+" "+st.Filename)
+		},
+		OnTransferComplete: func(st Stats) {
+			events = append(events, fmt.Sprintf("complete %s %d", st.Filename, st.Bytes))
+		},
+		OnTransferError: func(st Stats) {
+			events = append(events, "error "+st.Filename)
+		},
+	}
+	ctx := context.Background()
+	c.Get(ctx, addr, "image", io.Discard)
+	c.Get(ctx, addr, "missing", io.Discard)
+	c.Put(ctx, addr, "upload", strings.NewReader("data"))
+	// a transfer that cannot start is not reported
+	c.Get(ctx, "invalid address:port", "image", io.Discard)
+	want := []string{"start RRQ image", "complete image 4", "start RRQ missing", "error missing", "start WRQ upload", "error upload"}
+	if strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %q, want %q", events, want)
+	}
+}
+
+func TestClientStreaming(t *testing.T) {
+	m := &MemFS{Writable: true}
+	sizes := make(chan int64, 4)
+	addr := startServer(t, &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+		if r.Op == WRQ {
+			sizes <- r.Size
+		}
+		return m.ServeTFTP(w, r)
+	})}).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("0123456789"), 300)
+
+	// a pipe does not tell its size
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(content)
+		pw.Close()
+	}()
+	if n, err := c.PutFrom(ctx, addr, "file", pr, int64(len(content))); err != nil || n != int64(len(content)) {
+		t.Fatalf("got %d, %v, want %d", n, err, len(content))
+	}
+	if size := <-sizes; size != int64(len(content)) {
+		t.Errorf("server got size %d, want %d", size, len(content))
+	}
+
+	h := sha256.New()
+	if n, err := c.GetTo(ctx, addr, "file", h); err != nil || n != int64(len(content)) {
+		t.Fatalf("got %d, %v, want %d", n, err, len(content))
+	}
+	if sum := sha256.Sum256(content); !bytes.Equal(h.Sum(nil), sum[:]) {
+		t.Error("got a different hash")
+	}
+
+	// the reader holds fewer or more bytes than announced
+	for _, size := range []int64{int64(len(content)) + 1, int64(len(content)) - 1} {
+		if _, err := c.PutFrom(ctx, addr, "other", bytes.NewReader(content), size); !errors.Is(err, ErrSizeMismatch) {
+			t.Errorf("size %d: got %v, want ErrSizeMismatch", size, err)
+		}
+		<-sizes
+	}
+}
+
+func TestClientGetOffset(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 500)
+	m := &MemFS{}
+	m.Set("file", content)
+	completed := make(chan Stats, 1)
+	handlers := map[string]Handler{
+		"readerat": m,
+		"write": HandlerFunc(func(w ResponseWriter, r *Request) error {
+			for i := 0; i < len(content); i += 100 {
+				if _, err := w.Write(content[i : i+100]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+	for name, h := range handlers {
+		for _, resume := range []bool{true, false} {
+			s := &Server{Handler: h, Resume: resume, OnComplete: func(st Stats) { completed <- st }}
+			addr := startServer(t, s).String()
+			c := &Client{Timeout: time.Second, BlockSize: 1024}
+			for _, off := range []int64{0, 1, 1024, 4321, int64(len(content))} {
+				got := bytes.NewBuffer(bytes.Clone(content[:off]))
+				if err := c.GetOffset(context.Background(), addr, "file", got, off); err != nil || !bytes.Equal(got.Bytes(), content) {
+					t.Errorf("%s, resume %v, offset %d: got %d bytes, %v", name, resume, off, got.Len(), err)
+				}
+				want := int64(len(content))
+				if resume {
+					want -= off
+				}
+				if st := <-completed; st.Bytes != want {
+					t.Errorf("%s, resume %v, offset %d: sent %d bytes, want %d", name, resume, off, st.Bytes, want)
+				}
+			}
+			err := c.GetOffset(context.Background(), addr, "file", io.Discard, int64(len(content))+1)
+			// refused by the server, or noticed by the client
+			if err == nil || !resume && !errors.Is(err, errOffset) {
+				t.Errorf("%s, resume %v: got %v, want offset beyond the end", name, resume, err)
+			}
+			<-completed
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package tftp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs timers for the timeouts and
+// retransmissions of transfers, so that tests can control them
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer started by a Clock
+type Timer interface {
+	// Stop prevents the timer from firing, it returns false if it fired
+	// or was stopped already
+	Stop() bool
+}
+
+// SystemClock is the clock of the system
+var SystemClock Clock = systemClock{}
+
+// systemClock is the clock of the system
+type systemClock struct{}
+
+// Now returns time.Now()
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc returns time.AfterFunc(d, f)
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// clockTimer is a resettable timer of a Clock whose channel C is closed
+// when it fires
+type clockTimer struct {
+	clock Clock
+	C     chan struct{}
+	t     Timer
+}
+
+// newClockTimer returns a timer of clock firing after d
+func newClockTimer(clock Clock, d time.Duration) *clockTimer {
+	t := &clockTimer{clock: orSystem(clock)}
+	t.Reset(d)
+	return t
+}
+
+// Reset stops the timer and starts it again to fire after d, with a new
+// channel so that a firing of the stopped timer is not seen
+func (t *clockTimer) Reset(d time.Duration) {
+	t.Stop()
+	c := make(chan struct{})
+	t.C = c
+	t.t = t.clock.AfterFunc(d, func() { close(c) })
+}
+
+// Stop stops the timer
+func (t *clockTimer) Stop() {
+	if t.t != nil {
+		t.t.Stop()
+	}
+}
+
+// orSystem returns c, or the system clock if c is nil
+func orSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// FakeClock is a Clock whose time only moves with Advance, for tests of
+// timeouts and retransmission schedules without sleeping
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+	seq    int // timers started, ordering those firing at the same time
+}
+
+// NewFakeClock returns a FakeClock at now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// fakeTimer is a timer of a FakeClock
+type fakeTimer struct {
+	c   *FakeClock
+	at  time.Time
+	f   func()
+	seq int // order of timers firing at the same time
+}
+
+// Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc calls f once the clock advanced by d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &fakeTimer{c: c, at: c.now.Add(d), f: f, seq: c.seq}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Stop removes the timer
+func (t *fakeTimer) Stop() bool {
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, u := range c.timers {
+		if u == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, firing the timers due in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			a, b := c.timers[i], c.timers[j]
+			return a.at.Before(b.at) || a.at.Equal(b.at) && a.seq < b.seq
+		})
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Next returns the time the next timer fires, false if there is none
+func (c *FakeClock) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	next := c.timers[0].at
+	for _, t := range c.timers[1:] {
+		if t.at.Before(next) {
+			next = t.at
+		}
+	}
+	return next, true
+}
+
+// BlockUntil waits until n timers are pending
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
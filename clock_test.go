@@ -0,0 +1,159 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	var fired []string
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, "b") })
+	c.AfterFunc(time.Second, func() { fired = append(fired, "a") })
+	stopped := c.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop: want true once")
+	}
+	if next, ok := c.Next(); !ok || !next.Equal(start.Add(time.Second)) {
+		t.Errorf("Next: got %v, %v", next, ok)
+	}
+	c.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 || fired[0] != "a" {
+		t.Errorf("got %v fired, want a", fired)
+	}
+	if got := c.Now(); !got.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Errorf("got %v, want %v", got, start.Add(1500*time.Millisecond))
+	}
+	c.Advance(time.Second)
+	if len(fired) != 2 {
+		t.Errorf("got %v fired, want a b", fired)
+	}
+	if _, ok := c.Next(); ok {
+		t.Error("timers pending")
+	}
+}
+
+func TestClockTimer(t *testing.T) {
+	c := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	timer := newClockTimer(c, time.Second)
+	fired := func() bool {
+		select {
+		case <-timer.C:
+			return true
+		default:
+			return false
+		}
+	}
+	c.Advance(500 * time.Millisecond)
+	timer.Reset(time.Second)
+	c.Advance(600 * time.Millisecond)
+	if fired() {
+		t.Error("fired before the reset time")
+	}
+	c.Advance(400 * time.Millisecond)
+	if !fired() {
+		t.Error("not fired at the reset time")
+	}
+	timer.Reset(time.Second)
+	timer.Stop()
+	c.Advance(time.Second)
+	if fired() {
+		t.Error("fired once stopped")
+	}
+}
+
+func TestSessionConnDeadline(t *testing.T) {
+	c := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	conn := newDemux(nil, c).open(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	defer conn.Close()
+	conn.SetReadDeadline(c.Now().Add(time.Second))
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, 512))
+		errc <- err
+	}()
+	c.BlockUntil(1)
+	c.Advance(999 * time.Millisecond)
+	select {
+	case err := <-errc:
+		t.Fatalf("read ended before the deadline: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Advance(time.Millisecond)
+	if err := <-errc; !isTimeout(err) {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
+
+func TestClientBackoffSchedule(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := &Loopback{Clock: clock}
+	server, err := l.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	c := &Client{Transport: l, Clock: clock, Timeout: time.Second, Retries: 3}
+	errc := make(chan error, 1)
+	go func() { errc <- c.Get(context.Background(), "server", "file", io.Discard) }()
+
+	// the RRQ is sent again after 1, 2 and 4 seconds, plus jitter
+	buf := make([]byte, 512)
+	var last time.Time
+	for i, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second} {
+		if _, _, err := server.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+		now := clock.Now()
+		if i > 0 {
+			if gap := now.Sub(last); gap < want || gap > want+want/10 {
+				t.Errorf("RRQ %d: sent after %v, want %v", i, gap, want)
+			}
+		}
+		last = now
+		// wait for the client to wait for a reply
+		clock.BlockUntil(1)
+		next, _ := clock.Next()
+		clock.Advance(next.Sub(now))
+	}
+	if err := <-errc; !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestClientRequestRetries(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := &Loopback{Clock: clock}
+	server, err := l.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	// with options, and without as by default
+	for _, c := range []*Client{
+		{Transport: l, Clock: clock, Timeout: time.Second, Retries: 5, RequestRetries: 1},
+		{Transport: l, Clock: clock, Retries: 5, RequestRetries: 1},
+	} {
+		errc := make(chan error, 1)
+		go func() { errc <- c.Get(context.Background(), "server", "file", io.Discard) }()
+
+		// the RRQ is sent twice, not six times
+		buf := make([]byte, 512)
+		for range 2 {
+			if _, _, err := server.ReadFrom(buf); err != nil {
+				t.Fatal(err)
+			}
+			clock.BlockUntil(1)
+			next, _ := clock.Next()
+			clock.Advance(next.Sub(clock.Now()))
+		}
+		if err := <-errc; !errors.Is(err, ErrTimeout) {
+			t.Errorf("timeout %v: got %v, want ErrTimeout", c.Timeout, err)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+// Command tftp transfers files with a TFTP server.
+//
+// Usage:
+//
+//	tftp [flags] [host[:port]] [get remote [local] | put local [remote]]
+//
+// With a command, the file is transferred and tftp exits. A local file of
+// "-" is standard output for get and standard input for put, and local
+// defaults to the base name of remote. IPv6 addresses may be given without
+// brackets if no port is given.
+//
+// Without a command, commands are read from standard input as with BSD
+// tftp, run help for the list.
+//
+// The flags are:
+//
+//	-m mode
+//		transfer mode, octet or netascii
+//	-b size
+//		block size to negotiate
+//	-w size
+//		window size to negotiate
+//	-t duration
+//		retransmission interval
+//	-r count
+//		retransmissions before giving up
+//	-v
+//		print transfer statistics
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+func main() {
+	var (
+		mode    = flag.String("m", "octet", "transfer `mode`, octet or netascii")
+		blksize = flag.Int("b", 0, "block `size` to negotiate")
+		window  = flag.Int("w", 0, "window `size` to negotiate")
+		timeout = flag.Duration("t", 0, "retransmission interval")
+		retries = flag.Int("r", 0, "retransmissions before giving up")
+		verbose = flag.Bool("v", false, "print transfer statistics")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: tftp [flags] [host[:port]] [get remote [local] | put local [remote]]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	s := newSession(os.Stdout)
+	s.stdout = os.Stdout
+	s.verbose = *verbose
+	s.client.Newline = tftp.NativeNewline
+	s.client.BlockSize = *blksize
+	s.client.WindowSize = *window
+	s.client.Timeout = *timeout
+	s.client.Retries = *retries
+	if err := s.exec([]string{"mode", *mode}); err != nil {
+		fatal(err)
+	}
+	args := flag.Args()
+	if len(args) > 0 {
+		s.host = args[0]
+	}
+	if len(args) > 1 {
+		// messages would mix with a file written to standard output
+		s.out = os.Stderr
+		s.stdin = os.Stdin
+		if err := s.exec(args[1:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	s.interactive(os.Stdin)
+}
+
+// fatal prints err and exits
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "tftp:", err)
+	os.Exit(1)
+}
+
+// session is the state of the client between commands
+type session struct {
+	client  tftp.Client
+	host    string    // server address, with port if not 69
+	verbose bool      // print transfer statistics
+	out     io.Writer // messages
+	stdout  io.Writer // file written to "-"
+	stdin   io.Reader // file read from "-", nil if not allowed
+	stats   tftp.Stats
+}
+
+// newSession returns a session printing to out
+func newSession(out io.Writer) *session {
+	s := &session{out: out}
+	s.client.OnComplete = func(st tftp.Stats) { s.stats = st }
+	return s
+}
+
+// command is a command of the interactive mode
+type command struct {
+	name  string
+	usage string
+	run   func(s *session, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"connect", "connect host [port]: set the server", (*session).connect},
+		{"mode", "mode [octet|netascii]: set or show the transfer mode", (*session).mode},
+		{"binary", "binary: set mode octet", func(s *session, _ []string) error { return s.mode([]string{"octet"}) }},
+		{"ascii", "ascii: set mode netascii", func(s *session, _ []string) error { return s.mode([]string{"netascii"}) }},
+		{"get", "get remote [local] | get file...: receive files", (*session).get},
+		{"put", "put local [remote] | put file... dir: send files", (*session).put},
+		{"blksize", "blksize [size]: set or show the block size to negotiate", intSetting("block size", func(s *session) *int { return &s.client.BlockSize })},
+		{"windowsize", "windowsize [size]: set or show the window size to negotiate", intSetting("window size", func(s *session) *int { return &s.client.WindowSize })},
+		{"rexmt", "rexmt [seconds]: set or show the retransmission interval", (*session).rexmt},
+		{"timeout", "timeout [seconds]: set or show the retransmission interval", (*session).rexmt},
+		{"retries", "retries [count]: set or show the retransmissions before giving up", intSetting("retries", func(s *session) *int { return &s.client.Retries })},
+		{"verbose", "verbose: toggle printing transfer statistics", (*session).toggleVerbose},
+		{"status", "status: show the current settings", (*session).status},
+		{"help", "help: list the commands", (*session).help},
+		{"?", "?: list the commands", (*session).help},
+	}
+}
+
+// exec runs the command args[0] with arguments args[1:]
+func (s *session) exec(args []string) error {
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(s, args[1:])
+		}
+	}
+	return fmt.Errorf("unknown command %q, try help", args[0])
+}
+
+// interactive runs the commands read from r until EOF or quit
+func (s *session) interactive(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(s.out, "tftp> ")
+		if !sc.Scan() {
+			fmt.Fprintln(s.out)
+			return
+		}
+		args := strings.Fields(sc.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "q" || args[0] == "exit" {
+			return
+		}
+		if err := s.exec(args); err != nil {
+			fmt.Fprintln(s.out, err)
+		}
+	}
+}
+
+// connect sets the server address
+func (s *session) connect(args []string) error {
+	switch len(args) {
+	case 1:
+		s.host = args[0]
+	case 2:
+		if _, err := strconv.ParseUint(args[1], 10, 16); err != nil {
+			return fmt.Errorf("invalid port %q", args[1])
+		}
+		s.host = net.JoinHostPort(strings.Trim(args[0], "[]"), args[1])
+	default:
+		return errors.New("usage: connect host [port]")
+	}
+	return nil
+}
+
+// mode sets or shows the transfer mode
+func (s *session) mode(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(s.out, "Using %s mode to transfer files.\n", modeName(s.client.Mode))
+		return nil
+	}
+	switch strings.ToLower(args[0]) {
+	case "octet", "binary":
+		s.client.Mode = tftp.Octet
+	case "netascii", "ascii":
+		s.client.Mode = tftp.Netascii
+	default:
+		return fmt.Errorf("unsupported mode %q", args[0])
+	}
+	return nil
+}
+
+// modeName returns the wire name of mode
+func modeName(mode tftp.Mode) string {
+	if mode == tftp.Netascii {
+		return "netascii"
+	}
+	return "octet"
+}
+
+// intSetting returns a command setting or showing the integer setting
+// name of a session
+func intSetting(name string, field func(s *session) *int) func(s *session, args []string) error {
+	return func(s *session, args []string) error {
+		p := field(s)
+		if len(args) == 0 {
+			fmt.Fprintf(s.out, "%s: %d\n", name, *p)
+			return nil
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q", name, args[0])
+		}
+		*p = n
+		return nil
+	}
+}
+
+// rexmt sets or shows the retransmission interval
+func (s *session) rexmt(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(s.out, "retransmission interval: %v\n", s.client.Timeout)
+		return nil
+	}
+	n, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid interval %q", args[0])
+	}
+	s.client.Timeout = time.Duration(n * float64(time.Second))
+	return nil
+}
+
+// toggleVerbose toggles printing transfer statistics
+func (s *session) toggleVerbose(args []string) error {
+	s.verbose = !s.verbose
+	fmt.Fprintf(s.out, "Verbose mode %s.\n", onOff(s.verbose))
+	return nil
+}
+
+// onOff returns on or off for b
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// status shows the current settings
+func (s *session) status(args []string) error {
+	if s.host == "" {
+		fmt.Fprintln(s.out, "Not connected.")
+	} else {
+		fmt.Fprintf(s.out, "Connected to %s.\n", s.host)
+	}
+	fmt.Fprintf(s.out, "Mode: %s Verbose: %s\n", modeName(s.client.Mode), onOff(s.verbose))
+	fmt.Fprintf(s.out, "Block size: %d Window size: %d\n", s.client.BlockSize, s.client.WindowSize)
+	fmt.Fprintf(s.out, "Retransmission interval: %v Retries: %d\n", s.client.Timeout, s.client.Retries)
+	return nil
+}
+
+// help lists the commands
+func (s *session) help(args []string) error {
+	for _, c := range commands {
+		fmt.Fprintln(s.out, c.usage)
+	}
+	fmt.Fprintln(s.out, "quit: exit tftp")
+	return nil
+}
+
+// get receives remote files
+func (s *session) get(args []string) error {
+	switch len(args) {
+	case 0:
+		return errors.New("usage: get remote [local] | get file...")
+	case 2:
+		return s.getFile(args[0], args[1])
+	}
+	for _, remote := range args {
+		if err := s.getFile(remote, path.Base(remote)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFile receives remote into the file local, standard output if "-".
+// The file is received into a temporary file replacing local once the
+// transfer succeeds, so that a failed transfer leaves local as it was.
+func (s *session) getFile(remote, local string) error {
+	if s.host == "" {
+		return errors.New("not connected, use connect")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if local == "-" {
+		if s.stdout == nil {
+			return errors.New("standard output is not available")
+		}
+		return s.report(s.client.Get(ctx, s.host, remote, s.stdout))
+	}
+	if s.verbose {
+		fmt.Fprintf(s.out, "getting from %s:%s to %s [%s]\n", s.host, remote, local, modeName(s.client.Mode))
+	}
+	perm := os.FileMode(0o644)
+	if fi, err := os.Stat(local); err == nil {
+		if !fi.Mode().IsRegular() {
+			// a device such as /dev/null is written in place
+			f, err := os.OpenFile(local, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			err = s.client.Get(ctx, s.host, remote, f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return s.report(err)
+		}
+		perm = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".*")
+	if err != nil {
+		return err
+	}
+	err = s.client.Get(ctx, s.host, remote, f)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), local)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return s.report(err)
+}
+
+// put sends local files
+func (s *session) put(args []string) error {
+	switch len(args) {
+	case 0:
+		return errors.New("usage: put local [remote] | put file... dir")
+	case 1:
+		return s.putFile(args[0], filepath.Base(args[0]))
+	case 2:
+		return s.putFile(args[0], args[1])
+	}
+	dir := args[len(args)-1]
+	for _, local := range args[:len(args)-1] {
+		if err := s.putFile(local, path.Join(dir, filepath.Base(local))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putFile sends the file local, standard input if "-", to remote
+func (s *session) putFile(local, remote string) error {
+	if s.host == "" {
+		return errors.New("not connected, use connect")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if local == "-" {
+		if s.stdin == nil {
+			return errors.New("standard input is not available")
+		}
+		return s.report(s.client.Put(ctx, s.host, remote, s.stdin))
+	}
+	if s.verbose {
+		fmt.Fprintf(s.out, "putting %s to %s:%s [%s]\n", local, s.host, remote, modeName(s.client.Mode))
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.report(s.client.Put(ctx, s.host, remote, f))
+}
+
+// report prints the statistics of the last transfer if verbose, and
+// returns err
+func (s *session) report(err error) error {
+	if err != nil || !s.verbose {
+		return err
+	}
+	verb := "Received"
+	if s.stats.Op == tftp.WRQ {
+		verb = "Sent"
+	}
+	secs := s.stats.Duration.Seconds()
+	fmt.Fprintf(s.out, "%s %d bytes in %.1f seconds [%.0f bit/s]\n", verb, s.stats.Bytes, secs, float64(s.stats.Bytes*8)/max(secs, 1e-9))
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// startServer starts a server of files held in memory on a loopback port
+func startServer(t *testing.T) (string, *tftp.MemFS) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	m := &tftp.MemFS{Writable: true}
+	go (&tftp.Server{Handler: m}).Serve(conn)
+	return conn.LocalAddr().String(), m
+}
+
+func TestSession(t *testing.T) {
+	addr, m := startServer(t)
+	m.Set("remote", []byte("line\n"))
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "kept"), []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(addr)
+
+	var out, stdout bytes.Buffer
+	s := newSession(&out)
+	s.stdout = &stdout
+	s.stdin = strings.NewReader("from stdin")
+	commands := "connect " + host + " " + port + "\n" +
+		"blksize 1024\n" +
+		"verbose\n" +
+		"get remote " + filepath.Join(dir, "local") + "\n" +
+		"put " + filepath.Join(dir, "local") + " copy\n" +
+		"get missing " + filepath.Join(dir, "missing") + "\n" +
+		"get missing " + filepath.Join(dir, "kept") + "\n" +
+		"ascii\n" +
+		"get remote -\n" +
+		"frobnicate\n" +
+		"status\n" +
+		"quit\n" +
+		"get never\n"
+	s.interactive(strings.NewReader(commands))
+
+	if got, err := os.ReadFile(filepath.Join(dir, "local")); err != nil || string(got) != "line\n" {
+		t.Errorf("get: got %q, %v", got, err)
+	}
+	if got, _ := m.Get("copy"); string(got) != "line\n" {
+		t.Errorf("put: got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing")); err == nil {
+		t.Error("failed get left a file")
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "kept")); err != nil || string(got) != "old" {
+		t.Errorf("failed get over a file: got %q, %v", got, err)
+	}
+	// no temporary file is left
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("got files %v, want local and kept", entries)
+	}
+	if stdout.String() != "line\n" {
+		t.Errorf("get to standard output: got %q", stdout.String())
+	}
+	for _, want := range []string{
+		"Received 5 bytes",
+		"Sent 5 bytes",
+		"(FileNotFound)",
+		`unknown command "frobnicate"`,
+		"Connected to " + addr,
+		"Mode: netascii Verbose: on",
+		"Block size: 1024",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	// standard input is read in command mode only
+	s.stdin = nil
+	if err := s.exec([]string{"put", "-", "stdin"}); err == nil {
+		t.Error("put from unavailable standard input")
+	}
+	s.stdin = strings.NewReader("from stdin")
+	if err := s.exec([]string{"put", "-", "stdin"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := m.Get("stdin"); string(got) != "from stdin" {
+		t.Errorf("put from standard input: got %q", got)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	s := newSession(&bytes.Buffer{})
+	for _, test := range []struct {
+		args []string
+		host string
+	}{
+		{[]string{"server"}, "server"},
+		{[]string{"server", "1069"}, "server:1069"},
+		{[]string{"::1"}, "::1"},
+		{[]string{"::1", "1069"}, "[::1]:1069"},
+		{[]string{"[::1]", "1069"}, "[::1]:1069"},
+	} {
+		if err := s.connect(test.args); err != nil || s.host != test.host {
+			t.Errorf("%q: got %q, %v, want %q", test.args, s.host, err, test.host)
+		}
+	}
+	if err := s.connect([]string{"server", "port"}); err == nil {
+		t.Error("invalid port accepted")
+	}
+}
@@ -0,0 +1,364 @@
+// Command tftpd serves the files of a directory over TFTP.
+//
+// Usage:
+//
+//	tftpd [flags]
+//
+// Files are served from the tree of the root directory, without following
+// symbolic links out of it, and uploads are refused unless -write is set.
+// SIGINT and SIGTERM stop accepting requests and wait for the transfers in
+// progress for the -grace period.
+//
+// With -chroot, tftpd changes its root directory to the directory served
+// once the listening sockets are bound, so that no other file is
+// reachable, and opens it again as / on SIGHUP. A -config file is then
+// read again at its path within the root directory.
+//
+// With -user, tftpd switches to the unprivileged account once the
+// listening sockets are bound, as to port 69, so that files are served
+// and uploads written with its permissions. The account is looked up at
+// start, not in the account database of a -chroot directory. The root directory is opened
+// again on SIGHUP with these permissions.
+//
+// Run as a systemd service of Type=notify, tftpd notifies readiness once
+// listening, and pings the watchdog of WatchdogSec while it receives and
+// serves requests normally.
+//
+// SIGHUP reads the -config file again and opens the root directory again,
+// so that a root directory replaced by a deploy is served. The requests
+// received afterwards are served with the new configuration, the transfers
+// in progress are not interrupted. The listening addresses and sockets
+// change at restart only.
+//
+// The flags are:
+//
+//	-config file
+//		file of further flags, one or more per line, lines starting
+//		with # being comments; flags given on the command line take
+//		precedence
+//	-root dir
+//		directory to serve, the current directory by default
+//	-listen addrs
+//		comma separated UDP addresses to listen on, :69 by default,
+//		or "dual" for separate IPv4 and IPv6 sockets on port 69. An
+//		address whose host names a network interface, such as
+//		eth1:69, listens on each address of the interface.
+//	-write
+//		accept uploads
+//	-resume
+//		resume downloads of clients of this package from a byte
+//		offset
+//	-max-file-size bytes
+//		largest file sent, larger files being refused, unlimited
+//		if zero
+//	-blksize size
+//		largest negotiated block size
+//	-windowsize size
+//		largest negotiated window size
+//	-timeout duration
+//		retransmission interval
+//	-retries count
+//		retransmissions before giving up
+//	-handshake-timeout duration
+//		longest wait for the first reply of a client
+//	-idle-timeout duration
+//		longest time a transfer makes no progress
+//	-max-transfers count
+//		transfers served at once, unlimited if zero
+//	-reject
+//		refuse requests beyond -max-transfers instead of queueing them
+//	-workers count
+//		goroutines serving requests, one per request if zero
+//	-reuseport count
+//		sockets opened on each address with SO_REUSEPORT, each
+//		served by its own workers
+//	-log level
+//		log level, debug, info, warn or error
+//	-log-format format
+//		log format, text or json
+//	-grace duration
+//		time to wait for transfers at shutdown
+//	-chroot
+//		change the root directory to the directory served once
+//		listening
+//	-user name
+//		account, by name or ID, to switch to once listening
+//	-group name
+//		group, by name or ID, to switch to with -user, the primary
+//		group of the account by default
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// config is the configuration given by the flags
+type config struct {
+	file         string
+	root         string
+	listen       string
+	write        bool
+	resume       bool
+	maxFileSize  int64
+	blksize      int
+	windowsize   int
+	timeout      time.Duration
+	retries      int
+	handshake    time.Duration
+	idle         time.Duration
+	maxTransfers int
+	reject       bool
+	workers      int
+	reusePort    int
+	logLevel     slog.Level
+	logFormat    string
+	grace        time.Duration
+	chroot       bool
+	user         string
+	group        string
+}
+
+// parseFlags returns the configuration given by args, printing errors and
+// usage to output
+func parseFlags(args []string, output io.Writer) (*config, error) {
+	c := &config{}
+	fs := flag.NewFlagSet("tftpd", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&c.file, "config", "", "`file` of further flags")
+	fs.StringVar(&c.root, "root", ".", "`dir`ectory to serve")
+	fs.StringVar(&c.listen, "listen", ":69", "comma separated UDP `addr`esses or interface:port to listen on, or dual for IPv4 and IPv6 sockets on port 69")
+	fs.BoolVar(&c.write, "write", false, "accept uploads")
+	fs.BoolVar(&c.resume, "resume", false, "resume downloads from a byte offset")
+	fs.Int64Var(&c.maxFileSize, "max-file-size", 0, "largest file sent in `bytes`, unlimited if zero")
+	fs.IntVar(&c.blksize, "blksize", 0, "largest negotiated block `size`")
+	fs.IntVar(&c.windowsize, "windowsize", 0, "largest negotiated window `size`")
+	fs.DurationVar(&c.timeout, "timeout", 0, "retransmission interval")
+	fs.IntVar(&c.retries, "retries", 0, "retransmissions before giving up")
+	fs.DurationVar(&c.handshake, "handshake-timeout", 0, "longest wait for the first reply of a client, unlimited if zero")
+	fs.DurationVar(&c.idle, "idle-timeout", 0, "longest time a transfer makes no progress, unlimited if zero")
+	fs.IntVar(&c.maxTransfers, "max-transfers", 0, "transfers served at once, unlimited if zero")
+	fs.BoolVar(&c.reject, "reject", false, "refuse requests beyond -max-transfers instead of queueing them")
+	fs.IntVar(&c.workers, "workers", 0, "goroutines serving requests, one per request if zero")
+	fs.IntVar(&c.reusePort, "reuseport", 0, "sockets opened on each address with SO_REUSEPORT")
+	fs.TextVar(&c.logLevel, "log", slog.LevelInfo, "log `level`, debug, info, warn or error")
+	fs.StringVar(&c.logFormat, "log-format", "text", "log `format`, text or json")
+	fs.DurationVar(&c.grace, "grace", 10*time.Second, "time to wait for transfers at shutdown")
+	fs.BoolVar(&c.chroot, "chroot", false, "change the root directory to the directory served once listening")
+	fs.StringVar(&c.user, "user", "", "account `name` to switch to once listening")
+	fs.StringVar(&c.group, "group", "", "group `name` to switch to with -user, the primary group of the account by default")
+	fs.Usage = func() {
+		fmt.Fprintln(output, "usage: tftpd [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if c.logFormat != "text" && c.logFormat != "json" {
+		return nil, fmt.Errorf("invalid log format %q", c.logFormat)
+	}
+	if c.group != "" && c.user == "" {
+		return nil, errors.New("-group requires -user")
+	}
+	return c, nil
+}
+
+// loadConfig returns the configuration given by args and the flags of the
+// config file they name, printing errors and usage to output
+func loadConfig(args []string, output io.Writer) (*config, error) {
+	c, err := parseFlags(args, output)
+	if err != nil || c.file == "" {
+		return c, err
+	}
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return nil, err
+	}
+	var fileArgs []string
+	for line := range strings.Lines(string(data)) {
+		if line = strings.TrimSpace(line); !strings.HasPrefix(line, "#") {
+			fileArgs = append(fileArgs, strings.Fields(line)...)
+		}
+	}
+	file := c.file
+	c, err = parseFlags(append(fileArgs, args...), output)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return c, nil
+}
+
+// server returns the server for the configuration, logging to w, and the
+// root directory it serves
+func (c *config) server(w io.Writer) (*tftp.Server, *os.Root, error) {
+	root, err := os.OpenRoot(c.root)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &slog.HandlerOptions{Level: c.logLevel}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if c.logFormat == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	s := &tftp.Server{
+		Handler:                &tftp.Dir{Root: root, Writable: c.write},
+		MaxBlockSize:           c.blksize,
+		MaxWindowSize:          c.windowsize,
+		Timeout:                c.timeout,
+		Retries:                c.retries,
+		Newline:                tftp.NativeNewline,
+		Resume:                 c.resume,
+		MaxFileSize:            c.maxFileSize,
+		HandshakeTimeout:       c.handshake,
+		IdleTimeout:            c.idle,
+		MaxConcurrentTransfers: c.maxTransfers,
+		Workers:                c.workers,
+		QueueSize:              c.workers,
+		ReusePort:              c.reusePort,
+		Logger:                 slog.New(h),
+	}
+	if c.reject {
+		s.Overflow = tftp.OverflowReject
+	}
+	return s, root, nil
+}
+
+// confine changes the root directory of the process to dir, unless
+// empty, then drops its privileges to cr, unless nil
+func confine(dir string, cr *credentials) error {
+	if dir != "" {
+		if err := chroot(dir); err != nil {
+			return fmt.Errorf("chroot: %w", err)
+		}
+	}
+	if cr != nil {
+		if err := dropPrivileges(cr); err != nil {
+			return fmt.Errorf("dropping privileges: %w", err)
+		}
+	}
+	return nil
+}
+
+func main() {
+	c, err := loadConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tftpd:", err)
+		os.Exit(2)
+	}
+	s, root, err := c.server(os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tftpd:", err)
+		os.Exit(1)
+	}
+	defer func() { root.Close() }()
+	log := s.Logger
+	var cr *credentials
+	if c.user != "" {
+		// the account database of the served tree is not trusted
+		if cr, err = lookupCredentials(c.user, c.group); err != nil {
+			fmt.Fprintln(os.Stderr, "tftpd: dropping privileges:", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var ready sync.Once
+	s.OnListen = func(net.Addr) {
+		ready.Do(func() {
+			// all listening sockets are bound once the first is served
+			dir := ""
+			if c.chroot {
+				dir = c.root
+			}
+			if err := confine(dir, cr); err != nil {
+				fmt.Fprintln(os.Stderr, "tftpd:", err)
+				os.Exit(1)
+			}
+			if c.chroot {
+				log.Info("changed root directory", "root", c.root)
+			}
+			if cr != nil {
+				log.Info("dropped privileges", "user", c.user, "group", c.group)
+			}
+			if err := notify("READY=1"); err != nil {
+				log.Error("notify failed", "err", err)
+			}
+		})
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go watchdog(ctx, s, interval, log)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if strings.EqualFold(c.listen, "dual") {
+			errc <- s.ListenAndServeDualStack("")
+			return
+		}
+		errc <- s.ListenAndServeAll(strings.Split(c.listen, ",")...)
+	}()
+	log.Info("serving", "root", c.root, "listen", c.listen, "write", c.write)
+	for done := false; !done; {
+		select {
+		case err = <-errc:
+			done = true
+		case <-hup:
+			nc, err := loadConfig(os.Args[1:], io.Discard)
+			if err != nil {
+				log.Error("reload failed", "err", err)
+				continue
+			}
+			if c.chroot {
+				// the root directory changes at restart only
+				nc.root = "/"
+			}
+			ns, nroot, err := nc.server(os.Stderr)
+			if err != nil {
+				log.Error("reload failed", "err", err)
+				continue
+			}
+			// the requests in progress keep the previous root
+			drained := s.Reload(ns)
+			go func(root *os.Root) {
+				<-drained
+				root.Close()
+			}(root)
+			root, log = nroot, ns.Logger
+			log.Info("reloaded", "root", nc.root, "write", nc.write)
+		case <-ctx.Done():
+			log.Info("shutting down")
+			notify("STOPPING=1")
+			sctx, cancel := context.WithTimeout(context.Background(), c.grace)
+			err = s.Shutdown(sctx)
+			cancel()
+			<-errc
+			done = true
+		}
+	}
+	if err != nil && !errors.Is(err, tftp.ErrServerClosed) {
+		log.Error("serving failed", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+func TestParseFlags(t *testing.T) {
+	c, err := parseFlags([]string{"-root", "/srv/tftp", "-write", "-resume", "-max-file-size", "1048576", "-blksize", "1468", "-log", "debug", "-log-format", "json"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.root != "/srv/tftp" || !c.write || !c.resume || c.maxFileSize != 1048576 || c.blksize != 1468 || c.logLevel != slog.LevelDebug || c.logFormat != "json" {
+		t.Errorf("got %+v", c)
+	}
+	for _, args := range [][]string{
+		{"-log", "loud"},
+		{"-log-format", "xml"},
+		{"extra"},
+		{"-group", "nogroup"},
+	} {
+		if _, err := parseFlags(args, io.Discard); err == nil {
+			t.Errorf("%q: accepted", args)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tftpd.conf")
+	conf := "# provisioning\n-root /srv/tftp\n-blksize 1468 -write\n"
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig([]string{"-config", file, "-blksize", "512"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the command line takes precedence
+	if c.root != "/srv/tftp" || !c.write || c.blksize != 512 {
+		t.Errorf("got %+v", c)
+	}
+	if err := os.WriteFile(file, []byte("-blksize large\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig([]string{"-config", file}, io.Discard); err == nil {
+		t.Error("invalid config file accepted")
+	}
+	if _, err := loadConfig([]string{"-config", file + ".missing"}, io.Discard); err == nil {
+		t.Error("missing config file accepted")
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseFlags([]string{"-root", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	s, root, err := c.server(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.Serve(conn)
+	addr := conn.LocalAddr().String()
+
+	var got bytes.Buffer
+	client := &tftp.Client{}
+	if err := client.Get(context.Background(), addr, "file", &got); err != nil || got.String() != "data" {
+		t.Errorf("get: got %q, %v", got.String(), err)
+	}
+	// read-only without -write
+	if err := client.Put(context.Background(), addr, "upload", strings.NewReader("data")); err == nil {
+		t.Error("upload accepted")
+	}
+	// the transfers are logged once done
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), "filename=file") {
+		t.Errorf("request not logged:\n%s", log.String())
+	}
+}
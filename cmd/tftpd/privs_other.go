@@ -0,0 +1,26 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// credentials are the user and group IDs the process switches to
+type credentials struct{}
+
+// lookupCredentials fails, user and group IDs are not supported on this
+// system
+func lookupCredentials(name, group string) (*credentials, error) {
+	return nil, errors.New("dropping privileges not supported")
+}
+
+// dropPrivileges fails, user and group IDs are not supported on this
+// system
+func dropPrivileges(cr *credentials) error {
+	return errors.New("dropping privileges not supported")
+}
+
+// chroot fails, changing the root directory is not supported on this
+// system
+func chroot(dir string) error {
+	return errors.New("chroot not supported")
+}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// credentials are the user and group IDs the process switches to
+type credentials struct {
+	uid, gid int
+}
+
+// lookupCredentials returns the user ID of the account name, by name or
+// ID, and the group ID of group, the primary group of the account if
+// empty. They must be looked up before chroot, the account database of
+// the new root directory being the served tree.
+func lookupCredentials(name, group string) (*credentials, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, err
+		}
+	}
+	gid := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, err
+			}
+		}
+		gid = g.Gid
+	}
+	cr := &credentials{}
+	if cr.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, err
+	}
+	if cr.gid, err = strconv.Atoi(gid); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// dropPrivileges sets the user and group IDs of the process to cr, with
+// no supplementary groups. It fails if root privileges can be regained.
+func dropPrivileges(cr *credentials) error {
+	if err := syscall.Setgroups(nil); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(cr.gid); err != nil {
+		return err
+	}
+	if err := syscall.Setuid(cr.uid); err != nil {
+		return err
+	}
+	if cr.uid != 0 && syscall.Setuid(0) == nil {
+		return errors.New("root privileges regained after dropping them")
+	}
+	return nil
+}
+
+// chroot changes the root directory of the process to dir, so that no
+// file outside of it is reachable even by a handler with a path traversal
+// bug
+func chroot(dir string) error {
+	if err := syscall.Chroot(dir); err != nil {
+		return err
+	}
+	return os.Chdir("/")
+}
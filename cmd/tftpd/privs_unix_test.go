@@ -0,0 +1,123 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDropPrivileges(t *testing.T) {
+	if name := os.Getenv("TFTPD_TEST_DROP"); name != "" {
+		// in the child process
+		cr, err := lookupCredentials(name, "")
+		if err == nil {
+			err = dropPrivileges(cr)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		groups, _ := os.Getgroups()
+		fmt.Println(os.Getuid(), os.Getgid(), len(groups))
+		os.Exit(0)
+	}
+	if _, err := lookupCredentials("no-such-account-tftpd", ""); err == nil {
+		t.Error("looked up a missing account")
+	}
+	if os.Getuid() != 0 {
+		t.Skip("not root")
+	}
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	// the privileges of the test process are kept
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivileges$")
+	cmd.Env = append(os.Environ(), "TFTPD_TEST_DROP=nobody")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), u.Uid+" "+u.Gid+" 0"; got != want {
+		t.Errorf("got uid, gid and groups %q, want %q", got, want)
+	}
+}
+
+func TestChroot(t *testing.T) {
+	if dir := os.Getenv("TFTPD_TEST_CHROOT"); dir != "" {
+		// in the child process
+		if err := chroot(dir); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		wd, _ := os.Getwd()
+		b, err := os.ReadFile("/marker")
+		fmt.Println(wd, string(b), err)
+		os.Exit(0)
+	}
+	if os.Getuid() != 0 {
+		t.Skip("not root")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "marker"), []byte("inside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestChroot$")
+	cmd.Env = append(os.Environ(), "TFTPD_TEST_CHROOT="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "/ inside <nil>" {
+		t.Errorf("got %q, want the root directory changed", got)
+	}
+}
+
+func TestChrootDropPrivileges(t *testing.T) {
+	if dir := os.Getenv("TFTPD_TEST_CHROOT_DROP"); dir != "" {
+		// in the child process, as main
+		cr, err := lookupCredentials("nobody", "")
+		if err == nil {
+			err = confine(dir, cr)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(os.Getuid(), os.Getgid())
+		os.Exit(0)
+	}
+	if os.Getuid() != 0 {
+		t.Skip("not root")
+	}
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	// an account database uploaded to the served tree maps nobody to root
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "etc", "passwd"), []byte("nobody:x:0:0::/:/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "etc", "group"), []byte("nogroup:x:0:\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestChrootDropPrivileges$")
+	cmd.Env = append(os.Environ(), "TFTPD_TEST_CHROOT_DROP="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), u.Uid+" "+u.Gid; got != want {
+		t.Errorf("got uid and gid %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// notify sends state, such as READY=1, to the service manager listening
+// on $NOTIFY_SOCKET as sd_notify does, if tftpd runs as a systemd service
+// of Type=notify
+func notify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	if name[0] == '@' {
+		// abstract socket
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the interval within which the service manager
+// expects watchdog pings from tftpd, zero if it expects none
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdog pings the service manager every half interval while s is
+// healthy, until ctx is done, so that a wedged server is restarted
+func watchdog(ctx context.Context, s *tftp.Server, interval time.Duration, log *slog.Logger) {
+	tick := time.NewTicker(interval / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if !s.Healthy(interval / 2) {
+			log.Warn("unhealthy, watchdog not pinged")
+			continue
+		}
+		if err := notify("WATCHDOG=1"); err != nil {
+			log.Error("watchdog ping failed", "err", err)
+		}
+	}
+}
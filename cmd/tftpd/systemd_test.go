@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// listenNotify listens for notifications as the service manager does
+func listenNotify(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// receive returns the next notification received on conn
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := notify("READY=1"); err != nil {
+		t.Errorf("without a service manager: %v", err)
+	}
+	conn := listenNotify(t)
+	if err := notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, conn); got != "READY=1" {
+		t.Errorf("got %q, want READY=1", got)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if d := watchdogInterval(); d != 0 {
+		t.Errorf("without a watchdog: got %v", d)
+	}
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if d := watchdogInterval(); d != 0 {
+		t.Errorf("watchdog of another process: got %v", d)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d := watchdogInterval(); d != 100*time.Millisecond {
+		t.Errorf("got %v, want 100ms", d)
+	}
+
+	conn := listenNotify(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &tftp.Server{Handler: &tftp.MemFS{}}
+	go s.Serve(pc)
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchdog(ctx, s, 100*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for range 2 {
+		if got := receive(t, conn); got != "WATCHDOG=1" {
+			t.Errorf("got %q, want WATCHDOG=1", got)
+		}
+	}
+}
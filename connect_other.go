@@ -0,0 +1,14 @@
+//go:build !unix
+
+package tftp
+
+import (
+	"errors"
+	"net"
+)
+
+// connect fails, connecting a listening conn is not supported on this
+// system
+func connect(conn *net.UDPConn, peer net.Addr) error {
+	return errors.New("tftp: connect not supported")
+}
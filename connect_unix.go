@@ -0,0 +1,49 @@
+//go:build unix
+
+package tftp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// connect connects conn to peer, the kernel then dropping datagrams from
+// other addresses and reporting ICMP errors for peer on reads
+func connect(conn *net.UDPConn, peer net.Addr) error {
+	addr, ok := peer.(*net.UDPAddr)
+	if !ok {
+		return errors.New("tftp: not a UDP address")
+	}
+	local, _ := conn.LocalAddr().(*net.UDPAddr)
+	var sa syscall.Sockaddr
+	if ip4 := addr.IP.To4(); ip4 != nil && local != nil && local.IP.To4() != nil {
+		sa4 := &syscall.SockaddrInet4{Port: addr.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		// IPv4 peers of dual stack sockets are IPv4-mapped IPv6 addresses
+		sa6 := &syscall.SockaddrInet6{Port: addr.Port}
+		copy(sa6.Addr[:], addr.IP.To16())
+		if addr.Zone != "" {
+			ifi, err := net.InterfaceByName(addr.Zone)
+			if err != nil {
+				return err
+			}
+			sa6.ZoneId = uint32(ifi.Index)
+		}
+		sa = sa6
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.Connect(int(fd), sa)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
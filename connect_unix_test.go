@@ -0,0 +1,80 @@
+//go:build unix
+
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServerConnected(t *testing.T) {
+	completed := make(chan Stats, 1)
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(make([]byte, 2000))), nil
+		},
+		Timeout:    100 * time.Millisecond,
+		Retries:    20,
+		OnComplete: func(st Stats) { completed <- st },
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("test", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	_, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// packets from other ports are dropped by the kernel, without an
+	// UnknownTransferID reply
+	other := dialServer(t)
+	other.WriteTo(newACKPacket(1), peer)
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := other.ReadFrom(buf); err == nil {
+		t.Error("reply to a foreign packet")
+	}
+
+	// the retransmission to the closed client port fails the transfer
+	// with the ICMP error rather than after all retries
+	conn.Close()
+	select {
+	case st := <-completed:
+		if !errors.Is(st.Err, syscall.ECONNREFUSED) {
+			t.Errorf("got %v, want %v", st.Err, syscall.ECONNREFUSED)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("transfer not failed by the ICMP error")
+	}
+}
+
+func TestClientConnected(t *testing.T) {
+	// a dual stack socket connected to an IPv4 peer batches datagrams
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tr := newTransfer(context.Background(), conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 69}, false)
+	tr.lock(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1069})
+	if !tr.connected || tr.batch == nil {
+		t.Errorf("got connected %v, batching %v, want both", tr.connected, tr.batch != nil)
+	}
+
+	addr := startServer(t, &Server{Handler: &MemFS{Writable: true}})
+	c := &Client{WindowSize: 4}
+	err = c.Put(context.Background(), addr.String(), "test", bytes.NewReader(make([]byte, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr.String(), "test", &got); err != nil || got.Len() != 1000 {
+		t.Errorf("got %d bytes, %v, want 1000", got.Len(), err)
+	}
+}
@@ -0,0 +1,32 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the DF bit on the packets sent on conn, for both
+// IPv4 and IPv6 on a dual-stack socket, so that packets larger than the
+// path MTU are dropped with an ICMP error rather than fragmented
+func setDontFragment(conn *net.UDPConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		err4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		err6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+		if err4 != nil && err6 != nil {
+			serr = err4
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("tftp: setting DF: %w", serr)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package tftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSetDontFragment(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		conn, err := net.ListenUDP(network, nil)
+		if err != nil {
+			t.Logf("%s: %v", network, err)
+			continue
+		}
+		defer conn.Close()
+		if err := setDontFragment(conn); err != nil {
+			t.Fatalf("%s: %v", network, err)
+		}
+		level, opt, want := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO
+		if network == "udp6" {
+			level, opt, want = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO
+		}
+		rc, _ := conn.SyscallConn()
+		var got int
+		rc.Control(func(fd uintptr) {
+			got, err = syscall.GetsockoptInt(int(fd), level, opt)
+		})
+		if err != nil || got != want {
+			t.Errorf("%s: got %d, %v, want %d", network, got, err, want)
+		}
+	}
+}
+
+func TestServerDontFragment(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = make([]byte, 5000)
+	s := files.server()
+	s.PathMTU, s.DontFragment = true, true
+	addr := startServer(t, s)
+	c := &Client{Timeout: time.Second, BlockSize: 1468}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
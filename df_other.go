@@ -0,0 +1,13 @@
+//go:build !linux
+
+package tftp
+
+import (
+	"errors"
+	"net"
+)
+
+// setDontFragment fails, setting DF is not supported on this system
+func setDontFragment(conn *net.UDPConn) error {
+	return errors.New("tftp: DF not supported")
+}
@@ -0,0 +1,125 @@
+package tftp
+
+import (
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strconv"
+)
+
+// Dir is a Handler serving the files in the directory tree of Root, named
+// by filenames cleaned with CleanPath. Files outside the tree, also
+// through symbolic links, are not served. Uploads are accepted if Writable
+// is set, they are written to a temporary file that replaces the file only
+// once complete, so that clients never read a partially written file.
+// Uploads over the size limits fail with DiskFull.
+type Dir struct {
+	Root        *os.Root
+	Writable    bool  // accept WRQ uploads
+	MaxFileSize int64 // largest accepted upload, unlimited if zero
+	MaxDirSize  int64 // largest total size of the files in a directory after an upload, unlimited if zero
+
+	// Overwrite reports whether an upload may replace the existing file
+	// name; if nil, any file may be replaced. Refused uploads fail with
+	// FileAlreadyExists.
+	Overwrite func(name string) bool
+}
+
+// ServeTFTP serves a file for a RRQ and stores an upload for a WRQ
+func (d *Dir) ServeTFTP(w ResponseWriter, r *Request) error {
+	if r.Op == WRQ {
+		return d.upload(r)
+	}
+	return serveFile(w, r, d.Root.FS())
+}
+
+// upload stores the file written by the client of a WRQ
+func (d *Dir) upload(r *Request) (err error) {
+	if !d.Writable {
+		return &fs.PathError{Op: "write", Path: r.Filename, Err: fs.ErrPermission}
+	}
+	name, err := CleanPath(r.Filename)
+	if err != nil {
+		return err
+	}
+	overwrite := d.Overwrite == nil || d.Overwrite(name)
+	if _, err := d.Root.Lstat(name); err == nil && !overwrite {
+		return &fs.PathError{Op: "create", Path: r.Filename, Err: fs.ErrExist}
+	}
+	limit, err := d.limit(name)
+	if err != nil {
+		return err
+	}
+	body := r.Body
+	if limit >= 0 {
+		if r.Size > limit {
+			return errTooLarge
+		}
+		body = io.LimitReader(body, limit+1)
+	}
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+".tftp"+strconv.FormatUint(rand.Uint64(), 36))
+	f, err := d.Root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			d.Root.Remove(tmp)
+		}
+	}()
+	n, err := io.Copy(f, body)
+	if err == nil && limit >= 0 && n > limit {
+		err = errTooLarge
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if !overwrite {
+		// linking fails if the file was created during the upload
+		if err = d.Root.Link(tmp, name); err == nil {
+			d.Root.Remove(tmp)
+		}
+		return err
+	}
+	return d.Root.Rename(tmp, name)
+}
+
+// limit returns the largest upload accepted for name, -1 if unlimited
+func (d *Dir) limit(name string) (int64, error) {
+	limit := int64(-1)
+	if d.MaxFileSize > 0 {
+		limit = d.MaxFileSize
+	}
+	if d.MaxDirSize <= 0 {
+		return limit, nil
+	}
+	entries, err := fs.ReadDir(d.Root.FS(), path.Dir(name))
+	if err != nil {
+		return 0, err
+	}
+	free := d.MaxDirSize
+	for _, e := range entries {
+		// the file replaced by the upload does not count
+		if !e.Type().IsRegular() || e.Name() == path.Base(name) {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			free -= fi.Size()
+		}
+	}
+	if free < 0 {
+		free = 0
+	}
+	if limit < 0 || free < limit {
+		limit = free
+	}
+	return limit, nil
+}
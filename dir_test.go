@@ -0,0 +1,113 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "image"), []byte("old image"), 0o644)
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	completed := make(chan Stats, 4)
+	s := &Server{Handler: &Dir{Root: root, Writable: true}, OnComplete: func(st Stats) { completed <- st }}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("new image "), 200)
+	if err := c.Put(ctx, addr, "image", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := c.Get(ctx, addr, "image", &got); err != nil || !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("got %d bytes, %v, want %d bytes", got.Len(), err, len(content))
+	}
+
+	// a failed upload leaves the file and no temporary file behind
+	failing := io.MultiReader(bytes.NewReader(content), iotest.ErrReader(errors.New("read failed")))
+	if err := c.Put(ctx, addr, "image", failing); err == nil {
+		t.Error("failed upload succeeded")
+	}
+	if err := c.Put(ctx, addr, "../outside", bytes.NewReader(content)); err == nil {
+		t.Error("upload outside root accepted")
+	}
+	for range 4 {
+		<-completed
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files, want 1", len(entries))
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "image")); !bytes.Equal(data, content) {
+		t.Errorf("file changed by failed upload: got %d bytes", len(data))
+	}
+
+	protected := &Dir{Root: root, Writable: true, Overwrite: func(string) bool { return false }}
+	addr = startServer(t, &Server{Handler: protected}).String()
+	if err := c.Put(ctx, addr, "image", bytes.NewReader([]byte("replaced"))); !errors.Is(err, ErrFileExists) {
+		t.Errorf("protected: got %v, want ErrFileExists", err)
+	}
+	if err := c.Put(ctx, addr, "new", bytes.NewReader([]byte("new"))); err != nil {
+		t.Errorf("protected: new file: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "new")); string(data) != "new" {
+		t.Errorf("protected: got %q, want %q", data, "new")
+	}
+
+	addr = startServer(t, &Server{Handler: &Dir{Root: root}}).String()
+	if err := c.Put(ctx, addr, "image", bytes.NewReader(nil)); !errors.Is(err, ErrAccessViolation) {
+		t.Errorf("read-only: got %v, want ErrAccessViolation", err)
+	}
+}
+
+func TestDirQuota(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "existing"), make([]byte, 600), 0o644)
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	completed := make(chan Stats, 1)
+	d := &Dir{Root: root, Writable: true, MaxFileSize: 1000, MaxDirSize: 1500}
+	addr := startServer(t, &Server{Handler: d, OnComplete: func(st Stats) { completed <- st }}).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		name string
+		size int
+		ok   bool
+	}{
+		{"file", 1001, false}, // over the file limit
+		{"file", 901, false},  // over the directory limit
+		{"file", 900, true},
+		{"file", 900, true}, // replacing the file
+		{"existing", 1000, false},
+		{"existing", 600, true},
+	} {
+		data := bytes.Repeat([]byte("x"), test.size)
+		// without tsize the limits apply while reading
+		for _, r := range []io.Reader{bytes.NewReader(data), io.MultiReader(bytes.NewReader(data))} {
+			err := c.Put(ctx, addr, test.name, r)
+			<-completed
+			if test.ok && err != nil || !test.ok && !errors.Is(err, &Error{Code: DiskFull}) {
+				t.Errorf("%s %d bytes: got %v", test.name, test.size, err)
+			}
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("got %d files, want 2", len(entries))
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package tftp
+
+import (
+	"errors"
+	"net"
+)
+
+// setDSCP fails, marking packets is not supported on this system
+func setDSCP(conn *net.UDPConn, dscp int) error {
+	return errors.New("tftp: DSCP not supported")
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setDSCP marks the packets sent on conn with dscp, for both IPv4 and
+// IPv6 on a dual-stack socket
+func setDSCP(conn *net.UDPConn, dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("tftp: invalid DSCP %d", dscp)
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		// IPV6_TCLASS fails on IPv4 sockets, IP_TOS marks IPv4 packets on
+		// dual-stack sockets where supported
+		err4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+		err6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+		if err4 != nil && err6 != nil {
+			serr = err4
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("tftp: setting DSCP: %w", serr)
+	}
+	return nil
+}
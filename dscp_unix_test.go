@@ -0,0 +1,58 @@
+//go:build unix
+
+package tftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSetDSCP(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		conn, err := net.ListenUDP(network, nil)
+		if err != nil {
+			t.Logf("%s: %v", network, err)
+			continue
+		}
+		defer conn.Close()
+		if err := setDSCP(conn, 46); err != nil {
+			t.Fatalf("%s: %v", network, err)
+		}
+		level, opt := syscall.IPPROTO_IP, syscall.IP_TOS
+		if network == "udp6" {
+			level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+		}
+		rc, _ := conn.SyscallConn()
+		var got int
+		rc.Control(func(fd uintptr) {
+			got, err = syscall.GetsockoptInt(int(fd), level, opt)
+		})
+		if err != nil || got != 46<<2 {
+			t.Errorf("%s: got %#x, %v, want %#x", network, got, err, 46<<2)
+		}
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := setDSCP(conn, 64); err == nil {
+		t.Error("DSCP 64: got no error")
+	}
+}
+
+func TestServerDSCP(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = []byte("data")
+	s := files.server()
+	s.DSCP = 10
+	addr := startServer(t, s)
+	c := &Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
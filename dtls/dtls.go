@@ -0,0 +1,264 @@
+// Package dtls runs TFTP over DTLS, for the confidentiality and integrity
+// of transfers, for example of firmware to a gateway relaying them to
+// devices speaking plain TFTP. The DTLS sessions, with PSK or certificate
+// authentication, are established by a DTLS implementation such as
+// github.com/pion/dtls, each transfer running over a session of its own.
+//
+// A DTLS record carries at most 16384 bytes, so the block size of
+// transfers must not exceed MaxBlockSize.
+package dtls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+var _ tftp.Transport = (*Transport)(nil)
+
+// MaxBlockSize is the largest block size whose DATA packets fit a DTLS
+// record
+const MaxBlockSize = 16384 - 4
+
+// Addr is the address of the peer of a DTLS session
+type Addr struct {
+	net.Addr // address of the peer on the underlying transport
+}
+
+// Network returns "dtls"
+func (a Addr) Network() string {
+	return "dtls"
+}
+
+// Transport is a tftp.Transport running each transfer of a client over a
+// new DTLS session
+type Transport struct {
+	// Dial establishes a DTLS session with the server at addr, for
+	// example with dtls.Dial of github.com/pion/dtls
+	Dial func(ctx context.Context, addr *net.UDPAddr) (net.Conn, error)
+}
+
+// ResolveAddr returns the address of the server addr, with port 69 if
+// addr has none
+func (t *Transport) ResolveAddr(ctx context.Context, addr string) (net.Addr, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "69")
+	}
+	udp, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return Addr{udp}, nil
+}
+
+// ListenPacket establishes a DTLS session with peer for a transfer
+func (t *Transport) ListenPacket(ctx context.Context, local, peer net.Addr) (net.PacketConn, error) {
+	a, ok := peer.(Addr)
+	if !ok {
+		return nil, errors.New("dtls: not a DTLS address")
+	}
+	udp, ok := a.Addr.(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("dtls: not a UDP address")
+	}
+	c, err := t.Dial(ctx, udp)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionConn{Conn: c, peer: peer}, nil
+}
+
+// sessionConn is the net.PacketConn of a transfer over a DTLS session
+type sessionConn struct {
+	net.Conn
+	peer net.Addr
+}
+
+// ReadFrom reads a packet from the session
+func (c *sessionConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, c.peer, nil
+}
+
+// WriteTo sends p over the session, whatever addr
+func (c *sessionConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.Write(p)
+}
+
+// LocalAddr returns the local address of the session
+func (c *sessionConn) LocalAddr() net.Addr {
+	return Addr{c.Conn.LocalAddr()}
+}
+
+// Listen returns a net.PacketConn receiving the packets of the DTLS
+// sessions accepted by l, for a tftp.Server serving the transfer of each
+// request over the session of the request. A session ends when its peer
+// closes it.
+func Listen(l net.Listener) net.PacketConn {
+	c := &listenerConn{
+		l:        l,
+		packets:  make(chan datagram, 64),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+		sessions: make(map[string]net.Conn),
+	}
+	go c.accept()
+	return c
+}
+
+// datagram is a packet received over a session
+type datagram struct {
+	p    []byte
+	from net.Addr
+}
+
+// listenerConn is the net.PacketConn of the sessions of a listener
+type listenerConn struct {
+	l       net.Listener
+	packets chan datagram
+	closed  chan struct{} // closed by Close
+	done    chan struct{} // closed when accepting failed
+	err     error         // error accepting sessions
+	once    sync.Once
+
+	mu       sync.Mutex
+	sessions map[string]net.Conn // sessions by peer address
+	deadline chan struct{}       // closed when the read deadline passes, nil if none
+	timer    *time.Timer
+}
+
+// accept accepts sessions until the listener fails
+func (c *listenerConn) accept() {
+	for {
+		s, err := c.l.Accept()
+		if err != nil {
+			c.err = err
+			close(c.done)
+			return
+		}
+		peer := Addr{s.RemoteAddr()}
+		c.mu.Lock()
+		c.sessions[peer.String()] = s
+		c.mu.Unlock()
+		go c.receive(s, peer)
+	}
+}
+
+// receive passes the packets of session s with peer to ReadFrom until
+// the session ends
+func (c *listenerConn) receive(s net.Conn, peer Addr) {
+	defer func() {
+		c.mu.Lock()
+		if c.sessions[peer.String()] == s {
+			delete(c.sessions, peer.String())
+		}
+		c.mu.Unlock()
+		s.Close()
+	}()
+	buf := make([]byte, 65536)
+	for {
+		n, err := s.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case c.packets <- datagram{append([]byte(nil), buf[:n]...), peer}:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// ReadFrom reads the next packet of any session
+func (c *listenerConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	select {
+	case <-deadline:
+		return 0, nil, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case d := <-c.packets:
+		return copy(p, d.p), d.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-c.done:
+		return 0, nil, c.err
+	case <-deadline:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends p over the session with addr
+func (c *listenerConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	s, ok := c.sessions[addr.String()]
+	c.mu.Unlock()
+	if !ok {
+		return 0, errors.New("dtls: no session with " + addr.String())
+	}
+	return s.Write(p)
+}
+
+// Close closes the listener and its sessions
+func (c *listenerConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.l.Close()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, s := range c.sessions {
+			s.Close()
+		}
+	})
+	return err
+}
+
+// LocalAddr returns the address of the listener
+func (c *listenerConn) LocalAddr() net.Addr {
+	return Addr{c.l.Addr()}
+}
+
+// SetDeadline sets the read deadline, writes are not limited
+func (c *listenerConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *listenerConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if t.IsZero() {
+		c.deadline = nil
+		return nil
+	}
+	deadline := make(chan struct{})
+	c.deadline = deadline
+	if d := time.Until(t); d > 0 {
+		c.timer = time.AfterFunc(d, func() { close(deadline) })
+	} else {
+		close(deadline)
+	}
+	return nil
+}
+
+// SetWriteDeadline has no effect
+func (c *listenerConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package dtls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// pipeListener stands in for a DTLS listener, accepting sessions over
+// in-memory pipes
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	next   atomic.Int32
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 69}
+}
+
+// dial stands in for a DTLS handshake, returning the client end of a pipe
+func (l *pipeListener) dial(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
+	client, server := net.Pipe()
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 10000 + int(l.next.Add(1))}
+	select {
+	case l.conns <- addrConn{server, peer}:
+		return client, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// addrConn is a pipe end with a remote address
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestTransfer(t *testing.T) {
+	l := newPipeListener()
+	m := &tftp.MemFS{Writable: true}
+	s := &tftp.Server{Handler: m, MaxBlockSize: MaxBlockSize}
+	go s.Serve(Listen(l))
+	defer s.Close()
+
+	c := &tftp.Client{Timeout: time.Second, BlockSize: 65464, WindowSize: 4, Transport: &Transport{Dial: l.dial}}
+	content := bytes.Repeat([]byte("firmware"), 10000)
+	for i := range 3 {
+		name := fmt.Sprint("file", i)
+		if err := c.Put(context.Background(), "192.0.2.1", name, bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		if err := c.Get(context.Background(), "192.0.2.1", name, &got); err != nil || !bytes.Equal(got.Bytes(), content) {
+			t.Errorf("%s: got %d bytes, %v, want %d", name, got.Len(), err, len(content))
+		}
+	}
+}
+
+func TestResolveAddr(t *testing.T) {
+	tr := &Transport{}
+	for addr, want := range map[string]string{
+		"192.0.2.1":      "192.0.2.1:69",
+		"192.0.2.1:1069": "192.0.2.1:1069",
+		"[2001:db8::1]":  "[2001:db8::1]:69",
+	} {
+		a, err := tr.ResolveAddr(context.Background(), addr)
+		if err != nil || a.String() != want || a.Network() != "dtls" {
+			t.Errorf("%s: got %v, %v, want %s", addr, a, err, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package tftp
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// ListenDualStack listens on port on all IPv4 and all IPv6 addresses, with
+// a socket for each family so both are served whether or not the system
+// maps IPv4 to IPv6 addresses. Port 69 is used if port is empty, with
+// port 0 both sockets listen on the same ephemeral port.
+func ListenDualStack(port string) (conns []net.PacketConn, err error) {
+	if port == "" {
+		port = "69"
+	}
+	for _, network := range []string{"udp4", "udp6"} {
+		conn, err := net.ListenPacket(network, net.JoinHostPort("", port))
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+		port = strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	}
+	return conns, nil
+}
+
+// ListenAndServeDualStack listens with ListenDualStack and serves requests
+// on both families until Shutdown or Close, or until serving either fails
+func (s *Server) ListenAndServeDualStack(port string) error {
+	conns, err := ListenDualStack(port)
+	if err != nil {
+		return err
+	}
+	for _, conn := range conns {
+		defer conn.Close()
+	}
+	return s.ServeAll(context.Background(), conns...)
+}
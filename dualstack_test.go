@@ -0,0 +1,77 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// skipNoIPv6 skips t if IPv6 loopback is unavailable
+func skipNoIPv6(t *testing.T) {
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 unavailable:", err)
+	}
+	conn.Close()
+}
+
+func TestServerIPv6(t *testing.T) {
+	skipNoIPv6(t)
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := &Server{Handler: &MemFS{Writable: true}}
+	go s.Serve(conn)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	c := &Client{Timeout: time.Second, BlockSize: 1024}
+	addr := net.JoinHostPort("::1", strconv.Itoa(port))
+	if err := c.Put(context.Background(), addr, "file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr, "file", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("got %d bytes, want %d", got.Len(), len(content))
+	}
+}
+
+func TestListenAndServeDualStack(t *testing.T) {
+	skipNoIPv6(t)
+	conns, err := ListenDualStack("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conns[0].LocalAddr().(*net.UDPAddr).Port
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	fs := &MemFS{}
+	fs.Set("file", []byte("data"))
+	s := &Server{Handler: fs}
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServeDualStack(strconv.Itoa(port)) }()
+	c := &Client{Timeout: 100 * time.Millisecond}
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		var got bytes.Buffer
+		if err := c.Get(context.Background(), net.JoinHostPort(host, strconv.Itoa(port)), "file", &got); err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if got.String() != "data" {
+			t.Errorf("%s: got %q", host, got.String())
+		}
+	}
+	s.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("got %v, want ErrServerClosed", err)
+	}
+}
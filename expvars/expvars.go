@@ -0,0 +1,71 @@
+// Package expvars publishes the counters of a tftp.Server with expvar, so
+// that the debug endpoints serving expvar report them without a metrics
+// dependency
+package expvars
+
+import (
+	"expvar"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// defaultName is the name of the counters if none is given
+const defaultName = "tftp"
+
+// results names the counters of the outcomes of requests
+var results = map[tftp.AuditResult]string{
+	tftp.AuditCompleted: "completed",
+	tftp.AuditFailed:    "failed",
+	tftp.AuditRefused:   "refused",
+	tftp.AuditDropped:   "dropped",
+}
+
+// Publish publishes the counters of s as the expvar map name, "tftp" if
+// empty:
+//
+//	requests     requests received by opcode
+//	completed    transfers completed
+//	failed       transfers failed
+//	refused      requests refused with an ERROR packet
+//	dropped      requests ignored without a reply
+//	errors       transfers failed and requests refused
+//	active       transfers in progress
+//	bytes        file data transferred
+//	retransmits  timeouts and retransmissions
+//
+// Requests are counted by chaining s.Audit, so Publish must be called
+// before s starts serving. Like expvar.Publish, it panics if name is
+// already published.
+func Publish(s *tftp.Server, name string) *expvar.Map {
+	if name == "" {
+		name = defaultName
+	}
+	m := expvar.NewMap(name)
+	requests := new(expvar.Map).Init()
+	m.Set("requests", requests)
+	counts := make(map[tftp.AuditResult]*expvar.Int)
+	for result, key := range results {
+		counts[result] = new(expvar.Int)
+		m.Set(key, counts[result])
+	}
+	errs := new(expvar.Int)
+	m.Set("errors", errs)
+	m.Set("active", expvar.Func(func() any { return s.Stats().Active }))
+	m.Set("bytes", expvar.Func(func() any { return s.Stats().Bytes }))
+	m.Set("retransmits", expvar.Func(func() any { return s.Stats().Retransmits }))
+
+	next := s.Audit
+	s.Audit = func(rec tftp.AuditRecord) {
+		requests.Add(rec.Op.String(), 1)
+		if n, ok := counts[rec.Result]; ok {
+			n.Add(1)
+		}
+		if rec.Result == tftp.AuditFailed || rec.Result == tftp.AuditRefused {
+			errs.Add(1)
+		}
+		if next != nil {
+			next(rec)
+		}
+	}
+	return m
+}
@@ -0,0 +1,67 @@
+package expvars
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+func TestPublish(t *testing.T) {
+	fs := &tftp.MemFS{}
+	fs.Set("file", make([]byte, 1000))
+	s := &tftp.Server{
+		Handler: fs,
+		Authorize: func(peer net.Addr, op tftp.Opcode, filename string, mode tftp.Mode) error {
+			if op == tftp.WRQ {
+				return &tftp.Error{Code: tftp.AccessViolation, Message: "read only"}
+			}
+			return nil
+		},
+	}
+	m := Publish(s, "tftp_test")
+	if expvar.Get("tftp_test") != m {
+		t.Fatal("map not published")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.Serve(conn)
+
+	addr := conn.LocalAddr().String()
+	c := &tftp.Client{Timeout: time.Second}
+	ctx := context.Background()
+	if err := c.Get(ctx, addr, "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	c.Get(ctx, addr, "missing", io.Discard)
+	c.Put(ctx, addr, "upload", io.LimitReader(nil, 0))
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Requests  map[string]int64
+		Completed int64
+		Failed    int64
+		Refused   int64
+		Dropped   int64
+		Errors    int64
+		Active    int64
+		Bytes     int64
+	}
+	if err := json.Unmarshal([]byte(m.String()), &got); err != nil {
+		t.Fatalf("%v: %s", err, m.String())
+	}
+	if got.Requests["RRQ"] != 2 || got.Requests["WRQ"] != 1 || got.Completed != 1 || got.Failed != 1 ||
+		got.Refused != 1 || got.Dropped != 0 || got.Errors != 2 || got.Active != 0 || got.Bytes != 1000 {
+		t.Errorf("got %s", m.String())
+	}
+}
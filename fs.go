@@ -0,0 +1,57 @@
+package tftp
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// FS returns a Handler serving reads from the files in fsys and refusing
+// writes. Filenames are cleaned with CleanPath. Missing files are reported
+// to clients as FileNotFound, files that cannot be read as AccessViolation.
+// Symbolic links are followed as fsys does, use Dir to confine them to a
+// directory tree.
+func FS(fsys fs.FS) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) error {
+		if r.Op != RRQ {
+			return &fs.PathError{Op: "write", Path: r.Filename, Err: fs.ErrPermission}
+		}
+		return serveFile(w, r, fsys)
+	})
+}
+
+// CleanPath returns the slash separated path within a served tree of a
+// requested filename. Leading slashes are removed, so that absolute paths
+// are relative to the root of the tree. Filenames with backslashes or
+// empty, "." or ".." elements are refused with an error matching
+// fs.ErrPermission, reported to clients as AccessViolation.
+func CleanPath(filename string) (string, error) {
+	name := strings.TrimLeft(filename, "/")
+	if !fs.ValidPath(name) || name == "." || strings.ContainsRune(name, '\\') {
+		return "", &fs.PathError{Op: "open", Path: filename, Err: fs.ErrPermission}
+	}
+	return name, nil
+}
+
+// serveFile sends the regular file in fsys requested by a RRQ
+func serveFile(w ResponseWriter, r *Request, fsys fs.FS) error {
+	name, err := CleanPath(r.Filename)
+	if err != nil {
+		return err
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrPermission}
+	}
+	w.SetSize(fi.Size())
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,82 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFS(t *testing.T) {
+	content := bytes.Repeat([]byte("pxelinux"), 200)
+	fsys := fstest.MapFS{
+		"boot/pxelinux.0": {Data: content},
+		"boot/empty":      {},
+	}
+	addr := startServer(t, &Server{Handler: FS(fsys)})
+	c := &Client{Timeout: time.Second}
+	for _, name := range []string{"boot/pxelinux.0", "/boot/pxelinux.0"} {
+		var got bytes.Buffer
+		if err := c.Get(context.Background(), addr.String(), name, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), content) {
+			t.Errorf("%s: got %d bytes, want %d", name, got.Len(), len(content))
+		}
+	}
+
+	for _, test := range []struct {
+		req  packet
+		code ErrorCode
+	}{
+		{newRRQPacket("boot/missing", Octet, nil), FileNotFound},
+		{newRRQPacket("boot", Octet, nil), AccessViolation},
+		{newRRQPacket("../etc/passwd", Octet, nil), AccessViolation},
+		{newRRQPacket("boot\\..\\..\\etc\\passwd", Octet, nil), AccessViolation},
+		{newWRQPacket("boot/empty", Octet, nil), AccessViolation},
+	} {
+		conn := dialServer(t)
+		conn.WriteTo(test.req, addr)
+		buf := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := packet(buf[:n]); p.opcode() != ERROR || p.errorCode() != test.code {
+			t.Errorf("%s %q: got %v %v %q, want %v", test.req.opcode(), test.req.filename(), p.opcode(), p.errorCode(), p.errorMessage(), test.code)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for _, test := range []struct {
+		filename, want string
+	}{
+		{"pxelinux.0", "pxelinux.0"},
+		{"/boot/pxelinux.0", "boot/pxelinux.0"},
+		{"//boot/pxelinux.cfg/01-00-11-22-33-44-55", "boot/pxelinux.cfg/01-00-11-22-33-44-55"},
+		{"", ""},
+		{"/", ""},
+		{".", ""},
+		{"..", ""},
+		{"../etc/passwd", ""},
+		{"/boot/../../etc/passwd", ""},
+		{"boot/./pxelinux.0", ""},
+		{"boot//pxelinux.0", ""},
+		{"boot/", ""},
+		{"boot\\pxelinux.0", ""},
+		{"..\\windows\\win.ini", ""},
+	} {
+		got, err := CleanPath(test.filename)
+		if test.want == "" {
+			if !errors.Is(err, fs.ErrPermission) {
+				t.Errorf("%q: got %q, %v, want fs.ErrPermission", test.filename, got, err)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("%q: got %q, %v, want %q", test.filename, got, err, test.want)
+		}
+	}
+}
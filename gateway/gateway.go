@@ -0,0 +1,144 @@
+// Package gateway implements an http.Handler giving HTTP access to the
+// files of a TFTP server, for tooling that cannot speak TFTP
+package gateway
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// Handler transfers the file named by the URL path, without the leading
+// slash, with the TFTP server at Addr: GET reads it with a RRQ, HEAD
+// queries its size with the tsize option and PUT writes it with a WRQ.
+// Use http.StripPrefix to serve it under a prefix.
+//
+// The status of a GET is sent once the first block arrives, a transfer
+// failing later aborts the response.
+type Handler struct {
+	Addr   string       // address of the TFTP server, port 69 if it has no port
+	Client *tftp.Client // client for transfers, a zero Client if nil
+}
+
+// ServeHTTP transfers a file with the TFTP server
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filename := strings.TrimPrefix(r.URL.Path, "/")
+	if filename == "" {
+		http.NotFound(w, r)
+		return
+	}
+	c := tftp.Client{}
+	if h.Client != nil {
+		c = *h.Client
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r, &c, filename)
+	case http.MethodHead:
+		size, err := c.Stat(r.Context(), h.Addr, filename)
+		if errors.Is(err, tftp.ErrSizeUnknown) {
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), status(err))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	case http.MethodPut:
+		var body io.Reader = r.Body
+		if r.ContentLength >= 0 {
+			// announced with the tsize option
+			body = &sizedBody{r.Body, r.ContentLength}
+		}
+		if err := c.Put(r.Context(), h.Addr, filename, body); err != nil {
+			http.Error(w, err.Error(), status(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// get sends a file read from the TFTP server
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, c *tftp.Client, filename string) {
+	// the size announced with the tsize option is known once the first
+	// block arrives, before it is written
+	size := int64(-1)
+	onProgress := c.OnProgress
+	c.OnProgress = func(transferred, n int64) {
+		size = n
+		if onProgress != nil {
+			onProgress(transferred, n)
+		}
+	}
+	rw := &response{w: w, size: &size}
+	err := c.Get(r.Context(), h.Addr, filename, rw)
+	if err != nil {
+		if rw.started {
+			panic(http.ErrAbortHandler)
+		}
+		http.Error(w, err.Error(), status(err))
+		return
+	}
+	if !rw.started {
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// response writes the file to an http.ResponseWriter, sending the header
+// on the first Write
+type response struct {
+	w       http.ResponseWriter
+	size    *int64 // file size, -1 if unknown
+	started bool   // the header was sent
+}
+
+// Write writes file data
+func (r *response) Write(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		r.w.Header().Set("Content-Type", "application/octet-stream")
+		if *r.size >= 0 {
+			r.w.Header().Set("Content-Length", strconv.FormatInt(*r.size, 10))
+		}
+		r.w.WriteHeader(http.StatusOK)
+	}
+	return r.w.Write(p)
+}
+
+// sizedBody is a request body of known length
+type sizedBody struct {
+	body io.Reader
+	n    int64
+}
+
+func (b *sizedBody) Read(p []byte) (int, error) { return b.body.Read(p) }
+func (b *sizedBody) Len() int                   { return int(b.n) }
+
+// status returns the HTTP status for a failed transfer
+func status(err error) int {
+	var e *tftp.Error
+	switch {
+	case errors.As(err, &e):
+		switch e.Code {
+		case tftp.FileNotFound:
+			return http.StatusNotFound
+		case tftp.AccessViolation:
+			return http.StatusForbidden
+		case tftp.DiskFull:
+			return http.StatusInsufficientStorage
+		case tftp.FileAlreadyExists:
+			return http.StatusConflict
+		}
+	case errors.Is(err, tftp.ErrTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
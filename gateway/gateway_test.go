@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+func TestHandler(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	m := &tftp.MemFS{Writable: true}
+	content := bytes.Repeat([]byte("x"), 2000)
+	m.Set("images/vmlinuz", content)
+	go (&tftp.Server{Handler: m}).Serve(conn)
+	srv := httptest.NewServer(&Handler{Addr: conn.LocalAddr().String()})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/images/vmlinuz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 2000 || !bytes.Equal(body, content) {
+		t.Errorf("GET: got %s, length %d, %d bytes", resp.Status, resp.ContentLength, len(body))
+	}
+
+	resp, err = http.Head(srv.URL + "/images/vmlinuz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 2000 {
+		t.Errorf("HEAD: got %s, length %d", resp.Status, resp.ContentLength)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/upload", strings.NewReader("data"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, _ := m.Get("upload"); resp.StatusCode != http.StatusNoContent || string(got) != "data" {
+		t.Errorf("PUT: got %s, stored %q", resp.Status, got)
+	}
+
+	for _, test := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound},
+		{http.MethodHead, "/missing", http.StatusNotFound},
+		{http.MethodGet, "/", http.StatusNotFound},
+		{http.MethodPost, "/upload", http.StatusMethodNotAllowed},
+	} {
+		req, _ := http.NewRequest(test.method, srv.URL+test.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s: got %s, want %d", test.method, test.path, resp.Status, test.status)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		status int
+	}{
+		{&tftp.Error{Code: tftp.FileNotFound}, http.StatusNotFound},
+		{&tftp.Error{Code: tftp.AccessViolation}, http.StatusForbidden},
+		{&tftp.Error{Code: tftp.DiskFull}, http.StatusInsufficientStorage},
+		{&tftp.Error{Code: tftp.FileAlreadyExists}, http.StatusConflict},
+		{tftp.ErrTimeout, http.StatusGatewayTimeout},
+		{io.ErrUnexpectedEOF, http.StatusBadGateway},
+	} {
+		if got := status(test.err); got != test.status {
+			t.Errorf("%v: got %d, want %d", test.err, got, test.status)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+)
+
+// GetRequest is a file read by GetAll
+type GetRequest struct {
+	Addr     string    // address of the server
+	Filename string    // file to read
+	Writer   io.Writer // receives the file
+
+	// Hash and Sum verify the file with GetVerified if Sum is not nil
+	Hash func() hash.Hash
+	Sum  []byte
+}
+
+// GetError is the error of a file GetAll failed to read
+type GetError struct {
+	Addr     string
+	Filename string
+	Err      error
+}
+
+func (e *GetError) Error() string {
+	return "tftp: get " + e.Filename + " from " + e.Addr + ": " + e.Err.Error()
+}
+
+func (e *GetError) Unwrap() error {
+	return e.Err
+}
+
+// GetAll reads the files of requests as with Get, or GetVerified if their
+// digest is given, concurrency of them at
+// once, 1 if zero, sharing MaxBandwidth over all transfers. Files not
+// started by the time ctx is done are not read. The error joins a
+// *GetError for each file that failed, in the order of requests, and is
+// nil if all files were read. The hooks of c are called concurrently.
+func (c *Client) GetAll(ctx context.Context, requests []GetRequest, concurrency int) error {
+	c2 := *c
+	if c2.shaper == nil {
+		c2.shaper = new(shaper)
+	}
+	concurrency = min(max(concurrency, 1), len(requests))
+	errs := make([]error, len(requests))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r := requests[i]
+				var err error
+				if r.Sum != nil {
+					err = c2.GetVerified(ctx, r.Addr, r.Filename, r.Writer, r.Hash, r.Sum)
+				} else {
+					err = c2.Get(ctx, r.Addr, r.Filename, r.Writer)
+				}
+				if err != nil {
+					errs[i] = &GetError{Addr: r.Addr, Filename: r.Filename, Err: err}
+				}
+			}
+		}()
+	}
+	for i, r := range requests {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			errs[i] = &GetError{Addr: r.Addr, Filename: r.Filename, Err: ctx.Err()}
+		}
+	}
+	close(next)
+	wg.Wait()
+	return errors.Join(errs...)
+}
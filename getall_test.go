@@ -0,0 +1,49 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClientGetAll(t *testing.T) {
+	m := &MemFS{}
+	for i := range 5 {
+		m.Set(fmt.Sprintf("file%d", i), bytes.Repeat([]byte{byte(i)}, 40000))
+	}
+	addr := startServer(t, &Server{Handler: m}).String()
+	c := &Client{Timeout: time.Second, BlockSize: 1024, WindowSize: 4, MaxBandwidth: 200000}
+
+	var bufs [6]bytes.Buffer
+	var requests []GetRequest
+	for i := range bufs {
+		requests = append(requests, GetRequest{Addr: addr, Filename: fmt.Sprintf("file%d", i), Writer: &bufs[i]})
+	}
+	// 65536 bytes of burst, the rest shared at 200000 per second
+	start := time.Now()
+	err := c.GetAll(context.Background(), requests, 3)
+	if d := time.Since(start); d < 600*time.Millisecond {
+		t.Errorf("transfers took %v, want at least 600ms", d)
+	}
+	var ge *GetError
+	if !errors.As(err, &ge) || ge.Filename != "file5" || !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("got %v, want file5 not found", err)
+	}
+	for i := range 5 {
+		if !bytes.Equal(bufs[i].Bytes(), bytes.Repeat([]byte{byte(i)}, 40000)) {
+			t.Errorf("file%d: got %d bytes", i, bufs[i].Len())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.GetAll(ctx, requests[:2], 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if err := c.GetAll(context.Background(), nil, 4); err != nil {
+		t.Errorf("no files: got %v", err)
+	}
+}
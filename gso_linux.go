@@ -0,0 +1,75 @@
+package tftp
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// segmentation offload limits
+const (
+	maxSegments     = 64    // segments per send, UDP_MAX_SEGMENTS of older kernels
+	maxSegmentBytes = 65000 // bytes per send, below the 65507 bytes of a UDP datagram
+)
+
+// sendSegments sends packets of equal size, but for a shorter last one,
+// with UDP segmentation offload, the kernel or the NIC splitting each
+// send into datagrams. It returns false without sending if offload does
+// not apply to the packets or failed, in which case it is disabled for t.
+func (t *transfer) sendSegments(ps []packet) (bool, error) {
+	c, ok := t.conn.(*net.UDPConn)
+	peer, _ := t.peer.(*net.UDPAddr)
+	size := len(ps[0])
+	if !ok || peer == nil && !t.connected || len(ps) < 2 || len(ps[len(ps)-1]) > size {
+		return false, nil
+	}
+	for _, p := range ps[1 : len(ps)-1] {
+		if len(p) != size {
+			return false, nil
+		}
+	}
+	n := min(maxSegments, maxSegmentBytes/size)
+	if n < 2 {
+		return false, nil
+	}
+	oob := segmentSize(size)
+	if t.connected {
+		peer = nil
+	}
+	t.sent = t.now()
+	for first := true; len(ps) > 0; first = false {
+		n := min(n, len(ps))
+		b := t.segments[:0]
+		for _, p := range ps[:n] {
+			b = append(b, p...)
+		}
+		t.segments = b
+		if _, _, err := c.WriteMsgUDP(b, oob, peer); err != nil {
+			if first {
+				// EIO without checksum offload, EINVAL on older kernels
+				t.log.Debug("segmentation offload failed", "err", err)
+				t.gso = false
+				return false, nil
+			}
+			return true, err
+		}
+		for _, p := range ps[:n] {
+			t.traceSend(p, t.peer)
+		}
+		ps = ps[n:]
+	}
+	return true, nil
+}
+
+// segmentSize returns the UDP_SEGMENT control message for segments of
+// size bytes
+func segmentSize(size int) []byte {
+	b := make([]byte, unix.CmsgSpace(2))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.SOL_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&b[unix.CmsgLen(0)])) = uint16(size)
+	return b
+}
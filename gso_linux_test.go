@@ -0,0 +1,58 @@
+package tftp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSendSegments(t *testing.T) {
+	a, b := transferPair(t)
+	a.gso = true
+	sizes := []int{516, 516, 516, 516, 100}
+	var ps []packet
+	for i, n := range sizes {
+		p := make(packet, n)
+		p[4] = byte(i)
+		ps = append(ps, p)
+	}
+	ok, err := a.sendSegments(ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		if a.gso {
+			t.Fatal("offload refused without being disabled")
+		}
+		t.Skip("segmentation offload not supported")
+	}
+	buf := make([]byte, 2048)
+	for i, want := range sizes {
+		n, _, err := b.conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want || buf[4] != byte(i) {
+			t.Errorf("datagram %d: got %d bytes starting %d, want %d", i, n, buf[4], want)
+		}
+	}
+
+	// packets of different sizes are not segmented
+	if ok, _ := a.sendSegments([]packet{make(packet, 100), make(packet, 516)}); ok {
+		t.Error("segmented a last packet larger than the others")
+	}
+
+	// windows are sent with segmentation offload
+	content := make([]byte, 100*512+100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	oack := map[option]int{windowsize: 16}
+	a.apply(oack)
+	b.apply(oack)
+	if got := copyTransfer(t, a, b, content); !bytes.Equal(got, content) {
+		t.Errorf("got %d bytes, want %d", len(got), len(content))
+	}
+	if !a.gso {
+		t.Error("segmentation offload disabled")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package tftp
+
+// sendSegments returns false, segmentation offload is not supported on
+// this system
+func (t *transfer) sendSegments(ps []packet) (bool, error) {
+	return false, nil
+}
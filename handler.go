@@ -0,0 +1,141 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+)
+
+// Handler responds to a TFTP request. For a RRQ it writes the file to w,
+// for a WRQ it reads the file from r.Body. Returning an error refuses the
+// request, or aborts the transfer in progress, with an ERROR packet
+// carrying the error message, or the code and message of an *Error. The
+// code is file not found for fs.ErrNotExist, access violation for
+// fs.ErrPermission and read-only file systems, file already exists for
+// fs.ErrExist, disk full for ENOSPC, EDQUOT and EFBIG, and not defined for
+// other errors. A panic of a handler is recovered by the server, which
+// logs it with the stack and aborts the transfer with ErrHandlerPanic.
+type Handler interface {
+	ServeTFTP(w ResponseWriter, r *Request) error
+}
+
+// HandlerFunc adapts a function to a Handler
+type HandlerFunc func(w ResponseWriter, r *Request) error
+
+// ServeTFTP calls f(w, r)
+func (f HandlerFunc) ServeTFTP(w ResponseWriter, r *Request) error {
+	return f(w, r)
+}
+
+// Request is a RRQ or WRQ received by a server
+type Request struct {
+	Op           Opcode            // RRQ or WRQ
+	Filename     string            // requested file
+	Mode         Mode              // transfer mode
+	RemoteAddr   net.Addr          // address of the client, whose port is the transfer identifier of the client
+	LocalAddr    net.Addr          // address the request was received on
+	TransferAddr net.Addr          // address of the socket of the transfer, whose port is the transfer identifier of the server
+	Interface    string            // network interface of LocalAddr, empty if unknown as for a wildcard address
+	Options      map[string]string // options requested by the client by lower case name
+	BlockSize    int               // negotiated block size
+	WindowSize   int               // negotiated window size
+	Size         int64             // file size announced by the client of a WRQ, -1 if unknown
+	Offset       int64             // byte offset a RRQ resumes from with Server.Resume, the file data before it is not sent
+	Body         io.Reader         // file written by the client of a WRQ in local text, nil for a RRQ
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc // aborts the transfer, nil outside a server
+}
+
+// Context returns the context of the request, which is done when the
+// transfer ends or the server stops
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// Abort aborts the transfer of the request with an ERROR packet for err,
+// with the code and message of an *Error, or the error code err maps to
+// as returned by a handler. It may be called from any goroutine, pending
+// and later reads of Body and writes to the ResponseWriter fail.
+func (r *Request) Abort(err error) {
+	if err == nil {
+		err = errAborted
+	}
+	if r.cancel != nil {
+		r.cancel(err)
+	}
+}
+
+// ErrHandlerPanic is the error of a transfer whose handler panicked,
+// reported to the client without the panic
+var ErrHandlerPanic = errors.New("tftp: internal server error")
+
+// errAborted is the error of a transfer aborted without an error
+var errAborted = errors.New("tftp: transfer aborted")
+
+// negotiated sets the negotiated values of r from the options to
+// acknowledge
+func (r *Request) negotiated(oack map[option]int) {
+	r.BlockSize, r.WindowSize, r.Size = defaultBlockSize, 1, -1
+	if n, ok := oack[blksize]; ok {
+		r.BlockSize = n
+	}
+	if n, ok := oack[windowsize]; ok {
+		r.WindowSize = n
+	}
+	if n, ok := oack[tsize]; ok && r.Op == WRQ {
+		r.Size = int64(n)
+	}
+	if n, ok := oack[offset]; ok {
+		r.Offset = int64(n)
+	}
+}
+
+// requestKey is the context key of the request of a transfer
+type requestKey struct{}
+
+// RequestFromContext returns the request whose transfer ctx belongs to, as
+// the context passed to a ReadHandler or WriteHandler, so that they may
+// tell requests apart by address
+func RequestFromContext(ctx context.Context) (*Request, bool) {
+	r, ok := ctx.Value(requestKey{}).(*Request)
+	return r, ok
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// ResponseWriter sends the file to the client of a RRQ. The ResponseWriter
+// of a server implements io.ReaderFrom, so that io.Copy from an
+// io.ReaderAt in octet mode reads blocks sent again from it rather than
+// keeping them in memory.
+type ResponseWriter interface {
+	// Write sends file data in local text, the first Write acknowledges
+	// the request. Writes fail for a WRQ.
+	Write(p []byte) (int, error)
+
+	// SetSize sets the file size announced to clients requesting the
+	// tsize option. It has no effect after the first Write or for
+	// netascii.
+	SetSize(size int64)
+}
+
+// Middleware wraps a Handler with additional behavior
+type Middleware func(Handler) Handler
+
+// Chain returns h wrapped with the middleware, the first middleware being
+// the outermost
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
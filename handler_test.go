@@ -0,0 +1,365 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	var files sync.Map
+	requests := make(chan *Request, 1)
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			requests <- r
+			switch r.Op {
+			case WRQ:
+				b, err := io.ReadAll(r.Body)
+				if err != nil {
+					return err
+				}
+				files.Store(r.Filename, b)
+				return nil
+			default:
+				b, ok := files.Load(r.Filename)
+				if !ok {
+					return errors.New("no such file")
+				}
+				w.SetSize(int64(len(b.([]byte))))
+				_, err := w.Write(b.([]byte))
+				return err
+			}
+		}),
+	}
+	addr := startServer(t, s).String()
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("line\n"), 300)
+	for _, mode := range []Mode{Octet, Netascii} {
+		c := &Client{Mode: mode, Timeout: time.Second, BlockSize: 1024}
+		if err := c.Put(ctx, addr, "file", bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		r := <-requests
+		if r.Op != WRQ || r.Filename != "file" || r.Mode != mode || r.Options["blksize"] != "1024" {
+			t.Errorf("got %v %q %v %v, want WRQ", r.Op, r.Filename, r.Mode, r.Options)
+		}
+		if r.RemoteAddr == nil || r.LocalAddr.String() != addr {
+			t.Errorf("got addresses %v %v", r.RemoteAddr, r.LocalAddr)
+		}
+		var got bytes.Buffer
+		if err := c.Get(ctx, addr, "file", &got); err != nil {
+			t.Fatal(err)
+		}
+		if r := <-requests; r.Op != RRQ {
+			t.Errorf("got %v, want RRQ", r.Op)
+		}
+		if !bytes.Equal(got.Bytes(), content) {
+			t.Errorf("%v: got %d bytes, want %d", mode, got.Len(), len(content))
+		}
+	}
+
+	c := &Client{Timeout: time.Second}
+	err := c.Get(ctx, addr, "missing", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("got %v, want no such file", err)
+	}
+	<-requests
+}
+
+func TestHandlerTransferSize(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 1000)
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			w.SetSize(int64(len(content)))
+			_, err := w.Write(content)
+			return err
+		}),
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("test", Octet, map[option]int{tsize: 0}), addr)
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != OACK || p.options()[tsize] != len(content) {
+		t.Errorf("got %v %v, want OACK with tsize %d", p.opcode(), p.options(), len(content))
+	}
+}
+
+func TestHandlerAbort(t *testing.T) {
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			if _, err := w.Write(make([]byte, 600)); err != nil {
+				return err
+			}
+			return errors.New("read failed")
+		}),
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("test", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	n, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != DATA || p.block() != 1 {
+		t.Fatalf("got %v block %d, want DATA block 1", p.opcode(), p.block())
+	}
+	conn.WriteTo(newACKPacket(1), peer)
+	if n, _, err = conn.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != ERROR || p.errorMessage() != "read failed" {
+		t.Errorf("got %v %q, want ERROR read failed", p.opcode(), p.errorMessage())
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, r *Request) error {
+				calls = append(calls, name)
+				return next.ServeTFTP(w, r)
+			})
+		}
+	}
+	h := Chain(HandlerFunc(func(w ResponseWriter, r *Request) error {
+		calls = append(calls, "handler")
+		return nil
+	}), trace("outer"), trace("inner"))
+	h.ServeTFTP(nil, &Request{})
+	if got := strings.Join(calls, " "); got != "outer inner handler" {
+		t.Errorf("got %q, want outer inner handler", got)
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			return fmt.Errorf("refused: %w", &Error{Code: NoSuchUser, Message: "unknown user"})
+		}),
+	}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second}
+	err := c.Get(context.Background(), addr, "file", io.Discard)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != NoSuchUser || e.Message != "unknown user" {
+		t.Errorf("got %v, want NoSuchUser unknown user", err)
+	}
+	if got, want := e.Error(), "tftp: unknown user (NoSuchUser)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRequestAbort(t *testing.T) {
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			if r.Op == WRQ {
+				if _, err := io.ReadFull(r.Body, make([]byte, 600)); err != nil {
+					return err
+				}
+				r.Abort(&Error{Code: DiskFull, Message: "quota exceeded"})
+				// the handler's result is superseded by the abort
+				return nil
+			}
+			if _, err := w.Write(make([]byte, 600)); err != nil {
+				return err
+			}
+			done := make(chan struct{})
+			go func() {
+				r.Abort(&Error{Code: AccessViolation, Message: "revoked"})
+				close(done)
+			}()
+			<-done
+			if _, err := w.Write(make([]byte, 600)); err == nil {
+				t.Error("write after abort succeeded")
+			}
+			return nil
+		}),
+	}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second}
+	err := c.Get(context.Background(), addr, "file", io.Discard)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != AccessViolation || e.Message != "revoked" {
+		t.Errorf("get: got %v, want AccessViolation revoked", err)
+	}
+	err = c.Put(context.Background(), addr, "file", bytes.NewReader(make([]byte, 4000)))
+	if !errors.As(err, &e) || e.Code != DiskFull || e.Message != "quota exceeded" {
+		t.Errorf("put: got %v, want DiskFull quota exceeded", err)
+	}
+}
+
+func TestRequestNegotiated(t *testing.T) {
+	requests := make(chan Request, 1)
+	s := &Server{
+		MaxBlockSize: 1024,
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			requests <- *r
+			if r.Op == WRQ {
+				_, err := io.Copy(io.Discard, r.Body)
+				return err
+			}
+			_, err := w.Write([]byte("data"))
+			return err
+		}),
+	}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second, BlockSize: 1468, WindowSize: 4}
+	if err := c.Put(context.Background(), addr, "file", bytes.NewReader(make([]byte, 3000))); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.BlockSize != 1024 || r.WindowSize != 4 || r.Size != 3000 {
+		t.Errorf("WRQ: got block size %d, window size %d, size %d, want 1024, 4, 3000", r.BlockSize, r.WindowSize, r.Size)
+	}
+	c = &Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), addr, "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.BlockSize != defaultBlockSize || r.WindowSize != 1 || r.Size != -1 {
+		t.Errorf("RRQ: got block size %d, window size %d, size %d, want %d, 1, -1", r.BlockSize, r.WindowSize, r.Size, defaultBlockSize)
+	}
+}
+
+func TestHandlerNetasciiLineEndings(t *testing.T) {
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			// a CR LF split over writes is one line ending
+			for _, p := range []string{"line\r", "\nend\r"} {
+				if _, err := io.WriteString(w, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+	addr := startServer(t, s).String()
+	for _, test := range []struct {
+		newline Newline
+		want    string
+	}{
+		{NewlineLF, "line\nend\r"},
+		{NewlineCRLF, "line\r\nend\r"},
+	} {
+		var got bytes.Buffer
+		c := &Client{Timeout: time.Second, Mode: Netascii, Newline: test.newline}
+		if err := c.Get(context.Background(), addr, "file", &got); err != nil || got.String() != test.want {
+			t.Errorf("%v: got %q, %v, want %q", test.newline, got.String(), err, test.want)
+		}
+	}
+}
+
+func TestRequestFromContext(t *testing.T) {
+	requests := make(chan *Request, 1)
+	s := &Server{ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+		r, ok := RequestFromContext(ctx)
+		if !ok {
+			return nil, errors.New("no request")
+		}
+		requests <- r
+		return io.NopCloser(strings.NewReader("data")), nil
+	}}
+	addr := startServer(t, s).(*net.UDPAddr)
+	c := &Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.Filename != "file" || !r.RemoteAddr.(*net.UDPAddr).IP.IsLoopback() || r.LocalAddr.String() != addr.String() {
+		t.Errorf("got request for %s from %v on %v", r.Filename, r.RemoteAddr, r.LocalAddr)
+	}
+	if a, ok := r.TransferAddr.(*net.UDPAddr); !ok || a.Port == 0 || a.Port == addr.Port {
+		t.Errorf("got transfer address %v", r.TransferAddr)
+	}
+	if _, ok := RequestFromContext(context.Background()); ok {
+		t.Error("request of a background context")
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	var log logBuffer
+	failed := make(chan Stats, 1)
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			if r.Filename == "boom" {
+				w.Write([]byte("partial"))
+				panic("handler bug")
+			}
+			_, err := w.Write([]byte("data"))
+			return err
+		}),
+		Logger:          slog.New(slog.NewTextHandler(&log, nil)),
+		OnTransferError: func(r *Request, st Stats) { failed <- st },
+	}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second}
+	var e *Error
+	err := c.Get(context.Background(), addr, "boom", io.Discard)
+	if !errors.As(err, &e) || e.Code != NotDefined || strings.Contains(e.Message, "handler bug") {
+		t.Errorf("got %v, want an internal error", err)
+	}
+	if st := <-failed; !errors.Is(st.Err, ErrHandlerPanic) {
+		t.Errorf("server: got %v, want ErrHandlerPanic", st.Err)
+	}
+	if !strings.Contains(log.String(), "handler bug") || !strings.Contains(log.String(), "TestHandlerPanic") {
+		t.Errorf("panic not logged with the stack: %s", log.String())
+	}
+	// the server keeps serving
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr, "file", &got); err != nil || got.String() != "data" {
+		t.Errorf("got %q, %v", got.String(), err)
+	}
+}
+
+func TestHandlerErrorCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code ErrorCode
+	}{
+		{&os.PathError{Op: "open", Path: "file", Err: syscall.ENOENT}, FileNotFound},
+		{&os.PathError{Op: "open", Path: "file", Err: syscall.EACCES}, AccessViolation},
+		{&os.PathError{Op: "open", Path: "file", Err: syscall.EROFS}, AccessViolation},
+		{&os.PathError{Op: "write", Path: "file", Err: syscall.ENOSPC}, DiskFull},
+		{fmt.Errorf("storing: %w", syscall.EDQUOT), DiskFull},
+		{errors.New("backend unavailable"), NotDefined},
+	} {
+		s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			// fail mid-transfer
+			if r.Op == RRQ {
+				if _, err := w.Write(make([]byte, 1000)); err != nil {
+					return err
+				}
+				return tt.err
+			}
+			if _, err := io.ReadFull(r.Body, make([]byte, 600)); err != nil {
+				return err
+			}
+			return tt.err
+		})}
+		addr := startServer(t, s)
+		c := &Client{Timeout: time.Second}
+		var e *Error
+		err := c.Get(context.Background(), addr.String(), "file", io.Discard)
+		if !errors.As(err, &e) || e.Code != tt.code || !strings.Contains(e.Message, tt.err.Error()) {
+			t.Errorf("RRQ %v: got %v, want %v", tt.err, err, tt.code)
+		}
+		err = c.Put(context.Background(), addr.String(), "file", bytes.NewReader(make([]byte, 4000)))
+		if !errors.As(err, &e) || e.Code != tt.code || !strings.Contains(e.Message, tt.err.Error()) {
+			t.Errorf("WRQ %v: got %v, want %v", tt.err, err, tt.code)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ListenInterface listens on port on each unicast address of the network
+// interface name, port 69 if empty, so that the requests received on it
+// are told apart from those of other interfaces. With port 0 the sockets
+// listen on ephemeral ports.
+func ListenInterface(name, port string) ([]net.PacketConn, error) {
+	return listenInterface(name, port, 1)
+}
+
+// listenInterface is ListenInterface opening n sockets on each address
+func listenInterface(name, port string, n int) (conns []net.PacketConn, err error) {
+	if port == "" {
+		port = "69"
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsMulticast() {
+			continue
+		}
+		host := ipn.IP.String()
+		if ipn.IP.IsLinkLocalUnicast() && ipn.IP.To4() == nil {
+			host += "%" + name
+		}
+		c, err := listenUDP(net.JoinHostPort(host, port), n)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, c...)
+	}
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("tftp: interface %s has no address", name)
+	}
+	return conns, nil
+}
+
+// ListenAndServeAll listens on each of the UDP addresses and serves
+// requests on all of them until Shutdown or Close, or until serving any
+// fails. An address whose host names a network interface, such as
+// "eth1:69", listens on each address of the interface with
+// ListenInterface. ReusePort sockets are opened on each address.
+func (s *Server) ListenAndServeAll(addrs ...string) error {
+	var conns []net.PacketConn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for _, addr := range addrs {
+		c, err := listenAddr(addr, s.conf().ReusePort)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, c...)
+	}
+	return s.ServeAll(context.Background(), conns...)
+}
+
+// listenAddr opens n sockets on the UDP address addr, or on each address
+// of the network interface named by its host
+func listenAddr(addr string, n int) ([]net.PacketConn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		if _, err := net.InterfaceByName(host); err == nil {
+			return listenInterface(host, port, n)
+		}
+	}
+	return listenUDP(addr, n)
+}
+
+// listenUDP listens on the UDP address addr, with n sockets sharing the
+// port with SO_REUSEPORT if n is more than one
+func listenUDP(addr string, n int) ([]net.PacketConn, error) {
+	if n <= 1 {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.PacketConn{conn}, nil
+	}
+	return ListenReusePort(addr, n)
+}
+
+// ListenReusePort opens n sockets listening on the UDP address addr with
+// SO_REUSEPORT, the kernel spreading the packets received over the
+// sockets by peer address, so that the packets of a client reach a single
+// socket. With port 0 all sockets listen on the same ephemeral port. It
+// fails on systems other than Linux and the BSDs.
+func ListenReusePort(addr string, n int) (conns []net.PacketConn, err error) {
+	lc := net.ListenConfig{Control: reusePort}
+	for range max(n, 1) {
+		conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		if len(conns) == 0 {
+			// the other sockets join the port of the first
+			host, _, _ := net.SplitHostPort(addr)
+			addr = net.JoinHostPort(host, strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port))
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// ServeAll serves requests on each of conns as with ServeContext until ctx
+// is done, Shutdown or Close, or until serving any fails, which stops
+// serving the others. It returns the first error.
+func (s *Server) ServeAll(ctx context.Context, conns ...net.PacketConn) error {
+	if len(conns) == 0 {
+		return errors.New("tftp: no conn to serve")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func() {
+			err := s.ServeContext(ctx, conn)
+			cancel()
+			errs <- err
+		}()
+	}
+	// the first error stopped serving the others
+	err := <-errs
+	for range conns[1:] {
+		<-errs
+	}
+	return err
+}
+
+// interfaceOf returns the network interface with the address ip, nil if
+// there is none, as for a wildcard address
+func interfaceOf(ip net.IP) *net.Interface {
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return &iface
+			}
+		}
+	}
+	return nil
+}
+
+// interfaceName returns the name of the network interface with the
+// address of local, empty if there is none
+func interfaceName(local net.Addr) string {
+	a, ok := local.(*net.UDPAddr)
+	if !ok {
+		return ""
+	}
+	if iface := interfaceOf(a.IP); iface != nil {
+		return iface.Name
+	}
+	return ""
+}
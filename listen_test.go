@@ -0,0 +1,85 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeAll(t *testing.T) {
+	lo := interfaceOf(net.IPv4(127, 0, 0, 1))
+	if lo == nil {
+		t.Skip("no loopback interface")
+	}
+	a, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	requests := make(chan *Request, 1)
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+		requests <- r
+		_, err := w.Write([]byte("data"))
+		return err
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ServeAll(ctx, a, b) }()
+
+	c := &Client{Timeout: time.Second}
+	for _, conn := range []net.PacketConn{a, b} {
+		var got bytes.Buffer
+		if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", &got); err != nil || got.String() != "data" {
+			t.Errorf("%v: got %q, %v", conn.LocalAddr(), got.String(), err)
+		}
+		r := <-requests
+		if r.LocalAddr.String() != conn.LocalAddr().String() || r.Interface != lo.Name {
+			t.Errorf("got request on %v of %q, want %v of %q", r.LocalAddr, r.Interface, conn.LocalAddr(), lo.Name)
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestListenInterface(t *testing.T) {
+	lo := interfaceOf(net.IPv4(127, 0, 0, 1))
+	if lo == nil {
+		t.Skip("no loopback interface")
+	}
+	conns, err := ListenInterface(lo.Name, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, conn := range conns {
+		if conn.LocalAddr().(*net.UDPAddr).IP.Equal(net.IPv4(127, 0, 0, 1)) {
+			found = true
+		}
+		if name := interfaceName(conn.LocalAddr()); name != lo.Name {
+			t.Errorf("%v: on interface %q, want %q", conn.LocalAddr(), name, lo.Name)
+		}
+		conn.Close()
+	}
+	if !found {
+		t.Errorf("not listening on 127.0.0.1")
+	}
+	if _, err := ListenInterface("no-such-interface", "0"); err == nil {
+		t.Error("listening on a missing interface")
+	}
+	if err := (&Server{}).ListenAndServeAll("127.0.0.1:0", "no port"); err == nil {
+		t.Error("served an invalid address")
+	}
+	if interfaceName(&net.UDPAddr{IP: net.IPv4zero}) != "" {
+		t.Error("wildcard address on an interface")
+	}
+}
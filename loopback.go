@@ -0,0 +1,276 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ Transport = (*Loopback)(nil)
+
+// Loopback is an in-memory network of packet conns, a Transport for tests
+// of clients, servers and handlers without UDP sockets. Packets are
+// delivered in order after Latency, or dropped if the destination does
+// not exist or has 1024 packets waiting. The zero value is ready to use.
+type Loopback struct {
+	Latency time.Duration // delay of each packet
+	Clock   Clock         // clock of Latency and read deadlines, the system clock if nil
+
+	mu    sync.Mutex
+	conns map[LoopbackAddr]*loopbackConn
+	port  int // last ephemeral port
+}
+
+// LoopbackAddr is the address of a conn of a Loopback
+type LoopbackAddr struct {
+	Host string
+	Port int
+}
+
+// Network returns "loopback"
+func (a LoopbackAddr) Network() string {
+	return "loopback"
+}
+
+// String returns host:port
+func (a LoopbackAddr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// parseLoopbackAddr parses host[:port], port 69 being used if there is
+// none
+func parseLoopbackAddr(addr string) (LoopbackAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return LoopbackAddr{Host: addr, Port: 69}, nil
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return LoopbackAddr{}, errors.New("tftp: invalid loopback port " + port)
+	}
+	return LoopbackAddr{Host: host, Port: int(n)}, nil
+}
+
+// Listen returns a conn at addr, host[:port] with port 69 by default, for
+// a server
+func (l *Loopback) Listen(addr string) (net.PacketConn, error) {
+	a, err := parseLoopbackAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.conns[a]; ok {
+		return nil, errors.New("tftp: loopback address " + a.String() + " in use")
+	}
+	return l.open(a), nil
+}
+
+// Pipe returns two conns at new addresses, each sending to the other at
+// its LocalAddr
+func (l *Loopback) Pipe() (net.PacketConn, net.PacketConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open(l.ephemeral("pipe")), l.open(l.ephemeral("pipe"))
+}
+
+// ResolveAddr returns the address host[:port] with port 69 by default
+func (l *Loopback) ResolveAddr(ctx context.Context, addr string) (net.Addr, error) {
+	return parseLoopbackAddr(addr)
+}
+
+// ListenPacket returns a conn at a new port on the host of local, or of
+// host "client" if local is nil
+func (l *Loopback) ListenPacket(ctx context.Context, local, peer net.Addr) (net.PacketConn, error) {
+	host := "client"
+	if a, ok := local.(LoopbackAddr); ok {
+		host = a.Host
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open(l.ephemeral(host)), nil
+}
+
+// ephemeral returns a free address on host. It must be called with l.mu
+// held.
+func (l *Loopback) ephemeral(host string) LoopbackAddr {
+	for {
+		l.port++
+		if l.port < 49152 || l.port > 65535 {
+			l.port = 49152
+		}
+		a := LoopbackAddr{Host: host, Port: l.port}
+		if _, ok := l.conns[a]; !ok {
+			return a
+		}
+	}
+}
+
+// open returns a new conn at a. It must be called with l.mu held.
+func (l *Loopback) open(a LoopbackAddr) *loopbackConn {
+	if l.conns == nil {
+		l.conns = make(map[LoopbackAddr]*loopbackConn)
+	}
+	c := &loopbackConn{l: l, addr: a, notify: make(chan struct{}, 1), done: make(chan struct{})}
+	l.conns[a] = c
+	return c
+}
+
+// send queues p for the conn at addr, from from
+func (l *Loopback) send(p []byte, from LoopbackAddr, addr net.Addr) {
+	a, ok := addr.(LoopbackAddr)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	c := l.conns[a]
+	l.mu.Unlock()
+	if c != nil {
+		c.queue(loopbackPacket{append([]byte(nil), p...), from, orSystem(l.Clock).Now().Add(l.Latency)})
+	}
+}
+
+// loopbackPacket is a packet waiting for delivery
+type loopbackPacket struct {
+	p    []byte
+	from LoopbackAddr
+	due  time.Time
+}
+
+// maxLoopbackQueue is the number of packets waiting for a conn beyond
+// which packets are dropped
+const maxLoopbackQueue = 1024
+
+// loopbackConn is a conn of a Loopback
+type loopbackConn struct {
+	l      *Loopback
+	addr   LoopbackAddr
+	notify chan struct{} // signalled when the state changes
+	done   chan struct{} // closed by Close
+
+	mu       sync.Mutex
+	packets  []loopbackPacket
+	deadline time.Time
+	closed   bool
+}
+
+// queue adds p to the packets waiting for delivery
+func (c *loopbackConn) queue(p loopbackPacket) {
+	c.mu.Lock()
+	if !c.closed && len(c.packets) < maxLoopbackQueue {
+		c.packets = append(c.packets, p)
+	}
+	c.mu.Unlock()
+	c.signal()
+}
+
+// signal wakes a waiting ReadFrom
+func (c *loopbackConn) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ReadFrom reads the next packet once it is due
+func (c *loopbackConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, nil, net.ErrClosed
+		}
+		clock := orSystem(c.l.Clock)
+		now := clock.Now()
+		if !c.deadline.IsZero() && !now.Before(c.deadline) {
+			c.mu.Unlock()
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		var wait time.Duration = -1
+		if len(c.packets) > 0 {
+			pkt := c.packets[0]
+			if !now.Before(pkt.due) {
+				c.packets = c.packets[1:]
+				c.mu.Unlock()
+				return copy(p, pkt.p), pkt.from, nil
+			}
+			wait = pkt.due.Sub(now)
+		}
+		if !c.deadline.IsZero() {
+			if d := c.deadline.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+		}
+		c.mu.Unlock()
+		if wait < 0 {
+			select {
+			case <-c.notify:
+			case <-c.done:
+			}
+			continue
+		}
+		timer := clock.AfterFunc(wait, c.signal)
+		select {
+		case <-c.notify:
+		case <-c.done:
+		}
+		timer.Stop()
+	}
+}
+
+// WriteTo sends p to addr
+func (c *loopbackConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	c.l.send(p, c.addr, addr)
+	return len(p), nil
+}
+
+// Close frees the address of the conn
+func (c *loopbackConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.packets = nil
+	c.mu.Unlock()
+	c.l.mu.Lock()
+	delete(c.l.conns, c.addr)
+	c.l.mu.Unlock()
+	close(c.done)
+	return nil
+}
+
+// LocalAddr returns the address of the conn
+func (c *loopbackConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline sets the read deadline, writes do not block
+func (c *loopbackConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *loopbackConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	c.signal()
+	return nil
+}
+
+// SetWriteDeadline has no effect, writes do not block
+func (c *loopbackConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
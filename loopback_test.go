@@ -0,0 +1,75 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoopback(t *testing.T) {
+	l := &Loopback{Latency: time.Millisecond}
+	conn, err := l.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Listen("server:69"); err == nil {
+		t.Error("address in use accepted")
+	}
+	s := &Server{Handler: &MemFS{Writable: true}, Transport: l}
+	go s.Serve(conn)
+	defer s.Close()
+
+	c := &Client{Timeout: time.Second, BlockSize: 1024, WindowSize: 8, Transport: l}
+	content := bytes.Repeat([]byte("0123456789"), 5000)
+	if err := c.Put(context.Background(), "server", "file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), "server:69", "file", &got); err != nil || !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("got %d bytes, %v, want %d", got.Len(), err, len(content))
+	}
+	// nothing listens at other addresses
+	c.Timeout, c.Retries = 10*time.Millisecond, 1
+	if err := c.Get(context.Background(), "other", "file", &got); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestLoopbackPipe(t *testing.T) {
+	l := &Loopback{Latency: 20 * time.Millisecond}
+	a, b := l.Pipe()
+	defer a.Close()
+	defer b.Close()
+	start := time.Now()
+	for _, p := range []string{"one", "two"} {
+		a.WriteTo([]byte(p), b.LocalAddr())
+	}
+	buf := make([]byte, 16)
+	for _, want := range []string{"one", "two"} {
+		n, from, err := b.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != want || from != a.LocalAddr() {
+			t.Fatalf("got %q from %v, %v, want %q from %v", buf[:n], from, err, want, a.LocalAddr())
+		}
+	}
+	if d := time.Since(start); d < l.Latency {
+		t.Errorf("delivered after %v, want at least %v", d, l.Latency)
+	}
+
+	a.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, _, err := a.ReadFrom(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	a.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := a.ReadFrom(buf)
+		done <- err
+	}()
+	a.Close()
+	if err := <-done; err == nil {
+		t.Error("read from a closed conn succeeded")
+	}
+}
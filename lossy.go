@@ -0,0 +1,150 @@
+package tftp
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+var _ Transport = (*Lossy)(nil)
+
+// Lossy is a Transport wrapping the conns of another Transport to drop,
+// duplicate, delay and reorder the packets they send, for tests of
+// retransmission and windowing. Its random choices follow from Seed, so
+// that a test sending packets in the same order loses the same packets.
+type Lossy struct {
+	Transport Transport // transport of the conns
+
+	Drop      float64       // probability a packet is dropped
+	Duplicate float64       // probability a packet is sent twice
+	Delay     float64       // probability a packet is sent DelayTime late
+	Reorder   float64       // probability a packet is sent after the next one, or DelayTime late if none follows
+	DelayTime time.Duration // delay of late packets, 10 milliseconds if zero
+	Seed      uint64        // seed of the random choices
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// ResolveAddr returns the address of the server addr of a client
+func (l *Lossy) ResolveAddr(ctx context.Context, addr string) (net.Addr, error) {
+	return l.Transport.ResolveAddr(ctx, addr)
+}
+
+// ListenPacket returns a new conn of the transport, losing packets
+func (l *Lossy) ListenPacket(ctx context.Context, local, peer net.Addr) (net.PacketConn, error) {
+	conn, err := l.Transport.ListenPacket(ctx, local, peer)
+	if err != nil {
+		return nil, err
+	}
+	return l.Wrap(conn), nil
+}
+
+// Wrap returns conn losing the packets it sends, for the listening conn
+// of a server
+func (l *Lossy) Wrap(conn net.PacketConn) net.PacketConn {
+	return &lossyConn{PacketConn: conn, l: l}
+}
+
+// fate is what happens to a packet
+type fate struct {
+	drop, duplicate, delay, reorder bool
+}
+
+// fate returns the fate of the next packet
+func (l *Lossy) fate() fate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rng == nil {
+		l.rng = rand.New(rand.NewPCG(l.Seed, l.Seed))
+	}
+	// a choice is drawn for each, so that the choices for a packet do
+	// not depend on the probabilities of the others
+	return fate{
+		drop:      l.rng.Float64() < l.Drop,
+		duplicate: l.rng.Float64() < l.Duplicate,
+		delay:     l.rng.Float64() < l.Delay,
+		reorder:   l.rng.Float64() < l.Reorder,
+	}
+}
+
+// delayTime returns the delay of late packets
+func (l *Lossy) delayTime() time.Duration {
+	if l.DelayTime <= 0 {
+		return 10 * time.Millisecond
+	}
+	return l.DelayTime
+}
+
+// lossyConn is a conn of a Lossy
+type lossyConn struct {
+	net.PacketConn
+	l *Lossy
+
+	mu   sync.Mutex
+	held *heldPacket // packet to send after the next one
+}
+
+// heldPacket is a packet sent late
+type heldPacket struct {
+	p      []byte
+	addr   net.Addr
+	copies int
+	timer  *time.Timer
+}
+
+// send sends the packet
+func (c *lossyConn) send(h *heldPacket) {
+	for range h.copies {
+		c.PacketConn.WriteTo(h.p, h.addr)
+	}
+}
+
+// WriteTo sends p to addr, or not
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	f := c.l.fate()
+	if f.drop {
+		return len(p), nil
+	}
+	h := &heldPacket{p: append([]byte(nil), p...), addr: addr, copies: 1}
+	if f.duplicate {
+		h.copies = 2
+	}
+	if f.delay {
+		time.AfterFunc(c.l.delayTime(), func() { c.send(h) })
+		return len(p), nil
+	}
+	c.mu.Lock()
+	held := c.held
+	c.held = nil
+	if f.reorder && held == nil {
+		c.held = h
+		h.timer = time.AfterFunc(c.l.delayTime(), func() { c.release(h) })
+		c.mu.Unlock()
+		return len(p), nil
+	}
+	c.mu.Unlock()
+	n, err := c.PacketConn.WriteTo(h.p, addr)
+	if h.copies > 1 {
+		c.PacketConn.WriteTo(h.p, addr)
+	}
+	if held != nil {
+		held.timer.Stop()
+		c.send(held)
+	}
+	return n, err
+}
+
+// release sends h if it is still held
+func (c *lossyConn) release(h *heldPacket) {
+	c.mu.Lock()
+	if c.held != h {
+		c.mu.Unlock()
+		return
+	}
+	c.held = nil
+	c.mu.Unlock()
+	c.send(h)
+}
@@ -0,0 +1,82 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLossyFate(t *testing.T) {
+	a := &Lossy{Drop: 0.3, Duplicate: 0.2, Delay: 0.1, Reorder: 0.1, Seed: 42}
+	b := &Lossy{Drop: 0.3, Duplicate: 0.2, Delay: 0.1, Reorder: 0.1, Seed: 42}
+	drops := 0
+	for range 1000 {
+		fa, fb := a.fate(), b.fate()
+		if fa != fb {
+			t.Fatal("same seed, different fates")
+		}
+		if fa.drop {
+			drops++
+		}
+	}
+	if drops < 250 || drops > 350 {
+		t.Errorf("dropped %d of 1000 packets, want about 300", drops)
+	}
+}
+
+func TestLossyOrder(t *testing.T) {
+	l := &Loopback{}
+	a, b := l.Pipe()
+	defer a.Close()
+	defer b.Close()
+	// the first packet is held back until the second is sent
+	lossy := &Lossy{Transport: l, Reorder: 1, Duplicate: 1, DelayTime: time.Hour}
+	w := lossy.Wrap(a)
+	w.WriteTo([]byte("1"), b.LocalAddr())
+	w.WriteTo([]byte("2"), b.LocalAddr())
+	buf := make([]byte, 1)
+	var got []byte
+	for range 4 {
+		b.SetReadDeadline(time.Now().Add(time.Second))
+		if _, _, err := b.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[0])
+	}
+	if string(got) != "2211" {
+		t.Errorf("got %q, want 2211", got)
+	}
+}
+
+func TestLossyTransfer(t *testing.T) {
+	l := &Loopback{}
+	lossy := &Lossy{Transport: l, Drop: 0.05, Duplicate: 0.05, Delay: 0.05, Reorder: 0.05, DelayTime: 5 * time.Millisecond, Seed: 1}
+	conn, err := l.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Handler: &MemFS{Writable: true}, Transport: lossy, Timeout: 20 * time.Millisecond, Retries: 20}
+	go s.Serve(lossy.Wrap(conn))
+	defer s.Close()
+
+	var retransmits int
+	c := &Client{
+		Timeout:    20 * time.Millisecond,
+		Retries:    20,
+		WindowSize: 4,
+		Transport:  lossy,
+		OnComplete: func(st Stats) { retransmits += st.Retransmits },
+	}
+	content := bytes.Repeat([]byte("0123456789"), 2000)
+	if err := c.Put(context.Background(), "server", "file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), "server", "file", &got); err != nil || !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("got %d bytes, %v, want %d", got.Len(), err, len(content))
+	}
+	if retransmits == 0 {
+		t.Error("no retransmissions")
+	}
+}
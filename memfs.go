@@ -0,0 +1,120 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// errTooLarge is returned when an upload exceeds the size limit
+var errTooLarge = errors.New("tftp: file too large")
+
+// MemFS is a Handler serving files held in memory. Files can be set and
+// removed while serving, uploads are accepted if Writable is set. It is
+// safe for concurrent use.
+type MemFS struct {
+	Writable    bool  // accept WRQ uploads
+	MaxFileSize int64 // largest accepted upload, unlimited if zero
+
+	// Overwrite reports whether an upload may replace the existing file
+	// name; if nil, any file may be replaced. Refused uploads fail with
+	// FileAlreadyExists.
+	Overwrite func(name string) bool
+
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// memName returns the name a file is stored under
+func memName(filename string) string {
+	return strings.TrimLeft(filename, "/")
+}
+
+// Set sets the contents of a file to a copy of data
+func (m *MemFS) Set(name string, data []byte) {
+	m.store(memName(name), bytes.Clone(data))
+}
+
+// store stores data under name
+func (m *MemFS) store(name string, data []byte) {
+	m.storeNew(name, data, true)
+}
+
+// storeNew stores data under name unless the file exists and overwrite is
+// false, and reports whether it did
+func (m *MemFS) storeNew(name string, data []byte, overwrite bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	if _, ok := m.files[name]; ok && !overwrite {
+		return false
+	}
+	m.files[name] = data
+	return true
+}
+
+// Get returns the contents of a file, which must not be modified
+func (m *MemFS) Get(name string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.files[memName(name)]
+	return data, ok
+}
+
+// Remove removes a file
+func (m *MemFS) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, memName(name))
+}
+
+// ServeTFTP serves a file for a RRQ and stores an upload for a WRQ once it
+// is complete
+func (m *MemFS) ServeTFTP(w ResponseWriter, r *Request) error {
+	if r.Op == WRQ {
+		return m.upload(r)
+	}
+	data, ok := m.Get(r.Filename)
+	if !ok {
+		return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrNotExist}
+	}
+	w.SetSize(int64(len(data)))
+	_, err := w.Write(data)
+	return err
+}
+
+// upload stores the file written by the client of a WRQ
+func (m *MemFS) upload(r *Request) error {
+	if !m.Writable {
+		return &fs.PathError{Op: "write", Path: r.Filename, Err: fs.ErrPermission}
+	}
+	name := memName(r.Filename)
+	overwrite := m.Overwrite == nil || m.Overwrite(name)
+	exists := &fs.PathError{Op: "create", Path: r.Filename, Err: fs.ErrExist}
+	if _, ok := m.Get(name); ok && !overwrite {
+		return exists
+	}
+	body := r.Body
+	if m.MaxFileSize > 0 {
+		if r.Size > m.MaxFileSize {
+			return errTooLarge
+		}
+		body = io.LimitReader(body, m.MaxFileSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if m.MaxFileSize > 0 && int64(len(data)) > m.MaxFileSize {
+		return errTooLarge
+	}
+	if !m.storeNew(name, data, overwrite) {
+		return exists
+	}
+	return nil
+}
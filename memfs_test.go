@@ -0,0 +1,73 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestMemFS(t *testing.T) {
+	m := &MemFS{Writable: true, MaxFileSize: 1000}
+	m.Set("boot.ipxe", []byte("#!ipxe\n"))
+	addr := startServer(t, &Server{Handler: m}).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+
+	var got bytes.Buffer
+	if err := c.Get(ctx, addr, "/boot.ipxe", &got); err != nil || got.String() != "#!ipxe\n" {
+		t.Errorf("got %q, %v", got.String(), err)
+	}
+	m.Set("boot.ipxe", []byte("#!ipxe\nchain next\n"))
+	got.Reset()
+	if err := c.Get(ctx, addr, "boot.ipxe", &got); err != nil || got.String() != "#!ipxe\nchain next\n" {
+		t.Errorf("after update: got %q, %v", got.String(), err)
+	}
+
+	upload := bytes.Repeat([]byte("u"), 1000)
+	if err := c.Put(ctx, addr, "upload", bytes.NewReader(upload)); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := m.Get("upload"); !ok || !bytes.Equal(b, upload) {
+		t.Errorf("got %d bytes, want %d", len(b), len(upload))
+	}
+	// without tsize the limit applies while reading
+	if err := c.Put(ctx, addr, "large", io.MultiReader(bytes.NewReader(append(upload, 'u')))); err == nil {
+		t.Error("upload over the limit accepted")
+	}
+	if err := c.Put(ctx, addr, "large", bytes.NewReader(append(upload, 'u'))); err == nil {
+		t.Error("upload announced over the limit accepted")
+	}
+	if _, ok := m.Get("large"); ok {
+		t.Error("file over the limit stored")
+	}
+
+	m.Remove("boot.ipxe")
+	if err := c.Get(ctx, addr, "boot.ipxe", &got); err == nil {
+		t.Error("removed file served")
+	}
+}
+
+func TestMemFSOverwrite(t *testing.T) {
+	m := &MemFS{Writable: true, Overwrite: func(name string) bool { return name == "scratch" }}
+	m.Set("image", []byte("image"))
+	m.Set("scratch", []byte("scratch"))
+	addr := startServer(t, &Server{Handler: m}).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+
+	if err := c.Put(ctx, addr, "image", bytes.NewReader([]byte("replaced"))); !errors.Is(err, ErrFileExists) || !errors.Is(err, fs.ErrExist) {
+		t.Errorf("got %v, want ErrFileExists", err)
+	}
+	if b, _ := m.Get("image"); string(b) != "image" {
+		t.Errorf("protected file replaced by %q", b)
+	}
+	for _, name := range []string{"scratch", "new"} {
+		if err := c.Put(ctx, addr, name, bytes.NewReader([]byte("replaced"))); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package metrics exports Prometheus metrics of a tftp.Server
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// Metrics collects metrics of the transfers of a server. It is a
+// prometheus.Collector to be registered with a prometheus.Registerer.
+type Metrics struct {
+	server      *tftp.Server
+	requests    *prometheus.CounterVec
+	transfers   *prometheus.CounterVec
+	bytes       *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	retransmits prometheus.Counter
+	active      prometheus.GaugeFunc
+}
+
+// New returns the metrics of s, collected by chaining s.OnComplete. It
+// must be called before s starts serving.
+func New(s *tftp.Server) *Metrics {
+	m := &Metrics{
+		server: s,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tftp_requests_total",
+			Help: "Requests handled by opcode.",
+		}, []string{"op"}),
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tftp_transfers_total",
+			Help: "Transfers by opcode and result.",
+		}, []string{"op", "result"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tftp_transfer_bytes_total",
+			Help: "File data transferred by opcode.",
+		}, []string{"op"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tftp_transfer_duration_seconds",
+			Help:    "Duration of transfers by opcode.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 9),
+		}, []string{"op"}),
+		retransmits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tftp_retransmits_total",
+			Help: "Timeouts and retransmissions.",
+		}),
+	}
+	m.active = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "tftp_active_transfers",
+		Help: "Transfers in progress.",
+	}, func() float64 {
+		return float64(s.Stats().Active)
+	})
+	next := s.OnComplete
+	s.OnComplete = func(st tftp.Stats) {
+		m.observe(st)
+		if next != nil {
+			next(st)
+		}
+	}
+	return m
+}
+
+// observe records a completed transfer
+func (m *Metrics) observe(st tftp.Stats) {
+	op := st.Op.String()
+	result := "success"
+	if st.Err != nil {
+		result = "failure"
+	}
+	m.requests.WithLabelValues(op).Inc()
+	m.transfers.WithLabelValues(op, result).Inc()
+	m.bytes.WithLabelValues(op).Add(float64(st.Bytes))
+	m.duration.WithLabelValues(op).Observe(st.Duration.Seconds())
+	m.retransmits.Add(float64(st.Retransmits))
+}
+
+// collectors returns the collectors of the metrics
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.requests, m.transfers, m.bytes, m.duration, m.retransmits, m.active}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
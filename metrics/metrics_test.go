@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+func TestMetrics(t *testing.T) {
+	completed := make(chan tftp.Stats, 1)
+	s := &tftp.Server{
+		ReadHandler: func(ctx context.Context, filename string, mode tftp.Mode) (io.ReadCloser, error) {
+			if filename != "file" {
+				return nil, errors.New("not found")
+			}
+			return io.NopCloser(bytes.NewReader(make([]byte, 1000))), nil
+		},
+		OnComplete: func(st tftp.Stats) { completed <- st },
+	}
+	m := New(s)
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.Serve(conn)
+
+	c := &tftp.Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	<-completed
+	c.Get(context.Background(), conn.LocalAddr().String(), "missing", io.Discard)
+	<-completed
+
+	want := `
+# HELP tftp_active_transfers Transfers in progress.
+# TYPE tftp_active_transfers gauge
+tftp_active_transfers 0
+# HELP tftp_requests_total Requests handled by opcode.
+# TYPE tftp_requests_total counter
+tftp_requests_total{op="RRQ"} 2
+# HELP tftp_transfer_bytes_total File data transferred by opcode.
+# TYPE tftp_transfer_bytes_total counter
+tftp_transfer_bytes_total{op="RRQ"} 1000
+# HELP tftp_transfers_total Transfers by opcode and result.
+# TYPE tftp_transfers_total counter
+tftp_transfers_total{op="RRQ",result="failure"} 1
+tftp_transfers_total{op="RRQ",result="success"} 1
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"tftp_active_transfers", "tftp_requests_total", "tftp_transfer_bytes_total", "tftp_transfers_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(m, "tftp_transfer_duration_seconds"); n != 1 {
+		t.Errorf("got %d duration series, want 1", n)
+	}
+}
+
+func TestMetricsReload(t *testing.T) {
+	m := &tftp.MemFS{}
+	m.Set("file", make([]byte, 1000))
+	completed := make(chan tftp.Stats, 1)
+	s := &tftp.Server{Handler: m, OnComplete: func(st tftp.Stats) { completed <- st }}
+	metrics := New(s)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.Serve(conn)
+
+	c := &tftp.Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	<-completed
+	s.Reload(&tftp.Server{Handler: m})
+	if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	<-completed
+	if n := testutil.ToFloat64(metrics.requests.WithLabelValues("RRQ")); n != 2 {
+		t.Errorf("got %v requests counted, want 2 across Reload", n)
+	}
+}
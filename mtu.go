@@ -0,0 +1,57 @@
+package tftp
+
+import "net"
+
+// Sizes of the headers in front of the data of a DATA packet
+const (
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	udpHeaderSize  = 8
+	dataHeaderSize = 4
+)
+
+// mtuBlockSize returns the largest block size whose DATA packets to ip
+// fit in mtu bytes, so that they are not fragmented
+func mtuBlockSize(mtu int, ip net.IP) int {
+	n := mtu - udpHeaderSize - dataHeaderSize - ipv6HeaderSize
+	if ip.To4() != nil {
+		n = mtu - udpHeaderSize - dataHeaderSize - ipv4HeaderSize
+	}
+	return max(n, minBlockSize)
+}
+
+// pathMTU returns the MTU of the interface packets to peer are sent on,
+// 0 if it is not known. The local address routing to peer is found by
+// connecting a UDP socket, which sends no packet.
+func pathMTU(peer net.Addr) int {
+	raddr, ok := peer.(*net.UDPAddr)
+	if !ok {
+		return 0
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return 0
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+	if iface := interfaceOf(local); iface != nil {
+		return iface.MTU
+	}
+	return 0
+}
+
+// clampBlockSize lowers the block size in oack so that DATA packets to
+// peer fit the MTU of the interface they are sent on
+func clampBlockSize(oack map[option]int, peer net.Addr) {
+	n, ok := oack[blksize]
+	if !ok {
+		return
+	}
+	mtu := pathMTU(peer)
+	if mtu <= 0 {
+		return
+	}
+	if limit := mtuBlockSize(mtu, peer.(*net.UDPAddr).IP); n > limit {
+		oack[blksize] = limit
+	}
+}
@@ -0,0 +1,42 @@
+package tftp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMTUBlockSize(t *testing.T) {
+	for _, test := range []struct {
+		mtu  int
+		ip   string
+		want int
+	}{
+		{1500, "192.0.2.1", 1468},
+		{1500, "2001:db8::1", 1448},
+		{9000, "192.0.2.1", 8968},
+		{20, "192.0.2.1", minBlockSize},
+	} {
+		if got := mtuBlockSize(test.mtu, net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("%d %s: got %d, want %d", test.mtu, test.ip, got, test.want)
+		}
+	}
+}
+
+func TestClampBlockSize(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 69}
+	mtu := pathMTU(peer)
+	if mtu <= 0 {
+		t.Skip("loopback MTU not known")
+	}
+	oack := map[option]int{blksize: maxBlockSize}
+	clampBlockSize(oack, peer)
+	if want := min(maxBlockSize, mtuBlockSize(mtu, peer.IP)); oack[blksize] != want {
+		t.Errorf("loopback MTU %d: got block size %d, want %d", mtu, oack[blksize], want)
+	}
+	// no block size is negotiated if not requested
+	oack = map[option]int{}
+	clampBlockSize(oack, peer)
+	if _, ok := oack[blksize]; ok {
+		t.Error("block size added")
+	}
+}
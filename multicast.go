@@ -0,0 +1,519 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMulticastGroups is the default number of multicast groups
+const defaultMulticastGroups = 16
+
+// multicastSession is a RFC 2090 multicast transfer of a file to a group of
+// clients. The first client is the master client, it acknowledges the
+// blocks that are sent to the group. When the master client is done, the
+// next client becomes master.
+type multicastSession struct {
+	s       *Server
+	ctx     context.Context
+	key     string
+	conn    net.PacketConn
+	group   *net.UDPAddr
+	oack    map[option]int
+	content io.ReaderAt
+	size    int64
+	blksize int
+	last    block // final block
+	timeout time.Duration
+	retries int
+	clock   Clock
+	clients []net.Addr // protected by s.mu, clients[0] is the master client
+	closer  io.Closer
+}
+
+// serveMulticast serves a RRQ with the multicast option, joining the
+// session for the same file if there is one. It returns false if the
+// request must be served as a unicast transfer instead.
+func (s *Server) serveMulticast(r *Request, oack map[option]int) bool {
+	delete(oack, windowsize)
+	delete(oack, offset)
+	r.Offset = 0
+	blocksize := defaultBlockSize
+	if n, ok := oack[blksize]; ok {
+		blocksize = n
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%d", r.Filename, r.Mode, blocksize)
+	peer := r.RemoteAddr
+
+	s.mu.Lock()
+	if m, ok := s.multicast[key]; ok {
+		m.join(peer)
+		s.mu.Unlock()
+		return true
+	}
+	group := s.allocateGroup()
+	s.mu.Unlock()
+	if group == nil {
+		return false
+	}
+
+	m := &multicastSession{
+		s:       s,
+		ctx:     r.Context(),
+		key:     key,
+		group:   group,
+		oack:    oack,
+		blksize: blocksize,
+		timeout: defaultTimeout,
+		retries: defaultRetries,
+		clients: []net.Addr{peer},
+	}
+	c := s.conf()
+	m.clock = orSystem(c.Clock)
+	if c.Timeout > 0 {
+		m.timeout = c.Timeout
+	}
+	if c.Retries > 0 {
+		m.retries = c.Retries
+	}
+	if n, ok := oack[timeout]; ok {
+		m.timeout = time.Duration(n) * time.Second
+	}
+	var err error
+	if m.conn, err = c.listen(r.LocalAddr, nil); err != nil {
+		s.releaseGroup(group)
+		return false
+	}
+	if err := m.open(c, r); err != nil {
+		m.conn.WriteTo(errorPacket(err, NotDefined), peer)
+		m.conn.Close()
+		s.releaseGroup(group)
+		return true
+	}
+	if c.MaxFileSize > 0 && m.size > c.MaxFileSize {
+		m.conn.WriteTo(errorPacket(errFileSize, NotDefined), peer)
+		m.close()
+		s.releaseGroup(group)
+		return true
+	}
+	if m.size/int64(blocksize)+1 > 65535 {
+		// too many blocks to address without rollover
+		m.close()
+		s.releaseGroup(group)
+		return false
+	}
+	if _, ok := oack[tsize]; ok {
+		oack[tsize] = int(m.size)
+	}
+
+	s.mu.Lock()
+	if other, ok := s.multicast[key]; ok {
+		// lost a race with another session for the same file
+		other.join(peer)
+		s.mu.Unlock()
+		m.close()
+		s.releaseGroup(group)
+		return true
+	}
+	s.multicast[key] = m
+	s.mu.Unlock()
+
+	m.run()
+	return true
+}
+
+// allocateGroup returns an unused multicast group, or nil if all groups are
+// in use. It must be called with s.mu held.
+func (s *Server) allocateGroup() *net.UDPAddr {
+	if s.multicast == nil {
+		s.multicast = make(map[string]*multicastSession)
+		s.groups = make(map[string]bool)
+	}
+	n := s.MulticastGroups
+	if n <= 0 {
+		n = defaultMulticastGroups
+	}
+	for i := 0; i < n; i++ {
+		group := &net.UDPAddr{IP: addIP(s.Multicast.IP, i), Port: s.Multicast.Port, Zone: s.Multicast.Zone}
+		if !s.groups[group.String()] {
+			s.groups[group.String()] = true
+			return group
+		}
+	}
+	return nil
+}
+
+// releaseGroup returns a multicast group to the pool
+func (s *Server) releaseGroup(group *net.UDPAddr) {
+	s.mu.Lock()
+	delete(s.groups, group.String())
+	s.mu.Unlock()
+}
+
+// addIP returns ip incremented by n
+func addIP(ip net.IP, n int) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	r := append(net.IP(nil), ip...)
+	for i := len(r) - 1; i >= 0 && n > 0; i-- {
+		sum := int(r[i]) + n
+		r[i] = byte(sum)
+		n = sum >> 8
+	}
+	return r
+}
+
+// open opens the file for the session with the handlers of the server
+// configuration c, buffering it in memory unless it supports random access
+// and needs no conversion
+func (m *multicastSession) open(c *Server, r *Request) error {
+	if c.Handler == nil && c.ReadHandler == nil && c.Backend == nil {
+		return &Error{Code: AccessViolation, Message: "read not allowed"}
+	}
+	if c.Handler != nil || c.ReadHandler == nil {
+		log := discard
+		if c.Logger != nil {
+			log = c.Logger.With("peer", r.RemoteAddr.String(), "op", r.Op.String(), "filename", r.Filename)
+		}
+		var w bufferResponse
+		if err := serveRecover(log, c.handler(), &w, r); err != nil {
+			return err
+		}
+		b := w.Bytes()
+		if r.Mode == Netascii {
+			b = appendNetascii(nil, b)
+		}
+		m.content, m.size = bytes.NewReader(b), int64(len(b))
+		return nil
+	}
+	rc, err := c.ReadHandler(m.ctx, r.Filename, r.Mode)
+	if err != nil {
+		return err
+	}
+	var src io.Reader = rc
+	if r.Mode == Netascii {
+		src = NewNetasciiReader(rc)
+	} else if ra, ok := rc.(io.ReaderAt); ok {
+		if n, ok := transferSize(rc); ok {
+			m.content, m.size, m.closer = ra, n, rc
+			return nil
+		}
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	m.content, m.size = bytes.NewReader(b), int64(len(b))
+	return nil
+}
+
+// bufferResponse is a ResponseWriter buffering the file in memory
+type bufferResponse struct {
+	bytes.Buffer
+}
+
+// SetSize does nothing, the size is known once the file is buffered
+func (*bufferResponse) SetSize(int64) {}
+
+// close releases the resources of the session
+func (m *multicastSession) close() {
+	m.conn.Close()
+	if m.closer != nil {
+		m.closer.Close()
+	}
+}
+
+// join adds a client to the session. It must be called with s.mu held.
+func (m *multicastSession) join(peer net.Addr) {
+	for i, c := range m.clients {
+		if c.String() == peer.String() {
+			// retransmitted request
+			m.conn.WriteTo(m.oackPacket(i == 0), peer)
+			return
+		}
+	}
+	m.clients = append(m.clients, peer)
+	m.conn.WriteTo(m.oackPacket(false), peer)
+}
+
+// leave removes a client from the session
+func (m *multicastSession) leave(peer net.Addr) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	for i, c := range m.clients {
+		if c.String() == peer.String() {
+			m.clients = append(m.clients[:i], m.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// master returns the master client, or nil when all clients are done or
+// the server stops, which ends the session
+func (m *multicastSession) master() net.Addr {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	if len(m.clients) == 0 || m.ctx.Err() != nil {
+		delete(m.s.multicast, m.key)
+		delete(m.s.groups, m.group.String())
+		return nil
+	}
+	return m.clients[0]
+}
+
+// oackPacket returns the OACK for a client
+func (m *multicastSession) oackPacket(master bool) packet {
+	return newMulticastOACKPacket(m.oack, m.group, master)
+}
+
+// run serves the clients in turn until all are done
+func (m *multicastSession) run() {
+	defer m.close()
+	defer context.AfterFunc(m.ctx, func() {
+		m.conn.SetReadDeadline(time.Unix(1, 0))
+	})()
+	m.last = block(m.size/int64(m.blksize) + 1)
+	buf := make([]byte, 4+m.blksize)
+	data := make([]byte, m.blksize)
+	for {
+		master := m.master()
+		if master == nil {
+			return
+		}
+		// make the client master, it acknowledges the blocks it has
+		p := m.oackPacket(true)
+		to := master
+		for try := 0; ; {
+			if _, err := m.conn.WriteTo(p, to); err != nil {
+				m.leave(master)
+				break
+			}
+			ack, err := m.await(master, buf, try)
+			if m.ctx.Err() != nil {
+				break
+			}
+			if err == ErrTimeout && try < m.retries {
+				try++
+				continue
+			}
+			if err != nil || ack >= m.last {
+				m.leave(master)
+				break
+			}
+			// send the block following the acknowledged one to the group
+			n, err := m.content.ReadAt(data, int64(ack)*int64(m.blksize))
+			if err != nil && err != io.EOF {
+				m.conn.WriteTo(errorPacket(err, NotDefined), master)
+				m.leave(master)
+				break
+			}
+			p, to, try = newDATAPacket(ack+1, data[:n]), m.group, 0
+		}
+	}
+}
+
+// await waits for an ACK from the master client, removing other clients
+// that send an ERROR or are done
+func (m *multicastSession) await(master net.Addr, buf []byte, try int) (block, error) {
+	m.conn.SetReadDeadline(m.clock.Now().Add(backoff(m.timeout, try)))
+	for {
+		n, addr, err := m.conn.ReadFrom(buf)
+		if isTimeout(err) {
+			return 0, ErrTimeout
+		}
+		if err != nil {
+			return 0, err
+		}
+		p := packet(buf[:n])
+		if addr.String() != master.String() {
+			if p.opcode() == ERROR || p.opcode() == ACK && p.block() == m.last {
+				m.leave(addr)
+			}
+			continue
+		}
+		switch p.opcode() {
+		case ERROR:
+			return 0, peerError(p)
+		case ACK:
+			return p.block(), nil
+		}
+	}
+}
+
+// multicastValue returns the value of the multicast option for a client
+func multicastValue(group *net.UDPAddr, master bool) string {
+	mc := 0
+	if master {
+		mc = 1
+	}
+	return group.IP.String() + "," + strconv.Itoa(group.Port) + "," + strconv.Itoa(mc)
+}
+
+// parseMulticast parses the value of the multicast option in an OACK. The
+// address and port are empty in an OACK that only changes the master
+// client.
+func parseMulticast(v string) (group *net.UDPAddr, master bool, err error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 3 {
+		return nil, false, fmt.Errorf("%w: invalid multicast option %q", ErrOptionNegotiation, v)
+	}
+	switch parts[2] {
+	case "0":
+	case "1":
+		master = true
+	default:
+		return nil, false, fmt.Errorf("%w: invalid multicast option %q", ErrOptionNegotiation, v)
+	}
+	if parts[0] == "" && parts[1] == "" {
+		return nil, master, nil
+	}
+	ip := net.ParseIP(parts[0])
+	port, err := strconv.Atoi(parts[1])
+	if ip == nil || !ip.IsMulticast() || err != nil || port <= 0 || port > 65535 {
+		return nil, false, fmt.Errorf("%w: invalid multicast option %q", ErrOptionNegotiation, v)
+	}
+	return &net.UDPAddr{IP: ip, Port: port}, master, nil
+}
+
+// multicastEvent is a packet received by a multicast client
+type multicastEvent struct {
+	p   packet
+	err error
+}
+
+// getMulticast completes a Get as a RFC 2090 multicast client. Blocks sent
+// to the group are received in any order, the client acknowledges the
+// blocks it has received in order while it is the master client.
+func (c *Client) getMulticast(t *transfer, v string, w io.Writer) error {
+	group, master, err := parseMulticast(v)
+	if err == nil && group == nil {
+		err = fmt.Errorf("%w: missing multicast group", ErrOptionNegotiation)
+	}
+	if err != nil {
+		t.abort(err)
+		return err
+	}
+	mconn, err := net.ListenMulticastUDP("udp", c.MulticastInterface, group)
+	if err != nil {
+		t.abort(err)
+		return err
+	}
+	defer mconn.Close()
+
+	events := make(chan multicastEvent)
+	done := make(chan struct{})
+	defer close(done)
+	t.conn.SetReadDeadline(time.Time{})
+	go readMulticastEvents(t.conn, t.peer, events, done)
+	go readMulticastEvents(capture(mconn, c.Capture), nil, events, done)
+
+	var (
+		next    block = 1 // next block to write
+		last    block     // final block, 0 until received
+		pending = make(map[block][]byte)
+		tries   int
+	)
+	timer := newClockTimer(t.clock, t.timeout)
+	defer timer.Stop()
+	if master {
+		t.send(newACKPacket(0))
+	}
+	for {
+		select {
+		case <-t.ctx.Done():
+			t.abort(t.ctx.Err())
+			return t.ctx.Err()
+		case <-timer.C:
+			if tries == t.retries {
+				return ErrTimeout
+			}
+			tries++
+			if master {
+				t.send(newACKPacket(next - 1))
+			}
+			timer.Reset(backoff(t.timeout, tries))
+			continue
+		case e := <-events:
+			if e.err != nil {
+				if err := t.ctx.Err(); err != nil {
+					t.abort(err)
+					return err
+				}
+				return e.err
+			}
+			switch p := e.p; p.opcode() {
+			case ERROR:
+				return peerError(p)
+			case OACK:
+				v, ok := p.multicast()
+				if !ok {
+					continue
+				}
+				if _, master, err = parseMulticast(v); err != nil {
+					t.abort(err)
+					return err
+				}
+			case DATA:
+				b := p.block()
+				if b < next || (last != 0 && b > last) {
+					break
+				}
+				if len(p.data()) < t.blksize {
+					last = b
+				}
+				pending[b] = p.data()
+				for d, ok := pending[next]; ok; d, ok = pending[next] {
+					if _, err := w.Write(d); err != nil {
+						t.abort(err)
+						return err
+					}
+					t.progress(len(d))
+					delete(pending, next)
+					next++
+				}
+			default:
+				continue
+			}
+		}
+		tries = 0
+		timer.Reset(t.timeout)
+		if last != 0 && next > last {
+			// done, also when not master so the server need not wait for us
+			return t.send(newACKPacket(last))
+		}
+		if master {
+			t.send(newACKPacket(next - 1))
+		}
+	}
+}
+
+// readMulticastEvents reads packets from conn until done, only from peer if
+// it is not nil
+func readMulticastEvents(conn net.PacketConn, peer net.Addr, events chan<- multicastEvent, done <-chan struct{}) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err == nil && peer != nil && addr.String() != peer.String() {
+			continue
+		}
+		e := multicastEvent{err: err}
+		if err == nil {
+			e.p = packet(append([]byte(nil), buf[:n]...))
+		}
+		select {
+		case events <- e:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerMulticast(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10) // 12 full blocks of 8 and 4 bytes
+	group := dialServer(t)                            // stands in for the multicast group
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		},
+		Multicast: group.LocalAddr().(*net.UDPAddr),
+	}
+	addr := startServer(t, s)
+	options := map[option]int{blksize: 8, multicast: 0}
+	g := group.LocalAddr().(*net.UDPAddr)
+	value := []byte(fmt.Sprintf("multicast\x00%s,%d,", g.IP, g.Port))
+	buf := make([]byte, 1024)
+
+	// the first client becomes master
+	a := dialServer(t)
+	a.WriteTo(newRRQPacket("test", Octet, options), addr)
+	n, session, err := a.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != OACK || !bytes.Contains(p, append(value, "1\x00"...)) {
+		t.Fatalf("got %q, want OACK for master client", p)
+	}
+
+	// the second client joins
+	b := dialServer(t)
+	b.WriteTo(newRRQPacket("test", Octet, options), addr)
+	if n, _, err = b.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != OACK || !bytes.Contains(p, append(value, "0\x00"...)) {
+		t.Fatalf("got %q, want OACK for client", p)
+	}
+
+	// the master client acknowledges the blocks sent to the group
+	var got []byte
+	for ack := block(0); ; ack++ {
+		a.WriteTo(newACKPacket(ack), session)
+		n, _, err := group.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := packet(buf[:n])
+		if p.opcode() != DATA || p.block() != ack+1 {
+			t.Fatalf("got %v block %d, want DATA block %d", p.opcode(), p.block(), ack+1)
+		}
+		got = append(got, p.data()...)
+		if len(p.data()) < 8 {
+			a.WriteTo(newACKPacket(p.block()), session)
+			break
+		}
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %q, want %q", got, content)
+	}
+
+	// the second client becomes master when the first is done
+	if n, _, err = b.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != OACK || !bytes.Contains(p, append(value, "1\x00"...)) {
+		t.Fatalf("got %q, want OACK for master client", p)
+	}
+	b.WriteTo(newACKPacket(12), session)
+	if n, _, err = group.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != DATA || p.block() != 13 {
+		t.Fatalf("got %v block %d, want DATA block 13", p.opcode(), p.block())
+	}
+	b.WriteTo(newACKPacket(13), session)
+}
+
+func TestAddIP(t *testing.T) {
+	for _, test := range []struct {
+		ip   string
+		n    int
+		want string
+	}{
+		{"239.1.1.1", 0, "239.1.1.1"},
+		{"239.1.1.1", 1, "239.1.1.2"},
+		{"239.1.1.255", 1, "239.1.2.0"},
+		{"ff05::1:3", 2, "ff05::1:5"},
+	} {
+		if got := addIP(net.ParseIP(test.ip), test.n); got.String() != test.want {
+			t.Errorf("%s + %d: got %s, want %s", test.ip, test.n, got, test.want)
+		}
+	}
+}
+
+func TestClientMulticast(t *testing.T) {
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 69, 1), Port: 21758}
+	if conn, err := net.ListenMulticastUDP("udp", nil, group); err != nil {
+		t.Skip("multicast unavailable:", err)
+	} else {
+		conn.Close()
+	}
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		},
+		Multicast: group,
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.Serve(conn)
+	addr := fmt.Sprintf("127.0.0.1:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+
+	c := &Client{Multicast: true, BlockSize: 1024, Timeout: time.Second}
+	results := make(chan error, 3)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			var got bytes.Buffer
+			err := c.Get(context.Background(), addr, "test", &got)
+			if err == nil && !bytes.Equal(got.Bytes(), content) {
+				err = fmt.Errorf("got %d bytes, want %d", got.Len(), len(content))
+			}
+			results <- err
+		}()
+	}
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestParseMulticast(t *testing.T) {
+	for _, test := range []struct {
+		v      string
+		group  string
+		master bool
+		ok     bool
+	}{
+		{"239.255.0.1,1758,1", "239.255.0.1:1758", true, true},
+		{"239.255.0.1,1758,0", "239.255.0.1:1758", false, true},
+		{",,1", "", true, true},
+		{"10.0.0.1,1758,1", "", false, false},
+		{"239.255.0.1,port,1", "", false, false},
+		{"239.255.0.1,1758,2", "", false, false},
+		{"239.255.0.1,1758", "", false, false},
+	} {
+		group, master, err := parseMulticast(test.v)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.v, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if g := fmt.Sprint(group); group != nil && g != test.group || group == nil && test.group != "" {
+			t.Errorf("%q: got group %v, want %q", test.v, group, test.group)
+		}
+		if master != test.master {
+			t.Errorf("%q: got master %v, want %v", test.v, master, test.master)
+		}
+	}
+}
+
+func TestAllocateGroupIPv6(t *testing.T) {
+	s := &Server{Multicast: &net.UDPAddr{IP: net.ParseIP("ff02::1:3"), Port: 1758, Zone: "eth0"}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, want := range []string{"[ff02::1:3%eth0]:1758", "[ff02::1:4%eth0]:1758"} {
+		if got := s.allocateGroup(); got.String() != want {
+			t.Errorf("got %v, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package tftp
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ServeMux is a Handler routing requests to the handler registered for the
+// pattern matching the filename. Leading slashes of filenames and patterns
+// are ignored. A pattern ending in a slash matches the files in that
+// directory tree, "/" matching all files, a pattern with the wildcards of
+// path.Match matches the filenames it matches, and any other pattern
+// matches that filename only. An exact match wins, otherwise the longest
+// matching pattern. Requests matching no pattern are refused with
+// FileNotFound. It is safe for concurrent use.
+type ServeMux struct {
+	mu       sync.RWMutex
+	patterns map[string]muxEntry // by pattern without leading slashes
+}
+
+// muxEntry is a handler registered with a ServeMux
+type muxEntry struct {
+	h       Handler
+	pattern string // pattern as registered
+}
+
+// NewServeMux returns a new ServeMux
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// Handle registers h for pattern, it panics if the pattern is invalid or
+// already registered
+func (m *ServeMux) Handle(pattern string, h Handler) {
+	if h == nil {
+		panic("tftp: nil handler")
+	}
+	p := strings.TrimLeft(pattern, "/")
+	if _, err := path.Match(p, ""); err != nil {
+		panic(fmt.Sprintf("tftp: invalid pattern %q: %v", pattern, err))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.patterns == nil {
+		m.patterns = make(map[string]muxEntry)
+	}
+	if _, ok := m.patterns[p]; ok {
+		panic(fmt.Sprintf("tftp: multiple registrations for %q", pattern))
+	}
+	m.patterns[p] = muxEntry{h, pattern}
+}
+
+// HandleFunc registers f for pattern
+func (m *ServeMux) HandleFunc(pattern string, f func(w ResponseWriter, r *Request) error) {
+	m.Handle(pattern, HandlerFunc(f))
+}
+
+// Handler returns the handler for r and its pattern as registered, nil
+// if no pattern matches
+func (m *ServeMux) Handler(r *Request) (h Handler, pattern string) {
+	name := strings.TrimLeft(r.Filename, "/")
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if e, ok := m.patterns[name]; ok {
+		return e.h, e.pattern
+	}
+	best := ""
+	for p, e := range m.patterns {
+		if !matchPattern(p, name) {
+			continue
+		}
+		if h == nil || len(p) > len(best) || len(p) == len(best) && p < best {
+			h, pattern, best = e.h, e.pattern, p
+		}
+	}
+	return h, pattern
+}
+
+// ServeTFTP calls the handler for r
+func (m *ServeMux) ServeTFTP(w ResponseWriter, r *Request) error {
+	h, _ := m.Handler(r)
+	if h == nil {
+		return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrNotExist}
+	}
+	return h.ServeTFTP(w, r)
+}
+
+// matchPattern reports whether the directory tree or wildcard pattern p
+// matches name
+func matchPattern(p, name string) bool {
+	if p == "" || strings.HasSuffix(p, "/") {
+		return strings.HasPrefix(name, p)
+	}
+	ok, _ := path.Match(p, name)
+	return ok
+}
+
+// StripPrefix returns a handler serving requests with prefix removed from
+// their filename by h, and refusing others with FileNotFound. Leading
+// slashes are ignored.
+func StripPrefix(prefix string, h Handler) Handler {
+	prefix = strings.TrimLeft(prefix, "/")
+	return HandlerFunc(func(w ResponseWriter, r *Request) error {
+		name, ok := strings.CutPrefix(strings.TrimLeft(r.Filename, "/"), prefix)
+		if !ok {
+			return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrNotExist}
+		}
+		r2 := *r
+		r2.Filename = name
+		return h.ServeTFTP(w, &r2)
+	})
+}
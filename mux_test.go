@@ -0,0 +1,76 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServeMux(t *testing.T) {
+	m := NewServeMux()
+	for _, pattern := range []string{"/", "pxelinux.0", "pxelinux.cfg/*", "pxelinux.cfg/default", "images/", "images/efi/", "/*.ipxe"} {
+		name := pattern
+		m.HandleFunc(pattern, func(w ResponseWriter, r *Request) error {
+			_, err := w.Write([]byte(name))
+			return err
+		})
+	}
+	for _, test := range []struct {
+		filename, pattern string
+	}{
+		{"pxelinux.0", "pxelinux.0"},
+		{"/pxelinux.0", "pxelinux.0"},
+		{"pxelinux.cfg/01-00-11-22-33-44-55", "pxelinux.cfg/*"},
+		{"pxelinux.cfg/default", "pxelinux.cfg/default"},
+		{"pxelinux.cfg/sub/file", "/"},
+		{"images/vmlinuz", "images/"},
+		{"images/efi/grubx64.efi", "images/efi/"},
+		{"boot.ipxe", "/*.ipxe"},
+		{"other", "/"},
+	} {
+		if _, pattern := m.Handler(&Request{Filename: test.filename}); pattern != test.pattern {
+			t.Errorf("%s: got pattern %q, want %q", test.filename, pattern, test.pattern)
+		}
+	}
+
+	addr := startServer(t, &Server{Handler: m}).String()
+	c := &Client{Timeout: time.Second}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr, "images/initrd", &got); err != nil || got.String() != "images/" {
+		t.Errorf("got %q, %v", got.String(), err)
+	}
+
+	m = NewServeMux()
+	m.Handle("images/", StripPrefix("/images/", HandlerFunc(func(w ResponseWriter, r *Request) error {
+		_, err := w.Write([]byte(r.Filename))
+		return err
+	})))
+	addr = startServer(t, &Server{Handler: m}).String()
+	got.Reset()
+	if err := c.Get(context.Background(), addr, "/images/efi/shim.efi", &got); err != nil || got.String() != "efi/shim.efi" {
+		t.Errorf("stripped: got %q, %v", got.String(), err)
+	}
+	if err := c.Get(context.Background(), addr, "pxelinux.0", &got); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("unmatched: got %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestServeMuxPanics(t *testing.T) {
+	h := HandlerFunc(func(ResponseWriter, *Request) error { return nil })
+	for _, f := range []func(m *ServeMux){
+		func(m *ServeMux) { m.Handle("[", h) },
+		func(m *ServeMux) { m.Handle("file", nil) },
+		func(m *ServeMux) { m.Handle("file", h); m.Handle("/file", h) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			f(NewServeMux())
+		}()
+	}
+}
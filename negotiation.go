@@ -0,0 +1,56 @@
+package tftp
+
+// Negotiation is the outcome of option negotiation for a request, the
+// values acknowledged in the OACK
+type Negotiation struct {
+	BlockSize    int  // RFC 2348 blksize, not acknowledged if zero
+	WindowSize   int  // RFC 7440 windowsize, not acknowledged if zero
+	Timeout      int  // RFC 2349 timeout in seconds, not acknowledged if zero
+	TransferSize bool // RFC 2349 tsize is acknowledged
+
+	// NoOACK sends no OACK, serving the request as RFC 1350 with default
+	// options
+	NoOACK bool
+}
+
+// NegotiationPolicy adjusts the negotiation for a request, whose options
+// are in r.Options. n holds the values the server acknowledges by
+// default, which may be lowered or dropped but not raised. Returning an
+// error refuses the request.
+type NegotiationPolicy func(r *Request, n *Negotiation) error
+
+// negotiation returns the Negotiation of an OACK
+func negotiation(oack map[option]int) *Negotiation {
+	_, tsize := oack[tsize]
+	return &Negotiation{
+		BlockSize:    oack[blksize],
+		WindowSize:   oack[windowsize],
+		Timeout:      oack[timeout],
+		TransferSize: tsize,
+	}
+}
+
+// apply restricts oack to the values of n
+func (n *Negotiation) apply(oack map[option]int) {
+	if n.NoOACK {
+		clear(oack)
+		return
+	}
+	if v := n.BlockSize; v < minBlockSize || v > oack[blksize] {
+		delete(oack, blksize)
+	} else {
+		oack[blksize] = v
+	}
+	if v := n.WindowSize; v < 1 || v > oack[windowsize] {
+		delete(oack, windowsize)
+	} else {
+		oack[windowsize] = v
+	}
+	// the timeout cannot be changed, only acknowledged or ignored
+	if n.Timeout != oack[timeout] {
+		delete(oack, timeout)
+	}
+	if !n.TransferSize {
+		delete(oack, tsize)
+	}
+}
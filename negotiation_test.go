@@ -0,0 +1,93 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiationPolicy(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = make([]byte, 3000)
+	files.m["legacy"] = make([]byte, 3000)
+	s := files.server()
+	s.NegotiationPolicy = func(r *Request, n *Negotiation) error {
+		if n.WindowSize > 4 {
+			return errors.New("windowsize too large")
+		}
+		if r.Filename == "legacy" {
+			n.NoOACK = true
+		}
+		n.BlockSize = min(n.BlockSize, 1000)
+		n.Timeout = 0
+		n.TransferSize = false
+		return nil
+	}
+	addr := startServer(t, s)
+
+	conn := dialServer(t)
+	buf := make([]byte, 2048)
+	for _, test := range []struct {
+		filename string
+		options  map[option]int
+		want     map[option]int
+	}{
+		{"file", map[option]int{blksize: 1468, timeout: 1, tsize: 0, windowsize: 2}, map[option]int{blksize: 1000, windowsize: 2}},
+		// the server may lower values, not raise them
+		{"file", map[option]int{blksize: 700}, map[option]int{blksize: 700}},
+		{"legacy", map[option]int{blksize: 1468}, nil},
+	} {
+		conn.WriteTo(newRRQPacket(test.filename, Octet, test.options), addr)
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := packet(buf[:n])
+		if test.want == nil {
+			if p.opcode() != DATA || len(p.data()) != defaultBlockSize {
+				t.Errorf("%v: got %v of %d bytes, want DATA of %d bytes", test.options, p.opcode(), len(p.data()), defaultBlockSize)
+			}
+		} else if got := p.options(); p.opcode() != OACK || len(got) != len(test.want) || got[blksize] != test.want[blksize] || got[windowsize] != test.want[windowsize] {
+			t.Errorf("%v: got %v %v, want OACK %v", test.options, p.opcode(), got, test.want)
+		}
+		conn.WriteTo(newERRORPacket(0, "done"), peer)
+	}
+
+	c := &Client{Timeout: time.Second, WindowSize: 8}
+	err := c.Get(context.Background(), addr.String(), "file", nil)
+	if err == nil || !strings.Contains(err.Error(), "windowsize too large") {
+		t.Errorf("got %v, want windowsize too large", err)
+	}
+}
+
+func TestAcknowledgeOption(t *testing.T) {
+	requests := make(chan *Request, 1)
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+			requests <- r
+			_, err := w.Write([]byte("data"))
+			return err
+		}),
+		AcknowledgeOption: func(r *Request, name, value string) (string, bool) {
+			return strings.ToUpper(value), name == "x-vendor"
+		},
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	rrq, _ := (&ReadRequest{Filename: "file", Mode: Octet, Options: map[string]string{"x-vendor": "on", "x-other": "1"}}).MarshalBinary()
+	conn.WriteTo(rrq, addr)
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oack OptionAck
+	if err := oack.UnmarshalBinary(buf[:n]); err != nil || len(oack.Options) != 1 || oack.Options["x-vendor"] != "ON" {
+		t.Errorf("got %q, %v, want OACK with x-vendor ON", buf[:n], err)
+	}
+	if r := <-requests; r.Options["x-vendor"] != "on" || r.Options["x-other"] != "1" {
+		t.Errorf("handler got options %v", r.Options)
+	}
+}
@@ -0,0 +1,226 @@
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+)
+
+// Newline is the line ending of local text decoded from netascii
+type Newline int
+
+const (
+	NewlineLF   Newline = iota // LF, as on Unix
+	NewlineCRLF                // CR LF, as on Windows
+)
+
+// NativeNewline is the line ending of text files on this system
+var NativeNewline = nativeNewline()
+
+// nativeNewline returns CR LF on Windows and LF elsewhere
+func nativeNewline() Newline {
+	if runtime.GOOS == "windows" {
+		return NewlineCRLF
+	}
+	return NewlineLF
+}
+
+// netasciiEncoder encodes local text to netascii: LF and CR LF become
+// CR LF and other CRs become CR NUL. A CR ending the text encoded so far
+// is held until the next byte shows whether it ends a line.
+type netasciiEncoder struct {
+	cr bool
+}
+
+// append appends p encoded to dst
+func (e *netasciiEncoder) append(dst, p []byte) []byte {
+	for len(p) > 0 {
+		if e.cr {
+			e.cr = false
+			if p[0] == '\n' {
+				dst = append(dst, '\r', '\n')
+				p = p[1:]
+				continue
+			}
+			dst = append(dst, '\r', 0)
+		}
+		// copy the run up to the next CR or LF at once
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			i = len(p)
+		}
+		if j := bytes.IndexByte(p[:i], '\r'); j >= 0 {
+			i = j
+		}
+		dst = append(dst, p[:i]...)
+		if i == len(p) {
+			break
+		}
+		if p[i] == '\n' {
+			dst = append(dst, '\r', '\n')
+		} else {
+			e.cr = true
+		}
+		p = p[i+1:]
+	}
+	return dst
+}
+
+// flush appends a held CR to dst at the end of the text
+func (e *netasciiEncoder) flush(dst []byte) []byte {
+	if e.cr {
+		e.cr = false
+		dst = append(dst, '\r', 0)
+	}
+	return dst
+}
+
+// appendNetascii appends the local text p of a whole file encoded to
+// netascii to dst
+func appendNetascii(dst, p []byte) []byte {
+	var e netasciiEncoder
+	return e.flush(e.append(dst, p))
+}
+
+// NetasciiReader converts local text read from an underlying reader to
+// netascii: LF and CR LF become CR LF and other CRs become CR NUL
+type NetasciiReader struct {
+	r       io.Reader
+	enc     netasciiEncoder
+	in      []byte // text read from r
+	encoded []byte // netascii of in
+	out     []byte // rest of encoded not yet read
+	err     error
+}
+
+// NewNetasciiReader returns a reader encoding r to netascii
+func NewNetasciiReader(r io.Reader) *NetasciiReader {
+	return &NetasciiReader{r: r}
+}
+
+// Read reads encoded data
+func (e *NetasciiReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(e.out) == 0 && e.err == nil {
+		if e.in == nil {
+			e.in = make([]byte, 4096)
+		}
+		var m int
+		m, e.err = e.r.Read(e.in)
+		e.encoded = e.enc.append(e.encoded[:0], e.in[:m])
+		if e.err == io.EOF {
+			e.encoded = e.enc.flush(e.encoded)
+		}
+		e.out = e.encoded
+	}
+	if len(e.out) == 0 {
+		return 0, e.err
+	}
+	n = copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// NetasciiWriter converts netascii written to it to local text written to
+// an underlying writer: CR LF becomes the Newline and CR NUL becomes CR.
+// Flush must be called after the last Write.
+type NetasciiWriter struct {
+	Newline Newline // line ending written for CR LF, LF if zero
+
+	w   io.Writer
+	buf []byte
+	cr  bool
+}
+
+// NewNetasciiWriter returns a writer decoding netascii to w
+func NewNetasciiWriter(w io.Writer) *NetasciiWriter {
+	return &NetasciiWriter{w: w}
+}
+
+// Write decodes p
+func (d *NetasciiWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	out := d.buf[:0]
+	for len(p) > 0 {
+		if d.cr {
+			d.cr = false
+			switch p[0] {
+			case '\n':
+				if d.Newline == NewlineCRLF {
+					out = append(out, '\r')
+				}
+				out = append(out, '\n')
+				p = p[1:]
+				continue
+			case 0:
+				out = append(out, '\r')
+				p = p[1:]
+				continue
+			}
+			// a CR followed by other bytes is invalid, it is kept
+			out = append(out, '\r')
+		}
+		i := bytes.IndexByte(p, '\r')
+		if i < 0 {
+			out = append(out, p...)
+			break
+		}
+		out = append(out, p[:i]...)
+		d.cr = true
+		p = p[i+1:]
+	}
+	d.buf = out
+	if len(out) > 0 {
+		if _, err = d.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush writes a trailing CR left pending by the last Write
+func (d *NetasciiWriter) Flush() error {
+	if !d.cr {
+		return nil
+	}
+	d.cr = false
+	_, err := d.w.Write([]byte{'\r'})
+	return err
+}
+
+// netasciiDecoder converts netascii read from an underlying reader to local
+// text
+type netasciiDecoder struct {
+	r   io.Reader
+	w   *NetasciiWriter
+	out bytes.Buffer
+	buf []byte
+	err error
+}
+
+// newNetasciiDecoder returns a reader decoding r from netascii, with
+// newline as line ending
+func newNetasciiDecoder(r io.Reader, newline Newline) *netasciiDecoder {
+	d := &netasciiDecoder{r: r, buf: make([]byte, defaultBlockSize)}
+	d.w = NewNetasciiWriter(&d.out)
+	d.w.Newline = newline
+	return d
+}
+
+// Read reads decoded data
+func (d *netasciiDecoder) Read(p []byte) (n int, err error) {
+	for d.out.Len() == 0 && d.err == nil {
+		var m int
+		m, d.err = d.r.Read(d.buf)
+		d.w.Write(d.buf[:m])
+		if d.err == io.EOF {
+			d.w.Flush()
+		}
+	}
+	if d.out.Len() > 0 {
+		return d.out.Read(p)
+	}
+	return 0, d.err
+}
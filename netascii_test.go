@@ -0,0 +1,121 @@
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// netasciiTests are local text with LF line endings and its netascii
+var netasciiTests = []struct {
+	local, netascii string
+}{
+	{"", ""},
+	{"text", "text"},
+	{"line\n", "line\r\n"},
+	{"a\nb\n\n", "a\r\nb\r\n\r\n"},
+	{"carriage\rreturn", "carriage\r\x00return"},
+	{"\r", "\r\x00"},
+	{"\r\x00", "\r\x00\x00"},
+}
+
+// encodeTests are local text with other line endings and its netascii
+var encodeTests = []struct {
+	local, netascii string
+}{
+	{"\r\n", "\r\n"},
+	{"\r\r\n", "\r\x00\r\n"},
+	{"a\r\nb\nc\r", "a\r\nb\r\nc\r\x00"},
+	{"\r\r\r\n\n", "\r\x00\r\x00\r\n\r\n"},
+}
+
+func TestNetasciiReader(t *testing.T) {
+	for _, test := range append(netasciiTests, encodeTests...) {
+		got, err := io.ReadAll(NewNetasciiReader(strings.NewReader(test.local)))
+		if err != nil || string(got) != test.netascii {
+			t.Errorf("%q: got %q, %v, want %q", test.local, got, err, test.netascii)
+		}
+		// one byte at a time exercises the carry between reads
+		got, err = io.ReadAll(iotest.OneByteReader(NewNetasciiReader(iotest.OneByteReader(strings.NewReader(test.local)))))
+		if err != nil || string(got) != test.netascii {
+			t.Errorf("%q: one byte reads: got %q, %v, want %q", test.local, got, err, test.netascii)
+		}
+		if got := appendNetascii(nil, []byte(test.local)); string(got) != test.netascii {
+			t.Errorf("%q: appendNetascii got %q, want %q", test.local, got, test.netascii)
+		}
+	}
+}
+
+func TestNetasciiWriter(t *testing.T) {
+	for _, test := range netasciiTests {
+		for _, newline := range []Newline{NewlineLF, NewlineCRLF} {
+			want := test.local
+			if newline == NewlineCRLF {
+				want = strings.ReplaceAll(test.netascii, "\r\x00", "\r")
+			}
+			var got bytes.Buffer
+			w := NewNetasciiWriter(&got)
+			w.Newline = newline
+			// one byte at a time exercises a CR pending between writes
+			for i := 0; i < len(test.netascii); i++ {
+				w.Write([]byte{test.netascii[i]})
+			}
+			if err := w.Flush(); err != nil || got.String() != want {
+				t.Errorf("%q %v: got %q, %v, want %q", test.netascii, newline, got.String(), err, want)
+			}
+		}
+	}
+	// invalid CRs are kept
+	var got bytes.Buffer
+	w := NewNetasciiWriter(&got)
+	w.Write([]byte("a\rb\r"))
+	w.Flush()
+	if got.String() != "a\rb\r" {
+		t.Errorf("got %q, want %q", got.String(), "a\rb\r")
+	}
+}
+
+func TestNetasciiDecoder(t *testing.T) {
+	for _, test := range netasciiTests {
+		got, err := io.ReadAll(newNetasciiDecoder(iotest.OneByteReader(strings.NewReader(test.netascii)), NewlineLF))
+		if err != nil || string(got) != test.local {
+			t.Errorf("%q: got %q, %v, want %q", test.netascii, got, err, test.local)
+		}
+	}
+	got, err := io.ReadAll(newNetasciiDecoder(strings.NewReader("a\r\nb\r\x00\r\n"), NewlineCRLF))
+	if want := "a\r\nb\r\r\n"; err != nil || string(got) != want {
+		t.Errorf("CR LF: got %q, %v, want %q", got, err, want)
+	}
+}
+
+// netasciiText is local text of 64 KiB with lines of about 60 bytes
+var netasciiText = bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog, 0123456789.\n"), 1150)[:65536]
+
+func BenchmarkNetasciiReader(b *testing.B) {
+	b.SetBytes(int64(len(netasciiText)))
+	for i := 0; i < b.N; i++ {
+		io.Copy(io.Discard, NewNetasciiReader(bytes.NewReader(netasciiText)))
+	}
+}
+
+func BenchmarkAppendNetascii(b *testing.B) {
+	b.SetBytes(int64(len(netasciiText)))
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf = appendNetascii(buf[:0], netasciiText)
+	}
+}
+
+func BenchmarkNetasciiWriter(b *testing.B) {
+	encoded := appendNetascii(nil, netasciiText)
+	b.SetBytes(int64(len(encoded)))
+	w := NewNetasciiWriter(io.Discard)
+	for i := 0; i < b.N; i++ {
+		for p := encoded; len(p) > 0; p = p[min(len(p), 512):] {
+			w.Write(p[:min(len(p), 512)])
+		}
+		w.Flush()
+	}
+}
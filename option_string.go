@@ -4,9 +4,9 @@ package tftp
 
 import "fmt"
 
-const _option_name = "blksizetimeouttsizemulticastwindowsizemaxOption"
+const _option_name = "blksizetimeouttsizemulticastwindowsizerolloveroffsetmaxOption"
 
-var _option_index = [...]uint8{0, 7, 14, 19, 28, 38, 47}
+var _option_index = [...]uint8{0, 7, 14, 19, 28, 38, 46, 52, 61}
 
 func (i option) String() string {
 	i -= 1
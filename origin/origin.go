@@ -0,0 +1,112 @@
+// Package origin implements a read-only tftp.Backend streaming files from
+// an HTTP(S) origin server, such as a web server or CDN holding boot images
+package origin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+var _ tftp.Backend = (*Backend)(nil)
+
+// Backend reads files from the URL of their name resolved against Base.
+// The Content-Length of a file is announced to clients requesting the
+// tsize option. Files cannot be written or removed.
+type Backend struct {
+	Base   string       // base URL of the files, for example https://boot.example.com/tftp/
+	Header http.Header  // headers added to each request, for example for authorization
+	Client *http.Client // client for requests, http.DefaultClient if nil
+}
+
+// Open requests a file, the body of the response is streamed as it is read
+func (b *Backend) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, name)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Create fails, the origin is read-only
+func (b *Backend) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+}
+
+// Stat returns the size and modification time of a file from the
+// Content-Length and Last-Modified headers, the size is -1 if unknown
+func (b *Backend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	resp, err := b.do(ctx, http.MethodHead, name)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	fi := &fileInfo{name: path.Base(name), size: resp.ContentLength}
+	fi.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return fi, nil
+}
+
+// Remove fails, the origin is read-only
+func (b *Backend) Remove(ctx context.Context, name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+// do sends a request for the URL of a file, returning an error for
+// responses other than 2xx
+func (b *Backend) do(ctx context.Context, method, name string) (*http.Response, error) {
+	base, err := url.Parse(b.Base)
+	if err != nil {
+		return nil, err
+	}
+	// the name is relative to the base even if the base lacks a
+	// trailing slash
+	u := base.JoinPath(name)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range b.Header {
+		req.Header[k] = v
+	}
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	resp.Body.Close()
+	err = fmt.Errorf("origin: %s %s: %s", method, u.Redacted(), resp.Status)
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return nil, err
+}
+
+// fileInfo describes a file
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return false }
+func (fi *fileInfo) Sys() any           { return nil }
@@ -0,0 +1,96 @@
+package origin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// files is a fake origin serving files under /tftp/ to requests carrying
+// the token
+type files map[string]string
+
+func (f files) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	data, ok := f[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.URL.Path == "/tftp/stream" {
+		// chunked, without a Content-Length
+		w.(http.Flusher).Flush()
+	}
+	w.Header().Set("Last-Modified", "Fri, 24 May 2013 00:00:00 GMT")
+	io.WriteString(w, data)
+}
+
+func TestBackend(t *testing.T) {
+	srv := httptest.NewServer(files{"/tftp/images/vmlinuz": "kernel", "/tftp/a b": "space", "/tftp/stream": "data"})
+	defer srv.Close()
+	b := &Backend{Base: srv.URL + "/tftp", Header: http.Header{"Authorization": {"Bearer token"}}}
+	ctx := context.Background()
+
+	fi, err := b.Stat(ctx, "images/vmlinuz")
+	if err != nil || fi.Size() != 6 || fi.Name() != "vmlinuz" || fi.ModTime().Year() != 2013 {
+		t.Errorf("got %v, %v", fi, err)
+	}
+	if fi, err := b.Stat(ctx, "stream"); err != nil || fi.Size() != -1 {
+		t.Errorf("chunked: got %v, %v, want unknown size", fi, err)
+	}
+	for name, want := range map[string]string{"images/vmlinuz": "kernel", "a b": "space"} {
+		rc, err := b.Open(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != want {
+			t.Errorf("%s: read %q, want %q", name, data, want)
+		}
+	}
+	if _, err := b.Open(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want not exist", err)
+	}
+	if _, err := b.Create(ctx, "upload"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("create: got %v, want permission denied", err)
+	}
+	b.Header = nil
+	if _, err := b.Open(ctx, "images/vmlinuz"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("got %v, want permission denied", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	srv := httptest.NewServer(files{"/tftp/image": string(bytes.Repeat([]byte("x"), 2000))})
+	defer srv.Close()
+	b := &Backend{Base: srv.URL + "/tftp/", Header: http.Header{"Authorization": {"Bearer token"}}}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go (&tftp.Server{Backend: b}).Serve(conn)
+
+	// the Content-Length is the transfer size
+	var size int64
+	c := &tftp.Client{OnProgress: func(_, n int64) { size = n }}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), conn.LocalAddr().String(), "image", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != 2000 || size != 2000 {
+		t.Errorf("got %d bytes of size %d, want 2000", got.Len(), size)
+	}
+}
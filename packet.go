@@ -0,0 +1,371 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// ErrMalformedPacket is returned when unmarshaling a malformed packet
+var ErrMalformedPacket = errors.New("tftp: malformed packet")
+
+// Packet is a TFTP packet, a *ReadRequest, *WriteRequest, *Data, *Ack,
+// *Error or *OptionAck
+type Packet interface {
+	Opcode() Opcode
+	MarshalBinary() ([]byte, error)
+}
+
+// DecodePacket decodes a TFTP packet of any type
+func DecodePacket(b []byte) (Packet, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("%w: missing opcode", ErrMalformedPacket)
+	}
+	var p interface {
+		Packet
+		UnmarshalBinary([]byte) error
+	}
+	switch op := Opcode(binary.BigEndian.Uint16(b)); op {
+	case RRQ:
+		p = new(ReadRequest)
+	case WRQ:
+		p = new(WriteRequest)
+	case DATA:
+		p = new(Data)
+	case ACK:
+		p = new(Ack)
+	case ERROR:
+		p = new(Error)
+	case OACK:
+		p = new(OptionAck)
+	default:
+		return nil, fmt.Errorf("%w: unknown opcode %d", ErrMalformedPacket, op)
+	}
+	if err := p.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ReadRequest is a RRQ packet
+type ReadRequest struct {
+	Filename string
+	Mode     Mode
+	Options  map[string]string // options by lower case name
+}
+
+// Opcode returns RRQ
+func (*ReadRequest) Opcode() Opcode { return RRQ }
+
+// MarshalBinary encodes r
+func (r *ReadRequest) MarshalBinary() ([]byte, error) {
+	return marshalRequest(RRQ, r.Filename, r.Mode, r.Options)
+}
+
+// UnmarshalBinary decodes a RRQ packet into r
+func (r *ReadRequest) UnmarshalBinary(p []byte) (err error) {
+	r.Filename, r.Mode, r.Options, err = unmarshalRequest(p, RRQ)
+	return
+}
+
+// WriteRequest is a WRQ packet
+type WriteRequest struct {
+	Filename string
+	Mode     Mode
+	Options  map[string]string // options by lower case name
+}
+
+// Opcode returns WRQ
+func (*WriteRequest) Opcode() Opcode { return WRQ }
+
+// MarshalBinary encodes r
+func (r *WriteRequest) MarshalBinary() ([]byte, error) {
+	return marshalRequest(WRQ, r.Filename, r.Mode, r.Options)
+}
+
+// UnmarshalBinary decodes a WRQ packet into r
+func (r *WriteRequest) UnmarshalBinary(p []byte) (err error) {
+	r.Filename, r.Mode, r.Options, err = unmarshalRequest(p, WRQ)
+	return
+}
+
+// Data is a DATA packet
+type Data struct {
+	Block uint16
+	Data  []byte
+}
+
+// Opcode returns DATA
+func (*Data) Opcode() Opcode { return DATA }
+
+// MarshalBinary encodes d
+func (d *Data) MarshalBinary() ([]byte, error) {
+	return d.AppendBinary(make([]byte, 0, 4+len(d.Data)))
+}
+
+// AppendBinary appends the encoding of d to b
+func (d *Data) AppendBinary(b []byte) ([]byte, error) {
+	return append(appendHeader(b, DATA, d.Block), d.Data...), nil
+}
+
+// UnmarshalBinary decodes a DATA packet into d, copying the data
+func (d *Data) UnmarshalBinary(p []byte) error {
+	rest, err := unmarshalHeader(p, DATA)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 2 {
+		return fmt.Errorf("%w: missing block number", ErrMalformedPacket)
+	}
+	d.Block = binary.BigEndian.Uint16(rest)
+	d.Data = append([]byte(nil), rest[2:]...)
+	return nil
+}
+
+// Ack is an ACK packet
+type Ack struct {
+	Block uint16
+}
+
+// Opcode returns ACK
+func (*Ack) Opcode() Opcode { return ACK }
+
+// MarshalBinary encodes a
+func (a *Ack) MarshalBinary() ([]byte, error) {
+	return a.AppendBinary(make([]byte, 0, 4))
+}
+
+// AppendBinary appends the encoding of a to b
+func (a *Ack) AppendBinary(b []byte) ([]byte, error) {
+	return appendHeader(b, ACK, a.Block), nil
+}
+
+// UnmarshalBinary decodes an ACK packet into a
+func (a *Ack) UnmarshalBinary(p []byte) error {
+	rest, err := unmarshalHeader(p, ACK)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 2 {
+		return fmt.Errorf("%w: ACK of %d bytes", ErrMalformedPacket, len(p))
+	}
+	a.Block = binary.BigEndian.Uint16(rest)
+	return nil
+}
+
+// Error is an ERROR packet. As an error it is returned for ERROR packets
+// received from peers, and handlers return it to choose the error code sent
+// to clients.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+// Error returns the message and the error code
+func (e *Error) Error() string {
+	return fmt.Sprintf("tftp: %s (%v)", e.Message, e.Code)
+}
+
+// Is reports whether target is an *Error with the same code, or the
+// fs error matching the code
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return t.Code == e.Code
+	}
+	switch e.Code {
+	case FileNotFound:
+		return target == fs.ErrNotExist
+	case AccessViolation:
+		return target == fs.ErrPermission
+	case FileAlreadyExists:
+		return target == fs.ErrExist
+	}
+	return false
+}
+
+// Errors with the error codes of the corresponding ERROR packets, received
+// errors match them with errors.Is whatever their message
+var (
+	ErrFileNotFound    = &Error{Code: FileNotFound, Message: "file not found"}
+	ErrAccessViolation = &Error{Code: AccessViolation, Message: "access violation"}
+	ErrFileExists      = &Error{Code: FileAlreadyExists, Message: "file already exists"}
+)
+
+// Opcode returns ERROR
+func (*Error) Opcode() Opcode { return ERROR }
+
+// MarshalBinary encodes e
+func (e *Error) MarshalBinary() ([]byte, error) {
+	if strings.IndexByte(e.Message, 0) >= 0 {
+		return nil, errors.New("tftp: NUL in error message")
+	}
+	return append(append(marshalHeader(ERROR, uint16(e.Code), len(e.Message)+1), e.Message...), 0), nil
+}
+
+// UnmarshalBinary decodes an ERROR packet into e
+func (e *Error) UnmarshalBinary(p []byte) error {
+	rest, err := unmarshalHeader(p, ERROR)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 2 {
+		return fmt.Errorf("%w: missing error code", ErrMalformedPacket)
+	}
+	fields, err := splitFields(rest[2:])
+	if err != nil {
+		return err
+	}
+	if len(fields) != 1 {
+		return fmt.Errorf("%w: %d fields in ERROR", ErrMalformedPacket, len(fields))
+	}
+	e.Code = ErrorCode(binary.BigEndian.Uint16(rest))
+	e.Message = fields[0]
+	return nil
+}
+
+// OptionAck is an OACK packet
+type OptionAck struct {
+	Options map[string]string // options by lower case name
+}
+
+// Opcode returns OACK
+func (*OptionAck) Opcode() Opcode { return OACK }
+
+// MarshalBinary encodes o
+func (o *OptionAck) MarshalBinary() ([]byte, error) {
+	return appendOptions(binary.BigEndian.AppendUint16(nil, uint16(OACK)), o.Options)
+}
+
+// UnmarshalBinary decodes an OACK packet into o
+func (o *OptionAck) UnmarshalBinary(p []byte) error {
+	rest, err := unmarshalHeader(p, OACK)
+	if err != nil {
+		return err
+	}
+	fields, err := splitFields(rest)
+	if err != nil {
+		return err
+	}
+	o.Options, err = optionFields(fields)
+	return err
+}
+
+// marshalHeader returns the opcode and the block number or error code of a
+// packet, with capacity for size more bytes
+func marshalHeader(op Opcode, n uint16, size int) []byte {
+	return appendHeader(make([]byte, 0, 4+size), op, n)
+}
+
+// appendHeader appends the opcode and the block number or error code of a
+// packet to b
+func appendHeader(b []byte, op Opcode, n uint16) []byte {
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(b, uint16(op)), n)
+}
+
+// unmarshalHeader checks the opcode of p and returns the rest of p
+func unmarshalHeader(p []byte, op Opcode) ([]byte, error) {
+	if len(p) < 2 {
+		return nil, fmt.Errorf("%w: missing opcode", ErrMalformedPacket)
+	}
+	if got := Opcode(binary.BigEndian.Uint16(p)); got != op {
+		return nil, fmt.Errorf("%w: got %v, want %v", ErrMalformedPacket, got, op)
+	}
+	return p[2:], nil
+}
+
+// marshalRequest encodes a RRQ or WRQ
+func marshalRequest(op Opcode, filename string, mode Mode, options map[string]string) ([]byte, error) {
+	if filename == "" || strings.IndexByte(filename, 0) >= 0 {
+		return nil, fmt.Errorf("tftp: invalid filename %q", filename)
+	}
+	if mode == 0 || mode >= maxMode {
+		return nil, fmt.Errorf("tftp: invalid mode %v", mode)
+	}
+	b := binary.BigEndian.AppendUint16(nil, uint16(op))
+	b = append(append(b, filename...), 0)
+	b = append(append(b, mode.wireName()...), 0)
+	return appendOptions(b, options)
+}
+
+// unmarshalRequest decodes a RRQ or WRQ
+func unmarshalRequest(p []byte, op Opcode) (filename string, mode Mode, options map[string]string, err error) {
+	rest, err := unmarshalHeader(p, op)
+	if err != nil {
+		return
+	}
+	fields, err := splitFields(rest)
+	if err != nil {
+		return
+	}
+	if len(fields) < 2 {
+		err = fmt.Errorf("%w: missing filename or mode", ErrMalformedPacket)
+		return
+	}
+	if fields[0] == "" {
+		err = fmt.Errorf("%w: empty filename", ErrMalformedPacket)
+		return
+	}
+	if mode, err = parseMode(fields[1]); err != nil {
+		return
+	}
+	options, err = optionFields(fields[2:])
+	return fields[0], mode, options, err
+}
+
+// parseMode returns the mode named s in any case
+func parseMode(s string) (Mode, error) {
+	for m := Octet; m < maxMode; m++ {
+		if strings.EqualFold(s, m.wireName()) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unsupported mode %q", ErrMalformedPacket, s)
+}
+
+// appendOptions appends the options to b in order of name
+func appendOptions(b []byte, options map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		value := options[name]
+		if name == "" || strings.IndexByte(name, 0) >= 0 || strings.IndexByte(value, 0) >= 0 {
+			return nil, fmt.Errorf("tftp: invalid option %q", name)
+		}
+		b = append(append(b, name...), 0)
+		b = append(append(b, value...), 0)
+	}
+	return b, nil
+}
+
+// splitFields splits p into NUL terminated strings
+func splitFields(p []byte) (fields []string, err error) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, 0)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: missing NUL terminator", ErrMalformedPacket)
+		}
+		fields = append(fields, string(p[:i]))
+		p = p[i+1:]
+	}
+	return
+}
+
+// optionFields returns the options of name and value fields by lower case
+// name
+func optionFields(fields []string) (map[string]string, error) {
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("%w: option %q without value", ErrMalformedPacket, fields[len(fields)-1])
+	}
+	options := make(map[string]string, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		options[strings.ToLower(fields[i])] = fields[i+1]
+	}
+	return options, nil
+}
@@ -0,0 +1,96 @@
+package tftp
+
+import (
+	"encoding"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// binaryPacket is a packet type
+type binaryPacket interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+func TestPacketMarshal(t *testing.T) {
+	for _, test := range []struct {
+		p    binaryPacket
+		wire string
+	}{
+		{&ReadRequest{Filename: "test", Mode: Octet, Options: map[string]string{"tsize": "0", "blksize": "1024"}}, "\x00\x01test\x00octet\x00blksize\x001024\x00tsize\x000\x00"},
+		{&WriteRequest{Filename: "test", Mode: Netascii, Options: map[string]string{}}, "\x00\x02test\x00netascii\x00"},
+		{&Data{Block: 0xbbaa, Data: []byte("data")}, "\x00\x03\xbb\xaadata"},
+		{&Ack{Block: 0xbbaa}, "\x00\x04\xbb\xaa"},
+		{&Error{Code: DiskFull, Message: "error message"}, "\x00\x05\x00\x03error message\x00"},
+		{&OptionAck{Options: map[string]string{"multicast": "", "windowsize": "16"}}, "\x00\x06multicast\x00\x00windowsize\x0016\x00"},
+	} {
+		b, err := test.p.MarshalBinary()
+		if err != nil || string(b) != test.wire {
+			t.Errorf("%T: got %q, %v, want %q", test.p, b, err, test.wire)
+			continue
+		}
+		got := reflect.New(reflect.TypeOf(test.p).Elem()).Interface().(binaryPacket)
+		if err := got.UnmarshalBinary(b); err != nil || !reflect.DeepEqual(got, test.p) {
+			t.Errorf("%T: got %+v, %v, want %+v", test.p, got, err, test.p)
+		}
+	}
+}
+
+func TestPacketUnmarshalMalformed(t *testing.T) {
+	for _, test := range []struct {
+		p    binaryPacket
+		wire string
+	}{
+		{new(ReadRequest), ""},
+		{new(ReadRequest), "\x00\x02test\x00octet\x00"},
+		{new(ReadRequest), "\x00\x01test\x00octet"},
+		{new(ReadRequest), "\x00\x01test\x00"},
+		{new(ReadRequest), "\x00\x01\x00octet\x00"},
+		{new(ReadRequest), "\x00\x01test\x00octet\x00blksize\x00"},
+		{new(Data), "\x00\x03\x00"},
+		{new(Ack), "\x00\x04\x00\x01\x00"},
+		{new(Error), "\x00\x05\x00\x01message"},
+		{new(OptionAck), "\x00\x06blksize\x00"},
+	} {
+		if err := test.p.UnmarshalBinary([]byte(test.wire)); !errors.Is(err, ErrMalformedPacket) {
+			t.Errorf("%T %q: got %v, want ErrMalformedPacket", test.p, test.wire, err)
+		}
+	}
+	var r ReadRequest
+	if err := r.UnmarshalBinary([]byte("\x00\x01test\x00binary\x00")); err == nil {
+		t.Error("unknown mode: got no error")
+	}
+	for _, p := range []binaryPacket{
+		&ReadRequest{Filename: "", Mode: Octet},
+		&ReadRequest{Filename: "a\x00b", Mode: Octet},
+		&WriteRequest{Filename: "test"},
+		&Error{Message: "a\x00b"},
+		&OptionAck{Options: map[string]string{"": "1"}},
+	} {
+		if _, err := p.MarshalBinary(); err == nil {
+			t.Errorf("%+v: got no error", p)
+		}
+	}
+}
+
+func TestDecodePacket(t *testing.T) {
+	for _, s := range validPacketStrings {
+		p, err := DecodePacket([]byte(s))
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if p.Opcode() != packet(s).opcode() {
+			t.Errorf("%q: got %v, want %v", s, p.Opcode(), packet(s).opcode())
+		}
+	}
+	if ack, err := DecodePacket([]byte("\x00\x04\x00\x07")); err != nil || *ack.(*Ack) != (Ack{Block: 7}) {
+		t.Errorf("got %v, %v, want ACK 7", ack, err)
+	}
+	for _, s := range []string{"", "\x00", "\x00\x07", "\x00\x01test"} {
+		if _, err := DecodePacket([]byte(s)); !errors.Is(err, ErrMalformedPacket) {
+			t.Errorf("%q: got %v, want ErrMalformedPacket", s, err)
+		}
+	}
+}
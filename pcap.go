@@ -0,0 +1,169 @@
+package tftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// pcap file format constants
+const (
+	pcapMagic   = 0xa1b2c3d4 // microsecond timestamps
+	pcapSnapLen = 65535
+	linkTypeRaw = 101 // raw IPv4 and IPv6 packets
+)
+
+// PcapWriter writes the packets of TFTP transfers to a pcap file, with IP
+// and UDP headers, for analysis with tools such as Wireshark. It is safe
+// for concurrent use.
+type PcapWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error // first write error
+}
+
+// NewPcapWriter returns a PcapWriter writing to w, after writing the pcap
+// file header
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	h := make([]byte, 24)
+	binary.LittleEndian.PutUint32(h[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(h[20:], linkTypeRaw)
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WritePacket writes the UDP datagram payload sent from src to dst at
+// time t. Addresses of different families are written as IPv6.
+func (p *PcapWriter) WritePacket(t time.Time, src, dst netip.AddrPort, payload []byte) error {
+	ip := ipPacket(src, dst, payload)
+	rec := make([]byte, 16, 16+len(ip))
+	binary.LittleEndian.PutUint32(rec[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(min(len(ip), pcapSnapLen)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(ip)))
+	rec = append(rec, ip[:min(len(ip), pcapSnapLen)]...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		_, p.err = p.w.Write(rec)
+	}
+	return p.err
+}
+
+// ipPacket returns the IPv4 or IPv6 packet of a UDP datagram
+func ipPacket(src, dst netip.AddrPort, payload []byte) []byte {
+	s, d := src.Addr().Unmap(), dst.Addr().Unmap()
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	var ip, pseudo []byte
+	if s.Is4() && d.Is4() {
+		ip = make([]byte, 20, 20+len(udp))
+		ip[0] = 0x45 // version 4, 5 word header
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = 64                                 // TTL
+		ip[9] = 17                                 // UDP
+		s4, d4 := s.As4(), d.As4()
+		copy(ip[12:], s4[:])
+		copy(ip[16:], d4[:])
+		binary.BigEndian.PutUint16(ip[10:], ^checksum(0, ip))
+		pseudo = append(append(append([]byte(nil), s4[:]...), d4[:]...), 0, 17, 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+	} else {
+		ip = make([]byte, 40, 40+len(udp))
+		ip[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+		ip[6] = 17 // UDP
+		ip[7] = 64 // hop limit
+		s16, d16 := s.As16(), d.As16()
+		copy(ip[8:], s16[:])
+		copy(ip[24:], d16[:])
+		pseudo = make([]byte, 40)
+		copy(pseudo, ip[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(udp)))
+		pseudo[39] = 17
+	}
+	sum := ^checksum(checksum(0, pseudo), udp)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return append(ip, udp...)
+}
+
+// checksum adds b to the one's complement sum
+func checksum(sum uint16, b []byte) uint16 {
+	s := uint32(sum)
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+	return uint16(s)
+}
+
+// captureConn is a net.PacketConn writing the packets sent and received
+// to a PcapWriter
+type captureConn struct {
+	net.PacketConn
+	w *PcapWriter
+}
+
+// capture returns conn writing its packets to w, conn itself if w is nil
+func capture(conn net.PacketConn, w *PcapWriter) net.PacketConn {
+	if w == nil {
+		return conn
+	}
+	return &captureConn{conn, w}
+}
+
+// ReadFrom reads a packet and writes it to the capture
+func (c *captureConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	n, addr, err = c.PacketConn.ReadFrom(p)
+	if err == nil {
+		c.w.WritePacket(time.Now(), addrPort(addr), addrPort(c.LocalAddr()), p[:n])
+	}
+	return
+}
+
+// WriteTo writes a packet to the capture and sends it
+func (c *captureConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.w.WritePacket(time.Now(), addrPort(c.LocalAddr()), addrPort(addr), p)
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// Write writes a packet to the capture and sends it on a connected conn
+func (c *captureConn) Write(p []byte) (int, error) {
+	conn, ok := c.PacketConn.(net.Conn)
+	if !ok {
+		return 0, errors.New("tftp: conn not connected")
+	}
+	c.w.WritePacket(time.Now(), addrPort(c.LocalAddr()), addrPort(conn.RemoteAddr()), p)
+	return conn.Write(p)
+}
+
+// addrPort returns the IP address and port of a UDP address, the zero
+// value for other addresses
+func addrPort(addr net.Addr) netip.AddrPort {
+	if a, ok := addr.(*net.UDPAddr); ok {
+		return a.AddrPort()
+	}
+	return netip.AddrPort{}
+}
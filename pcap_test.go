@@ -0,0 +1,104 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.b.Bytes())
+}
+
+// pcapPayloads returns the UDP payloads of the packets in a pcap file,
+// checking the IP and UDP checksums
+func pcapPayloads(t *testing.T, file []byte) [][]byte {
+	t.Helper()
+	if len(file) < 24 || binary.LittleEndian.Uint32(file) != pcapMagic || binary.LittleEndian.Uint32(file[20:]) != linkTypeRaw {
+		t.Fatalf("bad pcap header % x", file[:min(len(file), 24)])
+	}
+	var payloads [][]byte
+	for rec := file[24:]; len(rec) > 0; {
+		n := int(binary.LittleEndian.Uint32(rec[8:]))
+		ip := rec[16 : 16+n]
+		rec = rec[16+n:]
+		var pseudo, udp []byte
+		switch ip[0] >> 4 {
+		case 4:
+			if checksum(0, ip[:20]) != 0xffff {
+				t.Errorf("bad IPv4 header checksum")
+			}
+			udp = ip[20:]
+			pseudo = append(append([]byte(nil), ip[12:20]...), 0, 17, byte(len(udp)>>8), byte(len(udp)))
+		case 6:
+			udp = ip[40:]
+			pseudo = append(append([]byte(nil), ip[8:40]...), 0, 0, byte(len(udp)>>8), byte(len(udp)), 0, 0, 0, 17)
+		default:
+			t.Fatalf("bad IP version %d", ip[0]>>4)
+		}
+		if checksum(checksum(0, pseudo), udp) != 0xffff {
+			t.Errorf("bad UDP checksum")
+		}
+		payloads = append(payloads, udp[8:])
+	}
+	return payloads
+}
+
+func TestPcapWriter(t *testing.T) {
+	var b lockedBuffer
+	w, err := NewPcapWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := netip.MustParseAddrPort("[2001:db8::1]:69")
+	dst := netip.MustParseAddrPort("192.0.2.1:1069")
+	w.WritePacket(time.Now(), src, dst, []byte("odd"))
+	w.WritePacket(time.Now(), dst, dst, []byte("even"))
+	if got := pcapPayloads(t, b.Bytes()); len(got) != 2 || string(got[0]) != "odd" || string(got[1]) != "even" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	var sb, cb lockedBuffer
+	sw, _ := NewPcapWriter(&sb)
+	cw, _ := NewPcapWriter(&cb)
+	m := &MemFS{}
+	m.Set("file", bytes.Repeat([]byte("x"), 600))
+	completed := make(chan Stats, 1)
+	addr := startServer(t, &Server{Handler: m, Capture: sw, OnComplete: func(st Stats) { completed <- st }}).String()
+	c := &Client{Timeout: time.Second, Capture: cw}
+	if err := c.Get(context.Background(), addr, "file", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	<-completed
+	// the same packets on both sides
+	for name, b := range map[string]*lockedBuffer{"server": &sb, "client": &cb} {
+		var ops []Opcode
+		for _, p := range pcapPayloads(t, b.Bytes()) {
+			ops = append(ops, packet(p).opcode())
+		}
+		if want := []Opcode{RRQ, OACK, ACK, DATA, ACK, DATA, ACK}; !slices.Equal(ops, want) {
+			t.Errorf("%s: got %v, want %v", name, ops, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package tftp
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// buffer is a packet buffer from the buffer pools
+type buffer struct {
+	p packet
+}
+
+// bufferPools hold buffers by capacity, pool i holding buffers of
+// capacity 1<<i, large enough for DATA packets of any block size
+var bufferPools [18]sync.Pool
+
+// getBuffer returns a buffer holding a packet of length n
+func getBuffer(n int) *buffer {
+	i := bits.Len(uint(n - 1))
+	if i >= len(bufferPools) {
+		return &buffer{make(packet, n)}
+	}
+	if b, ok := bufferPools[i].Get().(*buffer); ok {
+		b.p = b.p[:n]
+		return b
+	}
+	return &buffer{make(packet, n, 1<<i)}
+}
+
+// free returns b to the pools, it must not be used afterwards
+func (b *buffer) free() {
+	i := bits.Len(uint(cap(b.p) - 1))
+	if i < len(bufferPools) && cap(b.p) == 1<<i {
+		bufferPools[i].Put(b)
+	}
+}
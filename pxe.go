@@ -0,0 +1,138 @@
+package tftp
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// PXEMachine identifies the machine requesting a pxelinux configuration
+// file, which PXELINUX requests in turn as pxelinux.cfg/ followed by the
+// client UUID, the hardware type and MAC address as in 01-88-99-aa-bb-cc-dd,
+// the IPv4 address in upper case hex as in C000025B and shortened by one
+// digit at a time, and finally default.
+type PXEMachine struct {
+	UUID         string           // lower case client UUID, if requested by UUID
+	HardwareType byte             // ARP hardware type, 1 for Ethernet, if requested by MAC address
+	MAC          net.HardwareAddr // hardware address, if requested by MAC address
+	IP           netip.Prefix     // IPv4 address, or prefix if shortened, if requested by IP address
+	Default      bool             // the default configuration is requested
+}
+
+// ParsePXEConfig returns the machine identified by filename if it names a
+// pxelinux configuration file. The pxelinux.cfg directory may be in a
+// subdirectory, as in efi64/pxelinux.cfg/default.
+func ParsePXEConfig(filename string) (m PXEMachine, ok bool) {
+	dir, name := path.Split(strings.TrimLeft(filename, "/"))
+	if path.Base(path.Clean(dir)) != "pxelinux.cfg" {
+		return m, false
+	}
+	switch {
+	case name == "default":
+		m.Default = true
+	case isUUID(name):
+		m.UUID = strings.ToLower(name)
+	case len(name) > 3 && name[2] == '-':
+		b, err := hex.DecodeString(strings.ReplaceAll(name, "-", ""))
+		if err != nil || len(b) < 2 || len(name) != 3*len(b)-1 {
+			return m, false
+		}
+		m.HardwareType, m.MAC = b[0], b[1:]
+	case len(name) >= 1 && len(name) <= 8:
+		n, err := strconv.ParseUint(name, 16, 32)
+		if err != nil {
+			return m, false
+		}
+		bits := 4 * len(name)
+		var a [4]byte
+		n <<= 32 - bits
+		a[0], a[1], a[2], a[3] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+		m.IP = netip.PrefixFrom(netip.AddrFrom4(a), bits)
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// isUUID reports whether s is a UUID in the 8-4-4-4-12 hex digit format
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHex(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isHex reports whether c is a hex digit
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// PXEConfigHandler returns a Handler serving the pxelinux configuration
+// files returned by config for the machine identified by the requested
+// filename. Requests for other files and machines for which config
+// returns nil are refused with FileNotFound, so that PXELINUX tries the
+// next filename. Writes are refused.
+func PXEConfigHandler(config func(r *Request, m PXEMachine) ([]byte, error)) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) error {
+		if r.Op != RRQ {
+			return &fs.PathError{Op: "write", Path: r.Filename, Err: fs.ErrPermission}
+		}
+		m, ok := ParsePXEConfig(r.Filename)
+		if !ok {
+			return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrNotExist}
+		}
+		data, err := config(r, m)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrNotExist}
+		}
+		w.SetSize(int64(len(data)))
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// PXEConfigFilenames returns the filenames PXELINUX requests in turn for a
+// machine with the given UUID, which may be empty, MAC address on an
+// Ethernet interface and IPv4 address
+func PXEConfigFilenames(uuid string, mac net.HardwareAddr, ip netip.Addr) []string {
+	var names []string
+	if uuid != "" {
+		names = append(names, "pxelinux.cfg/"+strings.ToLower(uuid))
+	}
+	if len(mac) > 0 {
+		var b strings.Builder
+		b.WriteString("01")
+		for _, c := range mac {
+			b.WriteByte('-')
+			b.WriteString(hex.EncodeToString([]byte{c}))
+		}
+		names = append(names, "pxelinux.cfg/"+b.String())
+	}
+	if ip.Is4() {
+		a := ip.As4()
+		h := strings.ToUpper(hex.EncodeToString(a[:]))
+		for n := len(h); n > 0; n-- {
+			names = append(names, "pxelinux.cfg/"+h[:n])
+		}
+	}
+	return append(names, "pxelinux.cfg/default")
+}
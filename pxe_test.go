@@ -0,0 +1,78 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePXEConfig(t *testing.T) {
+	mac := net.HardwareAddr{0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd}
+	for _, test := range []struct {
+		filename string
+		want     PXEMachine
+		ok       bool
+	}{
+		{"pxelinux.cfg/default", PXEMachine{Default: true}, true},
+		{"/efi64/pxelinux.cfg/default", PXEMachine{Default: true}, true},
+		{"pxelinux.cfg/B8945908-D6A6-41A9-611D-74A6AB80B83D", PXEMachine{UUID: "b8945908-d6a6-41a9-611d-74a6ab80b83d"}, true},
+		{"pxelinux.cfg/01-88-99-aa-bb-cc-dd", PXEMachine{HardwareType: 1, MAC: mac}, true},
+		{"pxelinux.cfg/C000025B", PXEMachine{IP: netip.MustParsePrefix("192.0.2.91/32")}, true},
+		{"pxelinux.cfg/C000025", PXEMachine{IP: netip.MustParsePrefix("192.0.2.80/28")}, true},
+		{"pxelinux.cfg/C", PXEMachine{IP: netip.MustParsePrefix("192.0.0.0/4")}, true},
+		{"pxelinux.0", PXEMachine{}, false},
+		{"default", PXEMachine{}, false},
+		{"pxelinux.cfg/C000025B0", PXEMachine{}, false},
+		{"pxelinux.cfg/01-88-99-aa-bb-cc-d", PXEMachine{}, false},
+		{"pxelinux.cfg/01-88-99-xx-bb-cc-dd", PXEMachine{}, false},
+		{"pxelinux.cfg/menu.c32", PXEMachine{}, false},
+		{"pxelinux.cfg/", PXEMachine{}, false},
+	} {
+		got, ok := ParsePXEConfig(test.filename)
+		if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, %v, want %+v, %v", test.filename, got, ok, test.want, test.ok)
+		}
+	}
+
+	names := PXEConfigFilenames("B8945908-D6A6-41A9-611D-74A6AB80B83D", mac, netip.MustParseAddr("192.0.2.91"))
+	want := []string{
+		"pxelinux.cfg/b8945908-d6a6-41a9-611d-74a6ab80b83d",
+		"pxelinux.cfg/01-88-99-aa-bb-cc-dd",
+		"pxelinux.cfg/C000025B", "pxelinux.cfg/C000025", "pxelinux.cfg/C00002", "pxelinux.cfg/C0000",
+		"pxelinux.cfg/C000", "pxelinux.cfg/C00", "pxelinux.cfg/C0", "pxelinux.cfg/C",
+		"pxelinux.cfg/default",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+	for _, name := range names {
+		if _, ok := ParsePXEConfig(name); !ok {
+			t.Errorf("%s: not parsed", name)
+		}
+	}
+}
+
+func TestPXEConfigHandler(t *testing.T) {
+	h := PXEConfigHandler(func(r *Request, m PXEMachine) ([]byte, error) {
+		if m.MAC.String() == "88:99:aa:bb:cc:dd" {
+			return []byte("default install\n"), nil
+		}
+		return nil, nil
+	})
+	addr := startServer(t, &Server{Handler: h}).String()
+	c := &Client{Timeout: time.Second}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr, "pxelinux.cfg/01-88-99-aa-bb-cc-dd", &got); err != nil || got.String() != "default install\n" {
+		t.Errorf("got %q, %v", got.String(), err)
+	}
+	for _, name := range []string{"pxelinux.cfg/01-88-99-aa-bb-cc-ee", "pxelinux.cfg/default", "pxelinux.0"} {
+		if err := c.Get(context.Background(), addr, name, &got); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("%s: got %v, want ErrFileNotFound", name, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package tftp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the requests and the file data throughput of each
+// client IP address with token buckets. Requests over the limit are
+// dropped, so clients retransmit them later, and transfers over the limit
+// are slowed down.
+type RateLimit struct {
+	Requests     float64 // steady requests per second, unlimited if zero
+	RequestBurst int     // requests accepted at once, 1 if zero
+	Bytes        float64 // steady file data bytes per second, unlimited if zero
+	ByteBurst    int     // file data bytes transferred at once, 65536 if zero
+}
+
+// defaultByteBurst is the default burst of file data
+const defaultByteBurst = 65536
+
+// bucket is a token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// fill adds the tokens accrued since the last fill at rate, up to burst
+func (b *bucket) fill(now time.Time, rate float64, burst int) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// take takes n tokens, going into debt, and returns the time until the
+// debt is paid off
+func (b *bucket) take(now time.Time, rate float64, burst int, n float64) time.Duration {
+	b.fill(now, rate, burst)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// peerLimit is the state of the buckets of a client
+type peerLimit struct {
+	requests, bytes bucket
+}
+
+// rateLimiter applies a RateLimit to clients by IP address
+type rateLimiter struct {
+	mu    sync.Mutex
+	peers map[string]*peerLimit
+	swept time.Time
+}
+
+// sweepInterval is the interval at which idle clients are forgotten
+const sweepInterval = time.Minute
+
+// peer returns the buckets of the client at addr. It must be called with
+// l.mu held.
+func (l *rateLimiter) peer(addr net.Addr, now time.Time) *peerLimit {
+	ip := addr.String()
+	if a, ok := addr.(*net.UDPAddr); ok {
+		ip = a.IP.String()
+	}
+	if now.Sub(l.swept) > sweepInterval {
+		// clients idle for a sweep interval have full buckets
+		for k, p := range l.peers {
+			if now.Sub(p.requests.last) > sweepInterval && now.Sub(p.bytes.last) > sweepInterval {
+				delete(l.peers, k)
+			}
+		}
+		l.swept = now
+	}
+	p, ok := l.peers[ip]
+	if !ok {
+		if l.peers == nil {
+			l.peers = make(map[string]*peerLimit)
+		}
+		p = new(peerLimit)
+		l.peers[ip] = p
+	}
+	return p
+}
+
+// allow reports whether a request from addr is within rl
+func (l *rateLimiter) allow(rl *RateLimit, addr net.Addr) bool {
+	if rl == nil || rl.Requests <= 0 {
+		return true
+	}
+	burst := rl.RequestBurst
+	if burst <= 0 {
+		burst = 1
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := &l.peer(addr, now).requests
+	b.fill(now, rl.Requests, burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// throttle returns a function waiting until n bytes of file data for addr
+// are within rl, or nil if throughput is unlimited
+func (l *rateLimiter) throttle(rl *RateLimit, addr net.Addr) func(ctx context.Context, n int) {
+	if rl == nil || rl.Bytes <= 0 {
+		return nil
+	}
+	rate, burst := rl.Bytes, rl.ByteBurst
+	if burst <= 0 {
+		burst = defaultByteBurst
+	}
+	return func(ctx context.Context, n int) {
+		now := time.Now()
+		l.mu.Lock()
+		wait := l.peer(addr, now).bytes.take(now, rate, burst, float64(n))
+		l.mu.Unlock()
+		sleep(ctx, wait)
+	}
+}
+
+// shaper limits the file data sent over all transfers. Transfers going
+// into debt wait in turn, so the bandwidth is shared fairly.
+type shaper struct {
+	mu sync.Mutex
+	b  bucket
+}
+
+// wait waits until n more bytes are within rate
+func (s *shaper) wait(ctx context.Context, rate float64, n int) {
+	now := time.Now()
+	s.mu.Lock()
+	wait := s.b.take(now, rate, defaultByteBurst, float64(n))
+	s.mu.Unlock()
+	sleep(ctx, wait)
+}
+
+// throttle returns a function waiting until n bytes of file data of a
+// transfer with peer are within RateLimit and MaxBandwidth, or nil if
+// throughput is unlimited
+func (s *Server) throttle(peer net.Addr, op Opcode) func(ctx context.Context, n int) {
+	c := s.conf()
+	client := s.limiter.throttle(c.RateLimit, peer)
+	rate := c.MaxBandwidth
+	if rate <= 0 || op != RRQ {
+		return client
+	}
+	return func(ctx context.Context, n int) {
+		if client != nil {
+			client(ctx, n)
+		}
+		s.shaper.wait(ctx, rate, n)
+	}
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
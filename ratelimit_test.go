@@ -0,0 +1,86 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	var b bucket
+	now := time.Now()
+	if wait := b.take(now, 100, 10, 10); wait != 0 {
+		t.Errorf("full bucket: got wait %v", wait)
+	}
+	if wait := b.take(now, 100, 10, 5); wait != 50*time.Millisecond {
+		t.Errorf("empty bucket: got wait %v, want 50ms", wait)
+	}
+	// refills up to the burst
+	if wait := b.take(now.Add(time.Hour), 100, 10, 10); wait != 0 {
+		t.Errorf("refilled bucket: got wait %v", wait)
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = make([]byte, 10000)
+	s := files.server()
+	s.RateLimit = &RateLimit{Requests: 0.1, RequestBurst: 1, Bytes: 20000, ByteBurst: 4096}
+	addr := startServer(t, s)
+
+	start := time.Now()
+	c := &Client{Timeout: time.Second, BlockSize: 1024}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	// 4096 bytes of burst, the rest at 20000 per second
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("transfer took %v, want at least 250ms", d)
+	}
+
+	// the request burst is used up, so the next request is dropped
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := conn.ReadFrom(make([]byte, 1024)); !isTimeout(err) {
+		t.Errorf("got %v, want timeout", err)
+	}
+}
+
+func TestServerMaxBandwidth(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = make([]byte, 65536)
+	s := files.server()
+	s.MaxBandwidth = 200000
+	addr := startServer(t, s)
+
+	// 65536 bytes of burst, the rest shared at 200000 per second
+	start := time.Now()
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			c := &Client{Timeout: time.Second, BlockSize: 1024, WindowSize: 4}
+			errs <- c.Get(context.Background(), addr.String(), "file", io.Discard)
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 600*time.Millisecond {
+		t.Errorf("transfers took %v, want at least 600ms", d)
+	}
+
+	// uploads are not limited
+	start = time.Now()
+	c := &Client{Timeout: time.Second, BlockSize: 1024, WindowSize: 4}
+	if err := c.Put(context.Background(), addr.String(), "upload", bytes.NewReader(make([]byte, 400000))); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("upload took %v, want no limit", d)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tftp
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT
+const soReusePort = syscall.SO_REUSEPORT
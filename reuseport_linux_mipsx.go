@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package tftp
+
+// soReusePort is SO_REUSEPORT, missing from package syscall on Linux
+const soReusePort = 0x200
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package tftp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort fails, SO_REUSEPORT is not supported on this system
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("tftp: SO_REUSEPORT not supported")
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tftp
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// reusePort sets SO_REUSEPORT on the socket of c before it is bound
+func reusePort(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("tftp: setting SO_REUSEPORT: %w", serr)
+	}
+	return nil
+}
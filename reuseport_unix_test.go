@@ -0,0 +1,78 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListenReusePort(t *testing.T) {
+	conns, err := ListenReusePort("127.0.0.1:0", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	if len(conns) != 4 {
+		t.Fatalf("got %d sockets, want 4", len(conns))
+	}
+	addr := conns[0].LocalAddr().String()
+	for _, conn := range conns[1:] {
+		if conn.LocalAddr().String() != addr {
+			t.Errorf("got socket on %v, want %v", conn.LocalAddr(), addr)
+		}
+	}
+
+	var served atomic.Int32
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+		served.Add(1)
+		_, err := w.Write([]byte("data"))
+		return err
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ServeAll(ctx, conns...) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// every socket serves the clients the kernel hashes to it
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got bytes.Buffer
+			c := &Client{Timeout: time.Second}
+			if err := c.Get(context.Background(), addr, "file", &got); err != nil || got.String() != "data" {
+				t.Errorf("got %q, %v", got.String(), err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := served.Load(); n != 16 {
+		t.Errorf("served %d requests, want 16", n)
+	}
+
+	// a port in use without SO_REUSEPORT is refused
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if c, err := ListenReusePort(conn.LocalAddr().String(), 2); err == nil {
+		c[0].Close()
+		c[1].Close()
+		t.Error("joined a port not shared")
+	}
+}
@@ -0,0 +1,224 @@
+// Package s3 implements a tftp.Backend serving files from an S3 compatible
+// object store, signing requests with AWS Signature Version 4
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+var _ tftp.Backend = (*Backend)(nil)
+
+// Backend stores files as objects in a bucket, with the file name
+// following Prefix as the object key
+type Backend struct {
+	Endpoint        string       // base URL of the service, for example https://s3.eu-west-1.amazonaws.com
+	Region          string       // region for signing, us-east-1 if empty
+	Bucket          string       // bucket name, addressed path style
+	Prefix          string       // prefix of object keys
+	AccessKeyID     string       // credentials, requests are not signed if empty
+	SecretAccessKey string       // credentials
+	SessionToken    string       // credentials, for temporary credentials only
+	Client          *http.Client // client for requests, http.DefaultClient if nil
+}
+
+// emptyHash is the SHA-256 hash of an empty payload
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Open opens an object for reading
+func (b *Backend) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Create returns a writer buffering an object in memory, which is uploaded
+// when the writer is closed
+func (b *Backend) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &object{b: b, ctx: ctx, name: name}, nil
+}
+
+// Stat returns the size and modification time of an object
+func (b *Backend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	resp, err := b.do(ctx, http.MethodHead, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	fi := &fileInfo{name: path.Base(name), size: resp.ContentLength}
+	fi.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return fi, nil
+}
+
+// Remove deletes an object
+func (b *Backend) Remove(ctx context.Context, name string) error {
+	resp, err := b.do(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for the object of a file, returning an error
+// for responses other than 2xx
+func (b *Backend) do(ctx context.Context, method, name string, payload []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(b.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + b.Bucket + "/" + b.Prefix + name
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		req.Body, req.GetBody, req.ContentLength = http.NoBody, nil, 0
+	}
+	if b.AccessKeyID != "" {
+		b.sign(req, payloadHash(payload), time.Now())
+	}
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	resp.Body.Close()
+	err = fmt.Errorf("s3: %s %s: %s", method, b.Prefix+name, resp.Status)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case http.StatusForbidden:
+		err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return nil, err
+}
+
+// payloadHash returns the hex encoded SHA-256 hash of a payload
+func payloadHash(payload []byte) string {
+	if len(payload) == 0 {
+		return emptyHash
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds an AWS Signature Version 4 authorization to req, signing the
+// host and all headers of req
+func (b *Backend) sign(req *http.Request, hash string, t time.Time) {
+	region := b.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := t.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+	if b.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		headers["host"] = req.Host
+	}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hash,
+	}, "\n")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + b.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// object is an object being written
+type object struct {
+	bytes.Buffer
+	b    *Backend
+	ctx  context.Context
+	name string
+}
+
+// Close uploads the object
+func (o *object) Close() error {
+	resp, err := o.b.do(o.ctx, http.MethodPut, o.name, o.Bytes())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Abort discards the object without uploading it
+func (o *object) Abort() error {
+	o.Reset()
+	return nil
+}
+
+// fileInfo describes an object
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return false }
+func (fi *fileInfo) Sys() any           { return nil }
@@ -0,0 +1,66 @@
+package tftp
+
+import (
+	"os"
+	"time"
+)
+
+// Option configures a Server created by ServeDir
+type Option func(*Server)
+
+// WithMaxBlockSize sets the largest negotiated block size
+func WithMaxBlockSize(n int) Option {
+	return func(s *Server) { s.MaxBlockSize = n }
+}
+
+// WithMaxWindowSize sets the largest negotiated window size
+func WithMaxWindowSize(n int) Option {
+	return func(s *Server) { s.MaxWindowSize = n }
+}
+
+// WithTimeout sets the initial retransmission interval
+func WithTimeout(d time.Duration) Option {
+	return func(s *Server) { s.Timeout = d }
+}
+
+// WithRetries sets the retransmissions before giving up
+func WithRetries(n int) Option {
+	return func(s *Server) { s.Retries = n }
+}
+
+// WithMiddleware wraps the handler with middleware, the first being the
+// outermost
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(s *Server) { s.Handler = Chain(s.Handler, middleware...) }
+}
+
+// ServeDir listens on the UDP address addr and serves the files in the
+// directory tree at root read-only. Files outside root, also through
+// symbolic links, are not served and writes are refused. The largest block
+// size fits an Ethernet frame and the initial retransmission interval is
+// one second unless changed by options.
+func ServeDir(addr, root string, opts ...Option) error {
+	s, dir, err := newDirServer(root, opts...)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return s.ListenAndServe(addr)
+}
+
+// newDirServer returns a server for ServeDir and the opened root
+func newDirServer(root string, opts ...Option) (*Server, *os.Root, error) {
+	dir, err := os.OpenRoot(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &Server{
+		Handler:      FS(dir.FS()),
+		MaxBlockSize: 1468, // Ethernet MTU less IP, UDP and TFTP headers
+		Timeout:      time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, dir, nil
+}
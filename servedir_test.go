@@ -0,0 +1,59 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServeDir(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	os.MkdirAll(filepath.Join(root, "pxelinux.cfg"), 0o755)
+	os.WriteFile(filepath.Join(root, "pxelinux.cfg", "default"), []byte("default local\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644)
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Skip("symlinks unavailable:", err)
+	}
+
+	var requests atomic.Int32
+	s, r, err := newDirServer(root, WithMaxBlockSize(512), WithMiddleware(func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) error {
+			requests.Add(1)
+			return next.ServeTFTP(w, r)
+		})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if s.MaxBlockSize != 512 || s.Timeout != time.Second {
+		t.Errorf("got MaxBlockSize %d Timeout %v", s.MaxBlockSize, s.Timeout)
+	}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second}
+	ctx := context.Background()
+
+	var got bytes.Buffer
+	if err := c.Get(ctx, addr, "pxelinux.cfg/default", &got); err != nil || got.String() != "default local\n" {
+		t.Errorf("got %q, %v", got.String(), err)
+	}
+	for _, name := range []string{"../secret", "link", "/../secret"} {
+		if err := c.Get(ctx, addr, name, &got); err == nil {
+			t.Errorf("%s: served a file outside root", name)
+		}
+	}
+	if err := c.Put(ctx, addr, "upload", bytes.NewReader([]byte("x"))); err == nil {
+		t.Error("write accepted")
+	}
+	if _, err := os.Stat(filepath.Join(root, "upload")); err == nil {
+		t.Error("file written")
+	}
+	if n := requests.Load(); n != 5 {
+		t.Errorf("middleware called %d times, want 5", n)
+	}
+}
@@ -0,0 +1,1279 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRequestSize is the size of the buffer for incoming requests
+const maxRequestSize = 65536
+
+// Server is a TFTP server
+type Server struct {
+	Handler       Handler       // handler for requests, ReadHandler and WriteHandler are used if nil
+	Backend       Backend       // file store used if Handler, ReadHandler and WriteHandler are nil
+	ReadHandler   ReadHandler   // handler for RRQ, reads are refused if nil
+	WriteHandler  WriteHandler  // handler for WRQ, writes are refused if nil
+	MaxBlockSize  int           // largest negotiated block size, 65464 if zero
+	MaxWindowSize int           // largest negotiated window size, 64 if zero
+	Timeout       time.Duration // initial retransmission interval, 5 seconds if zero, doubled for each retransmission
+	Retries       int           // retransmissions of a block, acknowledgement or OACK before giving up, 5 if zero
+	MaxTimeout    time.Duration // largest negotiated timeout, 255 seconds if zero
+	Newline       Newline       // line ending of the local text of WRQ bodies in netascii, LF if zero
+	MaxFileSize   int64         // largest file sent for a RRQ, unlimited if zero
+	MaxRequest    int           // largest request packet accepted, 512 bytes as of RFC 2347 if zero
+	MaxFilename   int           // longest filename accepted, 255 bytes if zero
+
+	HandshakeTimeout time.Duration // longest wait for the first reply of a client over all retransmissions, unlimited if zero
+	IdleTimeout      time.Duration // longest time a transfer makes no progress before it fails, unlimited if zero
+
+	// NegotiationPolicy adjusts or refuses the options negotiated for each
+	// request, after MaxBlockSize, MaxWindowSize and MaxTimeout apply
+	NegotiationPolicy NegotiationPolicy
+
+	// AcknowledgeOption is called for each requested option the server
+	// does not implement, returning true acknowledges it with value ack in
+	// the OACK of a unicast transfer. All requested options are in
+	// Request.Options whether acknowledged or not.
+	AcknowledgeOption func(r *Request, name, value string) (ack string, ok bool)
+
+	// Resume acknowledges the nonstandard x-offset option of a unicast
+	// RRQ in octet mode, with which a Client resumes an interrupted
+	// download from a byte offset rather than from the start. The file
+	// data before the offset written by the handler is not sent. Uploads
+	// are not resumed, as handlers store files only once completely
+	// written.
+	Resume bool
+
+	RateLimit    *RateLimit // limits of each client IP address, unlimited if nil
+	MaxBandwidth float64    // file data bytes per second sent over all unicast transfers, unlimited if zero
+	MinPort      int        // lowest port of transfers, with MaxPort, any ephemeral port if zero
+	MaxPort      int        // highest port of transfers
+	DSCP         int        // DiffServ code point marking the packets of transfers, from 0 to 63, unmarked if zero
+	GSO          bool       // send windows of DATA packets with UDP segmentation offload on Linux, ignored elsewhere
+	Transport    Transport  // transport of the conns of transfers, UDP sockets if nil
+
+	// Clock is the clock of timeouts and retransmissions, the system clock
+	// if nil, also of multicast transfers and of the transfers sharing the
+	// listening conn with SinglePort. Read deadlines of conns are set in
+	// its time, so another clock suits conns of a Loopback with the same
+	// Clock only.
+	Clock Clock
+
+	// PathMTU lowers the negotiated block size so that DATA packets fit
+	// the MTU of the interface towards the client, as fragmented packets
+	// are often dropped by firewalls and PXE ROMs. DontFragment sets DF on
+	// the packets of transfers, on Linux only.
+	PathMTU      bool
+	DontFragment bool
+
+	MaxConcurrentTransfers int            // transfers served at once, unlimited if zero
+	Overflow               OverflowPolicy // handling of requests beyond MaxConcurrentTransfers or a full worker queue
+
+	// Workers is the number of goroutines serving the requests received
+	// on each conn, with QueueSize requests waiting for a free worker.
+	// Each request is served on a new goroutine if zero.
+	Workers   int
+	QueueSize int
+
+	// ReusePort is the number of sockets ListenAndServe and
+	// ListenAndServeAll open on each address with SO_REUSEPORT, each
+	// served by its own loop, so that the kernel spreads requests over
+	// cores. One socket is opened if zero or one, on Linux and the BSDs
+	// only otherwise.
+	ReusePort int
+
+	// SinglePort serves unicast transfers from the listening port instead
+	// of a new ephemeral port each, routing packets to transfers by peer
+	// address, for firewalls and NATs passing port 69 only. Requests
+	// beyond MaxConcurrentTransfers are dropped rather than queued, as the
+	// listening conn keeps receiving for the transfers in progress.
+	// MinPort, MaxPort, DSCP and DontFragment do not apply to the
+	// listening conn.
+	SinglePort bool
+
+	Logger  *slog.Logger // logger for requests, transfers and errors, nothing is logged if nil
+	Capture *PcapWriter  // capture of all packets sent and received, nothing is captured if nil
+
+	// Authorize is called for each request before it is handled, an error
+	// refuses the request with an ERROR packet carrying the error message,
+	// with the code of an *Error, the error code for fs.ErrNotExist or
+	// fs.ErrExist or, by default, access violation
+	Authorize func(peer net.Addr, op Opcode, filename string, mode Mode) error
+
+	// TransferContext returns the context for the transfer of a request,
+	// derived from r.Context(), for example to add a Trace with WithTrace
+	TransferContext func(r *Request) context.Context
+
+	// Audit is called once for every RRQ and WRQ received when it is
+	// served, refused or dropped, also for requests refused before the
+	// handler runs, for compliance logging
+	Audit func(AuditRecord)
+
+	// OnListen is called with the local address of each conn once
+	// ServeContext receives requests on it, as when the server is ready
+	OnListen func(addr net.Addr)
+
+	// OnComplete is called with the statistics of each completed unicast
+	// transfer
+	OnComplete func(Stats)
+
+	// OnProgress is called with the file data transferred so far and the
+	// file size, -1 if unknown, as blocks of a unicast transfer are
+	// received or acknowledged
+	OnProgress func(r *Request, transferred, size int64)
+
+	// OnTransferStart is called with each request served by a unicast
+	// transfer once it is authorized and its options negotiated, before
+	// the handler runs. OnTransferComplete or OnTransferError is then
+	// called with the request and the statistics of the transfer once it
+	// succeeds or fails. Requests refused before the transfer starts are
+	// reported to Audit only.
+	OnTransferStart    func(r *Request)
+	OnTransferComplete func(r *Request, st Stats)
+	OnTransferError    func(r *Request, st Stats)
+
+	Multicast       *net.UDPAddr // first group for RFC 2090 multicast transfers, refused if nil
+	MulticastGroups int          // number of consecutive groups from Multicast, 16 if zero
+
+	config atomic.Pointer[Server] // configuration set by Reload, s itself if nil
+
+	mu        sync.Mutex
+	multicast map[string]*multicastSession // active multicast sessions by file
+	groups    map[string]bool              // multicast groups in use
+	listeners map[net.PacketConn]*listener // conns being served
+	shutdown  bool                         // no new requests are accepted
+	closed    context.Context              // done when transfers must stop
+	closeAll  context.CancelFunc
+	active    sync.WaitGroup // transfers in progress
+	stats     ServerStats
+	limiter   rateLimiter
+	shaper    shaper
+	slots     chan struct{}           // transfers served, nil if unlimited
+	sessions  map[*session]struct{}   // unicast transfers in progress
+	refs      map[*Server]*configRefs // requests being served by configuration
+}
+
+// OverflowPolicy is the handling of requests received while
+// MaxConcurrentTransfers are served
+type OverflowPolicy int
+
+const (
+	// OverflowQueue stops accepting requests until a transfer completes,
+	// further requests wait in the socket buffer
+	OverflowQueue OverflowPolicy = iota
+	// OverflowReject refuses requests with an ERROR packet so clients back
+	// off
+	OverflowReject
+)
+
+// ErrServerClosed is returned by Serve after Shutdown or Close
+var ErrServerClosed = errors.New("tftp: server closed")
+
+// defaultMaxWindowSize is the default largest negotiated window size
+const defaultMaxWindowSize = 64
+
+// ListenAndServe listens on the UDP address addr and serves requests,
+// ":69" is used if addr is empty
+func (s *Server) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = ":69"
+	}
+	if s.conf().ReusePort > 1 {
+		return s.ListenAndServeAll(addr)
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return s.Serve(conn)
+}
+
+// Serve accepts requests on conn, serving each transfer from a new
+// ephemeral port or a new conn of Transport, or from conn with SinglePort
+// or if conn is no UDP socket and Transport is nil
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.ServeContext(context.Background(), conn)
+}
+
+// ServeContext is like Serve but stops accepting requests and cancels the
+// transfers in progress when ctx is done, returning the context's error
+func (s *Server) ServeContext(ctx context.Context, conn net.PacketConn) error {
+	c := s.conf()
+	single := c.SinglePort || c.Transport == nil && !isUDPAddr(conn.LocalAddr())
+	l := s.track(conn, single)
+	if l == nil {
+		return ErrServerClosed
+	}
+	defer s.untrack(conn)
+	if s.OnListen != nil {
+		s.OnListen(conn.LocalAddr())
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+	conn = capture(conn, c.Capture)
+	iface := interfaceName(conn.LocalAddr())
+	var d *demux
+	if single {
+		d = newDemux(conn, c.Clock)
+	}
+	var queue chan func()
+	if c.Workers > 0 {
+		queue = make(chan func(), c.QueueSize)
+		defer close(queue)
+		for range c.Workers {
+			go func() {
+				for f := range queue {
+					f()
+				}
+			}()
+		}
+	}
+	buf := make([]byte, maxRequestSize)
+	for {
+		l.handling(false)
+		n, addr, err := conn.ReadFrom(buf)
+		l.handling(true)
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		req := packet(append([]byte(nil), buf[:n]...))
+		if d != nil && d.deliver(addr, req) {
+			continue
+		}
+		switch req.opcode() {
+		case RRQ, WRQ:
+			if !s.limiter.allow(s.conf().RateLimit, addr) {
+				s.auditRequest(addr, req, AuditDropped, errRateLimited)
+				continue
+			}
+			// waiting for a transfer to complete is not wedged
+			l.handling(false)
+			ok, err := s.acquire(ctx, d == nil)
+			l.handling(true)
+			if err != nil {
+				return err
+			} else if !ok {
+				s.busy(conn, addr, req)
+				continue
+			}
+			if !s.begin() {
+				s.release()
+				s.auditRequest(addr, req, AuditDropped, ErrServerClosed)
+				if d != nil {
+					// keep routing packets to the transfers in progress
+					continue
+				}
+				return ErrServerClosed
+			}
+			var tconn net.PacketConn
+			if d != nil {
+				tconn = d.open(addr)
+			}
+			l.handling(false)
+			ok, err = s.dispatch(ctx, queue, d == nil, func() {
+				defer s.active.Done()
+				defer s.release()
+				s.serve(ctx, tconn, conn.LocalAddr(), iface, addr, req)
+			})
+			l.handling(true)
+			if !ok {
+				s.active.Done()
+				s.release()
+				if tconn != nil {
+					tconn.Close()
+				}
+				if err != nil {
+					return err
+				}
+				s.busy(conn, addr, req)
+			}
+		case DATA, ACK, ERROR, OACK:
+			// late packets of transfers that ended
+		default:
+			if s.limiter.allow(s.conf().RateLimit, addr) {
+				conn.WriteTo(newERRORPacket(IllegalOperation, req.check().Error()), addr)
+			}
+		}
+	}
+}
+
+// Shutdown stops accepting requests and waits for the transfers in
+// progress to complete. When ctx is done first, the remaining transfers
+// are cancelled and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop()
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeAll()
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting requests and cancels the transfers in progress
+// without waiting for them
+func (s *Server) Close() error {
+	s.stop()
+	s.closeAll()
+	return nil
+}
+
+// Reload replaces the handlers, limits and policies of s with the fields
+// of c, a Server used for its configuration only, which must not be
+// modified afterwards. It may be called while s is serving: each request
+// is served with the configuration current when it is received, so the
+// transfers in progress are not interrupted. MaxConcurrentTransfers,
+// Multicast and MulticastGroups are not changed, the settings of listening
+// conns apply to the conns served afterwards. The hooks Audit,
+// TransferContext and the On functions of s are kept, so that the metrics
+// and tracing chained into them keep recording. Reload(nil) restores the
+// fields of s. The channel returned is closed once the requests received
+// with the previous configuration are served, when the resources of its
+// handlers may be released.
+func (s *Server) Reload(c *Server) <-chan struct{} {
+	drained := make(chan struct{})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.conf()
+	s.config.Store(c)
+	if r := s.refs[prev]; r != nil {
+		r.drained = append(r.drained, drained)
+	} else {
+		close(drained)
+	}
+	return drained
+}
+
+// configRefs counts the requests being served with a configuration
+type configRefs struct {
+	n       int
+	drained []chan struct{} // closed once n is zero, by Reload
+}
+
+// useConf returns the configuration of s to serve a request with, which
+// must be released by doneConf once the request is served
+func (s *Server) useConf() *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.conf()
+	if s.refs == nil {
+		s.refs = make(map[*Server]*configRefs)
+	}
+	r := s.refs[c]
+	if r == nil {
+		r = &configRefs{}
+		s.refs[c] = r
+	}
+	r.n++
+	return c
+}
+
+// doneConf ends a request served with the configuration c
+func (s *Server) doneConf(c *Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.refs[c]
+	if r.n--; r.n == 0 {
+		for _, drained := range r.drained {
+			close(drained)
+		}
+		delete(s.refs, c)
+	}
+}
+
+// conf returns the configuration of s set by Reload, s itself if none
+func (s *Server) conf() *Server {
+	if c := s.config.Load(); c != nil {
+		return c
+	}
+	return s
+}
+
+// init initializes the shutdown state. It must be called with s.mu held.
+func (s *Server) init() {
+	if s.closed == nil {
+		s.closed, s.closeAll = context.WithCancel(context.Background())
+		s.listeners = make(map[net.PacketConn]*listener)
+		if s.MaxConcurrentTransfers > 0 {
+			s.slots = make(chan struct{}, s.MaxConcurrentTransfers)
+		}
+	}
+}
+
+// acquire takes a slot for a transfer, waiting for one with OverflowQueue
+// if wait is true. It returns false if none is free, or an error if ctx is
+// done or the server closed while waiting.
+func (s *Server) acquire(ctx context.Context, wait bool) (bool, error) {
+	if s.slots == nil {
+		return true, nil
+	}
+	if s.conf().Overflow == OverflowReject || !wait {
+		select {
+		case s.slots <- struct{}{}:
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-s.closed.Done():
+		return false, ErrServerClosed
+	}
+}
+
+// dispatch runs f on a worker of queue, or on a new goroutine if queue is
+// nil. With a full queue it waits for a worker with OverflowQueue if wait
+// is true, otherwise it returns false. It returns an error if ctx is done
+// or the server closed while waiting.
+func (s *Server) dispatch(ctx context.Context, queue chan func(), wait bool, f func()) (bool, error) {
+	if queue == nil {
+		go f()
+		return true, nil
+	}
+	if s.conf().Overflow == OverflowReject || !wait {
+		select {
+		case queue <- f:
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+	select {
+	case queue <- f:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-s.closed.Done():
+		return false, ErrServerClosed
+	}
+}
+
+// busy refuses a request with OverflowReject, and drops it otherwise
+func (s *Server) busy(conn net.PacketConn, peer net.Addr, req packet) {
+	if s.conf().Overflow == OverflowReject {
+		conn.WriteTo(newERRORPacket(NotDefined, "server busy"), peer)
+		s.auditRequest(peer, req, AuditRefused, errBusy)
+		return
+	}
+	s.auditRequest(peer, req, AuditDropped, errBusy)
+}
+
+// release frees the slot of a transfer
+func (s *Server) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// stop marks the server as shut down and closes the conns being served
+func (s *Server) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.shutdown = true
+	for conn, l := range s.listeners {
+		if l.shared {
+			// closed when the transfers sharing it are done
+			go func() {
+				s.active.Wait()
+				conn.Close()
+			}()
+			continue
+		}
+		conn.Close()
+	}
+}
+
+// shuttingDown reports whether Shutdown or Close was called
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// track adds conn to the conns being served, shared by transfers if
+// shared is true, it returns nil after Shutdown or Close
+func (s *Server) track(conn net.PacketConn, shared bool) *listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.shutdown {
+		return nil
+	}
+	l := &listener{shared: shared}
+	s.listeners[conn] = l
+	return l
+}
+
+// untrack removes conn from the conns being served
+func (s *Server) untrack(conn net.PacketConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, conn)
+}
+
+// listener is the state of a conn being served
+type listener struct {
+	shared bool         // the conn is shared by transfers
+	busy   atomic.Int64 // Unix time in nanoseconds the loop started handling a request, zero while waiting
+}
+
+// handling records that the loop serving l is handling a request, or
+// waiting if not
+func (l *listener) handling(busy bool) {
+	if busy {
+		l.busy.Store(time.Now().UnixNano())
+	} else {
+		l.busy.Store(0)
+	}
+}
+
+// Healthy reports whether requests are received and served: some conn is
+// served, and the loop of each is waiting for a request or for a transfer
+// to complete, or has been handling a request for less than d. It does
+// not return while the server is deadlocked, for watchdogs.
+func (s *Server) Healthy(d time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return false
+	}
+	now := time.Now().UnixNano()
+	for _, l := range s.listeners {
+		if busy := l.busy.Load(); busy != 0 && now-busy >= int64(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// begin counts a new transfer in progress, it returns false after
+// Shutdown or Close
+func (s *Server) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return false
+	}
+	s.active.Add(1)
+	return true
+}
+
+// serve serves a single request from peer received on local of the network
+// interface iface, on conn, or on a new ephemeral port or conn of Transport
+// if conn is nil
+func (s *Server) serve(ctx context.Context, conn net.PacketConn, local net.Addr, iface string, peer net.Addr, req packet) {
+	c := s.useConf()
+	defer s.doneConf(c)
+	var err error
+	connected := conn == nil && c.Transport == nil
+	if conn == nil {
+		if c.Transport != nil {
+			if conn, err = c.Transport.ListenPacket(ctx, local, peer); err == nil {
+				conn = capture(conn, c.Capture)
+			}
+		} else {
+			conn, err = c.listen(local, peer)
+		}
+		if err != nil {
+			if c.Logger != nil {
+				c.Logger.Error("listen failed", "peer", peer.String(), "err", err)
+			}
+			s.auditRequest(peer, req, AuditFailed, err)
+			return
+		}
+	}
+	defer conn.Close()
+	sctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	defer context.AfterFunc(s.closed, func() { cancel(nil) })()
+	t := newTransfer(sctx, conn, peer, true)
+	t.setClock(c.Clock)
+	if connected {
+		t.connected = true
+		t.batch = newBatchConn(conn, peer, true)
+	}
+	defer t.watch()()
+	if c.Timeout > 0 {
+		t.timeout = c.Timeout
+	}
+	if c.Retries > 0 {
+		t.retries, t.requestRetries = c.Retries, c.Retries
+	}
+	t.handshake, t.idle = c.HandshakeTimeout, c.IdleTimeout
+	t.throttle = s.throttle(peer, req.opcode())
+	t.gso = c.GSO
+	filename, mode, raw, err := unmarshalRequest(req, req.opcode())
+	if err == nil {
+		err = c.checkRequest(req, filename, raw)
+	}
+	if err != nil {
+		t.send(newERRORPacket(IllegalOperation, err.Error()))
+		s.auditRequest(peer, req, AuditRefused, err)
+		return
+	}
+	options, err := parseOptions(raw)
+	if err != nil {
+		t.send(newERRORPacket(IllegalOperation, err.Error()))
+		s.auditRequest(peer, req, AuditRefused, err)
+		return
+	}
+	oack := c.negotiate(options)
+	if req.opcode() != RRQ || mode != Octet {
+		delete(oack, offset)
+	}
+	if c.PathMTU {
+		clampBlockSize(oack, peer)
+	}
+	r := &Request{
+		Op:           req.opcode(),
+		Filename:     filename,
+		Mode:         mode,
+		RemoteAddr:   peer,
+		LocalAddr:    local,
+		Interface:    iface,
+		TransferAddr: conn.LocalAddr(),
+		Options:      raw,
+		cancel:       cancel,
+	}
+	t.ctx = context.WithValue(t.ctx, requestKey{}, r)
+	r.ctx = t.ctx
+	if s.TransferContext != nil {
+		t.ctx = s.TransferContext(r)
+		r.ctx = t.ctx
+		t.trace = ContextTrace(t.ctx)
+	}
+	t.traceReceive(req, peer)
+	if c.Logger != nil {
+		t.log = c.Logger.With("peer", peer.String(), "op", r.Op.String(), "filename", r.Filename)
+		t.log.Info("request", "mode", r.Mode.String(), "options", r.Options)
+	}
+	if s.OnProgress != nil {
+		t.onProgress = func(transferred, size int64) {
+			s.OnProgress(r, transferred, size)
+		}
+	}
+	if c.Authorize != nil {
+		if err := c.Authorize(peer, r.Op, r.Filename, r.Mode); err != nil {
+			s.refuse(t, r, err, AccessViolation)
+			return
+		}
+	}
+	noOACK := false
+	if c.NegotiationPolicy != nil {
+		n := negotiation(oack)
+		if err := c.NegotiationPolicy(r, n); err != nil {
+			s.refuse(t, r, err, NotDefined)
+			return
+		}
+		n.apply(oack)
+		if noOACK = n.NoOACK; noOACK {
+			// served as RFC 1350, without multicast
+			delete(options, multicast)
+		}
+	}
+	r.negotiated(oack)
+	if c.AcknowledgeOption != nil && !noOACK {
+		t.extra = c.acknowledge(r)
+	}
+	if _, ok := options[multicast]; ok && r.Op == RRQ && s.Multicast != nil {
+		if s.serveMulticast(r, oack) {
+			st := t.stats(r.Op, r.Filename, nil)
+			t.done(st)
+			s.auditStats(t, r, st, AuditCompleted)
+			return
+		}
+	}
+	s.mu.Lock()
+	s.stats.Active++
+	s.mu.Unlock()
+	if s.OnTransferStart != nil {
+		s.OnTransferStart(r)
+	}
+	untrack := s.trackSession(t, r)
+	switch r.Op {
+	case RRQ:
+		err = c.serveRead(t, r, oack)
+	case WRQ:
+		err = c.serveWrite(t, r, oack)
+	}
+	untrack()
+	st := t.stats(r.Op, r.Filename, err)
+	s.record(t, st)
+	s.auditStats(t, r, st, AuditCompleted)
+	if err == nil && s.OnTransferComplete != nil {
+		s.OnTransferComplete(r, st)
+	} else if err != nil && s.OnTransferError != nil {
+		s.OnTransferError(r, st)
+	}
+}
+
+// acknowledge returns the options of r unknown to the server that
+// AcknowledgeOption acknowledges, with their acknowledged values
+func (s *Server) acknowledge(r *Request) map[string]string {
+	var extra map[string]string
+	for name, value := range r.Options {
+		if name == "" || knownOption(name) {
+			continue
+		}
+		ack, ok := s.AcknowledgeOption(r, name, value)
+		if !ok || strings.IndexByte(ack, 0) >= 0 {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[name] = ack
+	}
+	return extra
+}
+
+// refuse refuses a request with an ERROR packet for err, with code if err
+// maps to no other error code
+func (s *Server) refuse(t *transfer, r *Request, err error, code ErrorCode) {
+	t.send(errorPacket(err, code))
+	s.mu.Lock()
+	s.stats.Active++
+	s.mu.Unlock()
+	st := t.stats(r.Op, r.Filename, err)
+	s.record(t, st)
+	s.auditStats(t, r, st, AuditRefused)
+}
+
+// defaultMaxRequest and defaultMaxFilename are the limits of requests
+// unless MaxRequest and MaxFilename are set
+const (
+	defaultMaxRequest  = 512
+	defaultMaxFilename = 255
+)
+
+// checkRequest returns an error if the request req for filename with the
+// options raw exceeds the limits of the server or contains control
+// characters, which no legitimate client sends
+func (s *Server) checkRequest(req packet, filename string, raw map[string]string) error {
+	maxRequest, maxFilename := s.MaxRequest, s.MaxFilename
+	if maxRequest <= 0 {
+		maxRequest = defaultMaxRequest
+	}
+	if maxFilename <= 0 {
+		maxFilename = defaultMaxFilename
+	}
+	if len(req) > maxRequest {
+		return fmt.Errorf("%w: request of %d bytes exceeds %d", ErrMalformedPacket, len(req), maxRequest)
+	}
+	if len(filename) > maxFilename {
+		return fmt.Errorf("%w: filename of %d bytes exceeds %d", ErrMalformedPacket, len(filename), maxFilename)
+	}
+	if hasControl(filename) {
+		return fmt.Errorf("%w: control character in filename %q", ErrMalformedPacket, filename)
+	}
+	for name, value := range raw {
+		if hasControl(name) || hasControl(value) {
+			return fmt.Errorf("%w: control character in option %q", ErrMalformedPacket, name)
+		}
+	}
+	return nil
+}
+
+// hasControl reports whether s contains an ASCII control character
+func hasControl(s string) bool {
+	return strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f })
+}
+
+// negotiate returns the options to acknowledge for the requested options
+func (s *Server) negotiate(requested map[option]int) map[option]int {
+	oack := make(map[option]int)
+	if n, ok := requested[blksize]; ok && n >= minBlockSize {
+		max := s.MaxBlockSize
+		if max <= 0 || max > maxBlockSize {
+			max = maxBlockSize
+		}
+		if n > max {
+			n = max
+		}
+		oack[blksize] = n
+	}
+	if n, ok := requested[windowsize]; ok && n >= 1 && n <= maxWindowSize {
+		max := s.MaxWindowSize
+		if max <= 0 {
+			max = defaultMaxWindowSize
+		}
+		if n > max {
+			n = max
+		}
+		oack[windowsize] = n
+	}
+	if n, ok := requested[timeout]; ok && n >= minTimeout && n <= maxTimeout {
+		// the timeout cannot be changed, only acknowledged or ignored
+		if s.MaxTimeout <= 0 || time.Duration(n)*time.Second <= s.MaxTimeout {
+			oack[timeout] = n
+		}
+	}
+	if n, ok := requested[tsize]; ok && n >= 0 {
+		oack[tsize] = n
+	}
+	if n, ok := requested[rollover]; ok {
+		oack[rollover] = n
+	}
+	if n, ok := requested[offset]; ok && s.Resume && n >= 0 {
+		oack[offset] = n
+	}
+	return oack
+}
+
+// serveRead serves a RRQ
+func (s *Server) serveRead(t *transfer, r *Request, oack map[option]int) error {
+	if s.Handler == nil && s.ReadHandler == nil && s.Backend == nil {
+		err := &Error{Code: AccessViolation, Message: "read not allowed"}
+		t.abort(err)
+		return err
+	}
+	w := &response{t: t, oack: oack, netascii: r.Mode == Netascii, size: -1, skip: r.Offset, max: s.MaxFileSize}
+	if err := handlerError(t, serveRecover(t.log, s.handler(), w, r)); err != nil {
+		if !w.failed() {
+			t.abort(err)
+		}
+		return err
+	}
+	return w.close()
+}
+
+// serveWrite serves a WRQ
+func (s *Server) serveWrite(t *transfer, r *Request, oack map[option]int) error {
+	if s.Handler == nil && s.WriteHandler == nil && s.Backend == nil {
+		err := &Error{Code: AccessViolation, Message: "write not allowed"}
+		t.abort(err)
+		return err
+	}
+	ack := newACKPacket(0)
+	if len(oack) > 0 || len(t.extra) > 0 {
+		ack = t.oackPacket(oack)
+		t.apply(oack)
+	}
+	if n, ok := oack[tsize]; ok {
+		t.size = int64(n)
+	}
+	// the request is acknowledged when the handler first reads the body
+	rcv := newReceiver(t, ack)
+	r.Body = rcv
+	if r.Mode == Netascii {
+		r.Body = newNetasciiDecoder(rcv, s.Newline)
+	}
+	err := handlerError(t, serveRecover(t.log, s.handler(), refusedResponse{}, r))
+	if err == nil {
+		// the rest of the file is accepted but unused
+		_, err = io.Copy(io.Discard, r.Body)
+	}
+	if err != nil {
+		if rcv.err == nil {
+			t.abort(err)
+		}
+		return err
+	}
+	return rcv.Close()
+}
+
+// serveRecover serves r with h, recovering from a panic of h, which is
+// logged to log with the stack, as ErrHandlerPanic
+func serveRecover(log *slog.Logger, h Handler, w ResponseWriter, r *Request) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Error("handler panic", "panic", v, "stack", string(debug.Stack()))
+			err = ErrHandlerPanic
+		}
+	}()
+	return h.ServeTFTP(w, r)
+}
+
+// handlerError returns the error a handler aborted the transfer of t
+// with, if any, or err returned by the handler
+func handlerError(t *transfer, err error) error {
+	if cause := context.Cause(t.ctx); cause != t.ctx.Err() {
+		return cause
+	}
+	return err
+}
+
+// handler returns the Handler of s, adapting ReadHandler, WriteHandler and
+// Backend if it is nil
+func (s *Server) handler() Handler {
+	if s.Handler != nil {
+		return s.Handler
+	}
+	return HandlerFunc(s.serveFuncs)
+}
+
+// serveFuncs serves a request with ReadHandler or WriteHandler, or with
+// Backend if the function is nil
+func (s *Server) serveFuncs(w ResponseWriter, r *Request) error {
+	if r.Op == RRQ && s.ReadHandler == nil || r.Op == WRQ && s.WriteHandler == nil {
+		return BackendHandler(s.Backend).ServeTFTP(w, r)
+	}
+	if r.Op == WRQ {
+		wc, err := s.WriteHandler(r.Context(), r.Filename, r.Mode)
+		if err != nil {
+			return err
+		}
+		if r.Size >= 0 {
+			if ts, ok := wc.(TransferSizer); ok {
+				if err := ts.SetTransferSize(r.Size); err != nil {
+					wc.Close()
+					return err
+				}
+			}
+		}
+		_, err = io.Copy(wc, r.Body)
+		if cerr := wc.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	rc, err := s.ReadHandler(r.Context(), r.Filename, r.Mode)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if n, ok := transferSize(rc); ok {
+		w.SetSize(n)
+	}
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// response is the ResponseWriter for a RRQ, it acknowledges the request
+// and starts sending the file on the first Write
+type response struct {
+	t        *transfer
+	oack     map[option]int
+	netascii bool
+	enc      netasciiEncoder
+	size     int64   // announced file size, -1 if unknown
+	skip     int64   // file data before the offset resumed from left to skip
+	max      int64   // largest file sent, unlimited if zero
+	written  int64   // file data written by the handler
+	w        *sender // nil until the request is acknowledged
+	buf      []byte  // netascii encoding buffer
+	err      error   // the transfer failed
+}
+
+// SetSize sets the file size for the tsize option
+func (r *response) SetSize(size int64) {
+	if r.w == nil {
+		r.size = size
+	}
+}
+
+// Write sends file data
+func (r *response) Write(p []byte) (n int, err error) {
+	if err := r.ready(); err != nil {
+		return 0, err
+	}
+	if err := r.limit(int64(len(p))); err != nil {
+		return 0, err
+	}
+	data := p
+	if r.netascii {
+		r.buf = r.enc.append(r.buf[:0], p)
+		data = r.buf
+	} else if r.skip > 0 {
+		n := min(int64(len(data)), r.skip)
+		data, r.skip = data[n:], r.skip-n
+	}
+	if _, r.err = r.w.Write(data); r.err != nil {
+		return 0, r.err
+	}
+	return len(p), nil
+}
+
+// ReadFrom sends the file data read from src. The blocks of an
+// io.ReaderAt in octet mode are read again when sent again, rather than
+// kept in memory until acknowledged, reading from the current offset of
+// an io.Seeker or else from the start.
+func (r *response) ReadFrom(src io.Reader) (int64, error) {
+	ra, ok := src.(io.ReaderAt)
+	if !ok || r.netascii || r.w != nil && len(r.w.data) > 0 {
+		return io.Copy(writerOnly{r}, src)
+	}
+	seeker, _ := src.(io.Seeker)
+	var off int64
+	if seeker != nil {
+		var err error
+		if off, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+	start := off
+	if r.skip > 0 {
+		// the data before the offset is not read
+		var b [1]byte
+		if n, _ := ra.ReadAt(b[:], off+r.skip-1); n == 0 {
+			return 0, errOffset
+		}
+		off, r.skip = off+r.skip, 0
+	}
+	if r.max > 0 {
+		// the file data already written counts towards the limit
+		ra = limitedReaderAt{ra, start - r.written + r.max}
+	}
+	if err := r.ready(); err != nil {
+		return 0, err
+	}
+	n, err := r.w.readFrom(ra, off)
+	r.written += off + n - start
+	if r.w.err != nil {
+		r.err = r.w.err
+		return n, r.err
+	}
+	if err == nil && seeker != nil {
+		_, err = seeker.Seek(off+n, io.SeekStart)
+	}
+	return n, err
+}
+
+// errFileSize is the error of a RRQ for a file larger than MaxFileSize
+var errFileSize = &Error{Code: AccessViolation, Message: "file exceeds the size limit"}
+
+// limit records n more bytes of file data written by the handler,
+// aborting the transfer if the file exceeds the size limit
+func (r *response) limit(n int64) error {
+	r.written += n
+	if r.max > 0 && r.written > r.max {
+		r.err = errFileSize
+		r.t.abort(r.err)
+	}
+	return r.err
+}
+
+// limitedReaderAt is an io.ReaderAt failing with errFileSize for file
+// data at or beyond end
+type limitedReaderAt struct {
+	io.ReaderAt
+	end int64
+}
+
+// ReadAt reads the file data at off, failing if any is beyond end
+func (l limitedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := l.ReaderAt.ReadAt(p, off)
+	if off+int64(n) > l.end {
+		return 0, errFileSize
+	}
+	return n, err
+}
+
+// writerOnly hides the ReadFrom method of a writer from io.Copy
+type writerOnly struct {
+	io.Writer
+}
+
+// ready acknowledges the request on the first write, it returns the
+// error of the transfer
+func (r *response) ready() error {
+	if r.err == nil && r.t.ctx.Err() != nil {
+		// aborted, without sending more data
+		r.err = r.t.cancel(context.Cause(r.t.ctx))
+	}
+	if r.w == nil && r.err == nil {
+		r.start()
+	}
+	return r.err
+}
+
+// start acknowledges the request
+func (r *response) start() {
+	if r.max > 0 && r.size > r.max {
+		r.err = errFileSize
+		r.t.abort(r.err)
+		return
+	}
+	// the size of netascii is not known until it is converted
+	if r.netascii {
+		r.size = -1
+	}
+	r.t.size = r.size
+	if _, ok := r.oack[tsize]; ok {
+		if r.size >= 0 {
+			r.oack[tsize] = int(r.size)
+		} else {
+			delete(r.oack, tsize)
+		}
+	}
+	if len(r.oack) > 0 || len(r.t.extra) > 0 {
+		r.t.apply(r.oack)
+		r.t.negotiateStart()
+		_, r.err = r.t.exchange(r.t.oackPacket(r.oack), func(p packet) bool {
+			return p.opcode() == ACK && p.block() == 0
+		})
+		r.t.negotiateDone(r.err)
+	}
+	r.w = newSender(r.t)
+}
+
+// errOffset is the error of a download resumed from beyond the end of the
+// file
+var errOffset = errors.New("tftp: offset beyond the end of the file")
+
+// close sends the rest of the file, completing the transfer
+func (r *response) close() error {
+	if r.skip > 0 && r.err == nil {
+		r.err = errOffset
+		r.t.abort(r.err)
+		return r.err
+	}
+	if r.w == nil && r.err == nil {
+		r.start()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if r.netascii {
+		// a CR held back at the end of the file
+		if _, r.err = r.w.Write(r.enc.flush(r.buf[:0])); r.err != nil {
+			return r.err
+		}
+	}
+	r.err = r.w.Close()
+	return r.err
+}
+
+// failed reports whether the transfer with the client failed, so that it
+// must not be aborted
+func (r *response) failed() bool {
+	return r.err != nil
+}
+
+// refusedResponse is the ResponseWriter for a WRQ
+type refusedResponse struct{}
+
+// Write fails
+func (refusedResponse) Write(p []byte) (int, error) {
+	return 0, errors.New("tftp: write to the response of a WRQ")
+}
+
+// SetSize does nothing
+func (refusedResponse) SetSize(int64) {}
+
+// TransferSizer is implemented by writers returned from a WriteHandler that
+// accept the transfer size announced by the client with the tsize option,
+// for example to check quota or preallocate space. Returning an error
+// refuses the transfer.
+type TransferSizer interface {
+	SetTransferSize(size int64) error
+}
+
+// transferSize returns the number of bytes remaining in r, if known
+func transferSize(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size(), true
+		}
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+// errNoPort is returned when all ports from MinPort to MaxPort are in use
+var errNoPort = errors.New("tftp: no free port in range")
+
+// listen listens on a UDP port for a transfer on the host of local, from
+// MinPort to MaxPort or an ephemeral port. The conn is connected to peer
+// unless peer is nil, the kernel then dropping datagrams from other
+// addresses and reporting ICMP errors for peer on reads.
+func (s *Server) listen(local, peer net.Addr) (net.PacketConn, error) {
+	addr, ok := local.(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("tftp: not a UDP address")
+	}
+	raddr, ok := peer.(*net.UDPAddr)
+	if !ok && peer != nil {
+		return nil, errors.New("tftp: not a UDP address")
+	}
+	conn, err := s.listenPort(addr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	if s.DSCP != 0 {
+		if err := setDSCP(conn, s.DSCP); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.DontFragment {
+		if err := setDontFragment(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return capture(conn, s.Capture), nil
+}
+
+// listenPort listens on a port from MinPort to MaxPort, or an ephemeral
+// port, on the host of addr, dialing peer if not nil
+func (s *Server) listenPort(addr, peer *net.UDPAddr) (*net.UDPConn, error) {
+	listen := func(port int) (*net.UDPConn, error) {
+		laddr := &net.UDPAddr{IP: addr.IP, Port: port, Zone: addr.Zone}
+		if peer != nil {
+			return net.DialUDP("udp", laddr, peer)
+		}
+		return net.ListenUDP("udp", laddr)
+	}
+	if s.MinPort <= 0 || s.MaxPort < s.MinPort {
+		return listen(0)
+	}
+	// start at a random port to spread transfers over the range
+	n := s.MaxPort - s.MinPort + 1
+	start := rand.IntN(n)
+	for i := range n {
+		port := s.MinPort + (start+i)%n
+		conn, err := listen(port)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, errNoPort
+}
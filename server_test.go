@@ -0,0 +1,933 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nopWriteCloser adds a no-op Close to a bytes.Buffer
+type nopWriteCloser struct {
+	*bytes.Buffer
+	closed chan struct{}
+}
+
+func (w nopWriteCloser) Close() error {
+	close(w.closed)
+	return nil
+}
+
+// startServer starts s on a loopback port and returns its address
+func startServer(t *testing.T, s *Server) net.Addr {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go s.Serve(conn)
+	return conn.LocalAddr()
+}
+
+// dialServer returns a client socket for talking to a server
+func dialServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestServerRead(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64) // exactly two blocks
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			if filename != "test" {
+				return nil, errors.New("not found")
+			}
+			return io.NopCloser(bytes.NewReader(content)), nil
+		},
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("test", Octet, nil), addr)
+
+	var got []byte
+	buf := make([]byte, 1024)
+	for b := block(1); ; b++ {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := packet(buf[:n])
+		if p.opcode() != DATA || p.block() != b {
+			t.Fatalf("got %v block %d, want DATA block %d", p.opcode(), p.block(), b)
+		}
+		got = append(got, p.data()...)
+		conn.WriteTo(newACKPacket(b), peer)
+		if len(p.data()) < defaultBlockSize {
+			break
+		}
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %d bytes, want %d", len(got), len(content))
+	}
+}
+
+func TestServerReadError(t *testing.T) {
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			return nil, errors.New("not found")
+		},
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("missing", Octet, nil), addr)
+
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := packet(buf[:n])
+	if p.opcode() != ERROR || p.errorMessage() != "not found" {
+		t.Errorf("got %v %q, want ERROR", p.opcode(), p.errorMessage())
+	}
+}
+
+func TestServerMalformed(t *testing.T) {
+	m := &MemFS{}
+	m.Set("file", make([]byte, 1000))
+	completed := make(chan Stats, 1)
+	addr := startServer(t, &Server{Handler: m, OnComplete: func(st Stats) { completed <- st }})
+	for _, req := range []string{
+		"\x00\x01file",
+		"\x00\x01file\x00binary\x00",
+		"\x00\x01file\x00octet\x00blksize\x00large\x00",
+		"\x00\x09",
+		"\x00\x01fi\x1ble\x00octet\x00",
+		"\x00\x01file\x00octet\x00x-tag\x00a\tb\x00",
+		"\x00\x01" + strings.Repeat("f", 256) + "\x00octet\x00",
+		"\x00\x01file\x00octet\x00x-pad\x00" + strings.Repeat("x", 500) + "\x00",
+	} {
+		conn := dialServer(t)
+		conn.WriteTo([]byte(req), addr)
+		buf := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := packet(buf[:n]); p.opcode() != ERROR || p.errorCode() != IllegalOperation {
+			t.Errorf("%q: got %v %v %q, want IllegalOperation", req, p.opcode(), p.errorCode(), p.errorMessage())
+		}
+	}
+
+	// a truncated ACK ends the transfer
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	_, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteTo([]byte{0, byte(ACK), 0}, peer)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != ERROR || p.errorCode() != IllegalOperation {
+		t.Errorf("truncated ACK: got %v %v, want IllegalOperation", p.opcode(), p.errorCode())
+	}
+	if st := <-completed; !errors.Is(st.Err, ErrMalformedPacket) {
+		t.Errorf("got %v, want ErrMalformedPacket", st.Err)
+	}
+
+	// the limits are raised
+	long := strings.Repeat("f", 300)
+	m.Set(long, []byte("data"))
+	addr = startServer(t, &Server{Handler: m, MaxRequest: 1024, MaxFilename: 300})
+	c := &Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), addr.String(), long, io.Discard); err != nil {
+		t.Errorf("long filename: %v", err)
+	}
+}
+
+func TestServerWrite(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 700)
+	w := nopWriteCloser{&bytes.Buffer{}, make(chan struct{})}
+	s := &Server{
+		WriteHandler: func(ctx context.Context, filename string, mode Mode) (io.WriteCloser, error) {
+			return w, nil
+		},
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newWRQPacket("test", Octet, nil), addr)
+
+	buf := make([]byte, 1024)
+	for b := block(0); ; b++ {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := packet(buf[:n])
+		if p.opcode() != ACK || p.block() != b {
+			t.Fatalf("got %v block %d, want ACK block %d", p.opcode(), p.block(), b)
+		}
+		if int(b)*defaultBlockSize > len(content) {
+			break
+		}
+		data := content[int(b)*defaultBlockSize:]
+		if len(data) > defaultBlockSize {
+			data = data[:defaultBlockSize]
+		}
+		conn.WriteTo(newDATAPacket(b+1, data), peer)
+	}
+	<-w.closed
+	if !bytes.Equal(w.Bytes(), content) {
+		t.Errorf("got %d bytes, want %d", w.Len(), len(content))
+	}
+}
+
+func TestServerNegotiateBlockSize(t *testing.T) {
+	s := &Server{MaxBlockSize: 1468}
+	for _, test := range []struct{ requested, want int }{
+		{4, 0},
+		{8, 8},
+		{1024, 1024},
+		{65464, 1468},
+	} {
+		oack := s.negotiate(map[option]int{blksize: test.requested})
+		if oack[blksize] != test.want {
+			t.Errorf("blksize %d: got %d, want %d", test.requested, oack[blksize], test.want)
+		}
+	}
+}
+
+func TestServerReadWindow(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10) // 12 full blocks of 8 and 4 bytes
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		},
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("test", Octet, map[option]int{blksize: 8, windowsize: 4}), addr)
+
+	buf := make([]byte, 1024)
+	n, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != OACK {
+		t.Fatalf("got %v, want OACK", p.opcode())
+	}
+	conn.WriteTo(newACKPacket(0), peer)
+
+	var got []byte
+	var last block
+	var received int
+	dropped := false
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := packet(buf[:n])
+		if p.opcode() != DATA {
+			t.Fatalf("got %v, want DATA", p.opcode())
+		}
+		if p.block() == 3 && !dropped {
+			dropped = true
+			continue
+		}
+		if p.block() != last+1 {
+			// out of order, acknowledge the last block received in order
+			conn.WriteTo(newACKPacket(last), peer)
+			received = 0
+			continue
+		}
+		last = p.block()
+		got = append(got, p.data()...)
+		if received++; received == 4 || len(p.data()) < 8 {
+			conn.WriteTo(newACKPacket(last), peer)
+			received = 0
+		}
+		if len(p.data()) < 8 {
+			break
+		}
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %q, want %q", got, content)
+	}
+}
+
+// nopReadCloser adds a no-op Close to a bytes.Reader, keeping its size
+// visible
+type nopReadCloser struct {
+	*bytes.Reader
+}
+
+func (nopReadCloser) Close() error {
+	return nil
+}
+
+// sizedWriter records the announced transfer size
+type sizedWriter struct {
+	nopWriteCloser
+	size  int64
+	quota int64
+}
+
+func (w *sizedWriter) SetTransferSize(size int64) error {
+	w.size = size
+	if size > w.quota {
+		return errors.New("quota exceeded")
+	}
+	return nil
+}
+
+func TestServerTransferSize(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 1000)
+	writers := make(chan *sizedWriter, 1)
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			return nopReadCloser{bytes.NewReader(content)}, nil
+		},
+		WriteHandler: func(ctx context.Context, filename string, mode Mode) (io.WriteCloser, error) {
+			w := &sizedWriter{nopWriteCloser{&bytes.Buffer{}, make(chan struct{})}, 0, 1000}
+			writers <- w
+			return w, nil
+		},
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("test", Octet, map[option]int{tsize: 0}), addr)
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != OACK || p.options()[tsize] != len(content) {
+		t.Errorf("got %v %v, want OACK with tsize %d", p.opcode(), p.options(), len(content))
+	}
+
+	c := &Client{Timeout: time.Second}
+	if err := c.Put(context.Background(), addr.String(), "test", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	w := <-writers
+	<-w.closed
+	if w.size != int64(len(content)) {
+		t.Errorf("got tsize %d, want %d", w.size, len(content))
+	}
+	err = c.Put(context.Background(), addr.String(), "test", bytes.NewReader(append(content, 'x')))
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("got %v, want quota exceeded", err)
+	}
+	<-(<-writers).closed
+}
+
+func TestServerMaxFileSize(t *testing.T) {
+	m := &MemFS{}
+	m.Set("small", make([]byte, 1000))
+	m.Set("large", make([]byte, 1001))
+	h := HandlerFunc(func(w ResponseWriter, r *Request) error {
+		switch r.Filename {
+		case "stream":
+			// written without a size
+			for range 3 {
+				if _, err := w.Write(make([]byte, 600)); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "readerat":
+			_, err := w.(io.ReaderFrom).ReadFrom(io.NewSectionReader(bytes.NewReader(make([]byte, 1800)), 0, 1800))
+			return err
+		}
+		return m.ServeTFTP(w, r)
+	})
+	addr := startServer(t, &Server{Handler: h, MaxFileSize: 1000})
+	c := &Client{Timeout: time.Second}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr.String(), "small", &got); err != nil || got.Len() != 1000 {
+		t.Errorf("small: got %d bytes, %v", got.Len(), err)
+	}
+	for _, name := range []string{"stream", "readerat"} {
+		var e *Error
+		if err := c.Get(context.Background(), addr.String(), name, io.Discard); !errors.As(err, &e) || e.Code != AccessViolation {
+			t.Errorf("%s: got %v, want AccessViolation", name, err)
+		}
+	}
+
+	// a file of known size is refused before any data is sent
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("large", Octet, map[option]int{tsize: 0}), addr)
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != ERROR || p.errorCode() != AccessViolation {
+		t.Errorf("large: got %v, want ERROR AccessViolation", p.opcode())
+	}
+}
+
+func TestServerNegotiateTimeout(t *testing.T) {
+	s := &Server{MaxTimeout: 30 * time.Second}
+	for _, test := range []struct{ requested, want int }{
+		{0, 0},
+		{1, 1},
+		{30, 30},
+		{31, 0},
+		{256, 0},
+	} {
+		oack := s.negotiate(map[option]int{timeout: test.requested})
+		if oack[timeout] != test.want {
+			t.Errorf("timeout %d: got %d, want %d", test.requested, oack[timeout], test.want)
+		}
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		},
+		Timeout: time.Minute,
+	}
+	addr := startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("test", Octet, map[option]int{timeout: 1}), addr)
+	buf := make([]byte, 1024)
+	if _, _, err := conn.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != OACK {
+		t.Errorf("got %v, want retransmitted OACK", p.opcode())
+	}
+	if d := time.Since(start); d < 900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("retransmitted after %v, want 1s", d)
+	}
+}
+
+func TestServerNegotiateRollover(t *testing.T) {
+	s := &Server{}
+	for _, n := range []int{0, 1} {
+		oack := s.negotiate(map[option]int{rollover: n})
+		if v, ok := oack[rollover]; !ok || v != n {
+			t.Errorf("rollover %d: got %v", n, oack)
+		}
+	}
+}
+
+func TestServerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan context.Context, 1)
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			handled <- ctx
+			return io.NopCloser(bytes.NewReader(make([]byte, 4096))), nil
+		},
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	done := make(chan error, 1)
+	go func() { done <- s.ServeContext(ctx, conn) }()
+
+	// start a transfer and leave it waiting for an ACK
+	client := dialServer(t)
+	client.WriteTo(newRRQPacket("test", Octet, nil), conn.LocalAddr())
+	hctx := <-handled
+	cancel()
+	select {
+	case <-hctx.Done():
+	case <-time.After(time.Second):
+		t.Error("handler context not done")
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want context canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("ServeContext did not return")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	handled := make(chan context.Context, 2)
+	s := &Server{
+		ReadHandler: func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error) {
+			handled <- ctx
+			return io.NopCloser(bytes.NewReader([]byte("data"))), nil
+		},
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(conn) }()
+
+	// two transfers wait for the ACK of their only block
+	a, b := dialServer(t), dialServer(t)
+	buf := make([]byte, 1024)
+	a.WriteTo(newRRQPacket("test", Octet, nil), conn.LocalAddr())
+	_, peer, err := a.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.WriteTo(newRRQPacket("test", Octet, nil), conn.LocalAddr())
+	if _, _, err := b.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	<-handled
+	stalled := <-handled
+
+	shutdown := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { shutdown <- s.Shutdown(ctx) }()
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("Serve: got %v, want ErrServerClosed", err)
+	}
+
+	// the first transfer completes, the second is cancelled
+	a.WriteTo(newACKPacket(1), peer)
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before transfers completed", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	if err := <-shutdown; err != context.Canceled {
+		t.Errorf("Shutdown: got %v, want context canceled", err)
+	}
+	select {
+	case <-stalled.Done():
+	case <-time.After(time.Second):
+		t.Error("stalled transfer not cancelled")
+	}
+	if err := s.Serve(conn); err != ErrServerClosed {
+		t.Errorf("Serve after Shutdown: got %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServerShutdownIdle(t *testing.T) {
+	s := &Server{}
+	startServer(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
+
+func TestServerAuthorize(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = []byte("data")
+	s := files.server()
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	s.Authorize = func(peer net.Addr, op Opcode, filename string, mode Mode) error {
+		if !loopback.Contains(peer.(*net.UDPAddr).IP) {
+			return errors.New("address not allowed")
+		}
+		if op == WRQ {
+			return errors.New("writes not allowed")
+		}
+		if strings.HasSuffix(filename, ".key") {
+			return fs.ErrNotExist
+		}
+		return nil
+	}
+	completed := make(chan Stats, 4)
+	s.OnComplete = func(st Stats) { completed <- st }
+	addr := startServer(t, s)
+	c := &Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Put(context.Background(), addr.String(), "file", strings.NewReader("new"))
+	if err == nil || !strings.Contains(err.Error(), "writes not allowed") {
+		t.Errorf("got %v, want writes not allowed", err)
+	}
+
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("secret.key", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != ERROR || p.errorCode() != FileNotFound {
+		t.Errorf("got %v %v, want FileNotFound", p.opcode(), p.errorCode())
+	}
+	conn.WriteTo(newWRQPacket("file", Octet, nil), addr)
+	if n, _, err = conn.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != ERROR || p.errorCode() != AccessViolation {
+		t.Errorf("got %v %v, want AccessViolation", p.opcode(), p.errorCode())
+	}
+	for i := 0; i < 4; i++ {
+		<-completed
+	}
+	if got := s.Stats(); got.Completed != 1 || got.Failed != 3 || got.Active != 0 {
+		t.Errorf("got %+v, want 1 completed, 3 failed", got)
+	}
+}
+
+func TestServerMaxConcurrentTransfers(t *testing.T) {
+	for _, overflow := range []OverflowPolicy{OverflowQueue, OverflowReject} {
+		files := newMemFiles()
+		files.m["file"] = []byte("data")
+		s := files.server()
+		s.Timeout = 100 * time.Millisecond
+		s.Retries = 1
+		s.MaxConcurrentTransfers = 1
+		s.Overflow = overflow
+		addr := startServer(t, s)
+
+		// the first transfer holds the only slot until it times out
+		conn := dialServer(t)
+		conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+		buf := make([]byte, 1024)
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		c := &Client{Timeout: time.Second}
+		err := c.Get(context.Background(), addr.String(), "file", io.Discard)
+		switch overflow {
+		case OverflowQueue:
+			if err != nil {
+				t.Errorf("queue: got %v", err)
+			}
+			if d := time.Since(start); d < 100*time.Millisecond {
+				t.Errorf("queue: served after %v, want after the first transfer", d)
+			}
+		case OverflowReject:
+			if err == nil || !strings.Contains(err.Error(), "server busy") {
+				t.Errorf("reject: got %v, want server busy", err)
+			}
+		}
+	}
+}
+
+func TestServerHandshakeIdleTimeout(t *testing.T) {
+	completed := make(chan Stats, 1)
+	m := &MemFS{}
+	m.Set("file", make([]byte, 2000))
+	s := &Server{
+		Handler:          m,
+		Timeout:          50 * time.Millisecond,
+		Retries:          100,
+		HandshakeTimeout: 300 * time.Millisecond,
+		IdleTimeout:      300 * time.Millisecond,
+		OnComplete:       func(st Stats) { completed <- st },
+	}
+	addr := startServer(t, s)
+	wait := func(want string) {
+		t.Helper()
+		select {
+		case st := <-completed:
+			if !errors.Is(st.Err, ErrTimeout) || !strings.Contains(st.Err.Error(), want) {
+				t.Errorf("got %v, want %s", st.Err, want)
+			}
+			if st.Duration > time.Second {
+				t.Errorf("failed after %v", st.Duration)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("transfer not failed, want %s", want)
+		}
+	}
+
+	// the first block is never acknowledged
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	wait("no reply")
+
+	// the transfer stalls after the first block
+	conn = dialServer(t)
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	_, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteTo(newACKPacket(1), peer)
+	wait("no progress")
+}
+
+func TestServerWorkers(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = []byte("data")
+	s := files.server()
+	s.Timeout = 100 * time.Millisecond
+	s.Retries = 1
+	s.Workers = 1
+	s.QueueSize = 1
+	s.Overflow = OverflowReject
+	addr := startServer(t, s)
+
+	// the first transfer holds the only worker until it times out
+	first := dialServer(t)
+	first.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	if _, _, err := first.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	// the second request waits in the queue, the third is refused
+	queued := dialServer(t)
+	queued.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	time.Sleep(20 * time.Millisecond)
+	c := &Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err == nil || !strings.Contains(err.Error(), "server busy") {
+		t.Errorf("full queue: got %v, want server busy", err)
+	}
+	n, _, err := queued.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != DATA || string(p.data()) != "data" {
+		t.Errorf("queued request: got %v %q, want DATA", p.opcode(), p.data())
+	}
+}
+
+func TestServerPortRange(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = []byte("data")
+	s := files.server()
+	// a range of a single port, busy at first
+	busy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.LocalAddr().(*net.UDPAddr).Port
+	s.MinPort, s.MaxPort = port, port
+	addr := startServer(t, s)
+
+	conn := dialServer(t)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	if _, _, err := conn.ReadFrom(make([]byte, 1024)); !isTimeout(err) {
+		t.Errorf("range in use: got %v, want timeout", err)
+	}
+
+	busy.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	_, from, err := conn.ReadFrom(make([]byte, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := from.(*net.UDPAddr).Port; got != port {
+		t.Errorf("got port %d, want %d", got, port)
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	old := HandlerFunc(func(w ResponseWriter, r *Request) error {
+		if _, err := w.Write(bytes.Repeat([]byte("old "), 200)); err != nil {
+			return err
+		}
+		close(started)
+		<-release
+		_, err := w.Write([]byte("end"))
+		return err
+	})
+	var completed atomic.Int32
+	s := &Server{Handler: old, OnComplete: func(Stats) { completed.Add(1) }}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second}
+
+	var inFlight bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- c.Get(context.Background(), addr, "file", &inFlight) }()
+	<-started
+
+	m := &MemFS{}
+	m.Set("file", []byte("new"))
+	s.Reload(&Server{Handler: m, MaxBlockSize: 512})
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr, "file", &got); err != nil || got.String() != "new" {
+		t.Errorf("after reload: got %q, %v", got.String(), err)
+	}
+	// the transfer in progress completes with the old handler
+	close(release)
+	if err := <-done; err != nil || !strings.HasSuffix(inFlight.String(), "old end") {
+		t.Errorf("in progress: got %d bytes, %v", inFlight.Len(), err)
+	}
+	// the hooks of s are kept across Reload
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := completed.Load(); n != 2 {
+		t.Errorf("OnComplete called %d times, want twice", n)
+	}
+}
+
+func TestServerReloadDrained(t *testing.T) {
+	m := &MemFS{}
+	m.Set("file", []byte("data"))
+	authorizing := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: m, Authorize: func(net.Addr, Opcode, string, Mode) error {
+		close(authorizing)
+		<-release
+		return nil
+	}}
+	addr := startServer(t, s).String()
+	done := make(chan error, 1)
+	go func() {
+		c := &Client{Timeout: time.Second}
+		done <- c.Get(context.Background(), addr, "file", io.Discard)
+	}()
+	// a request received but not yet handled keeps its configuration
+	<-authorizing
+	drained := s.Reload(&Server{Handler: m})
+	select {
+	case <-drained:
+		t.Fatal("drained with a request in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not drained once the request is served")
+	}
+	select {
+	case <-s.Reload(nil):
+	default:
+		t.Error("not drained without requests")
+	}
+}
+
+func TestServerTransferHooks(t *testing.T) {
+	m := &MemFS{}
+	m.Set("image", []byte("data"))
+	events := make(chan string, 8)
+	s := &Server{
+		Handler: m,
+		Authorize: func(peer net.Addr, op Opcode, filename string, mode Mode) error {
+			if filename == "secret" {
+				return errors.New("denied")
+			}
+			return nil
+		},
+		OnTransferStart: func(r *Request) {
+			events <- "start " + r.Filename + " " + r.Options["blksize"]
+		},
+		OnTransferComplete: func(r *Request, st Stats) {
+			if st.Start.IsZero() || st.Peer.String() != r.RemoteAddr.String() {
+				t.Errorf("complete: got %+v", st)
+			}
+			events <- "complete " + r.Filename
+		},
+		OnTransferError: func(r *Request, st Stats) {
+			events <- "error " + r.Filename
+		},
+	}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second, BlockSize: 1024}
+	ctx := context.Background()
+	var got []string
+	for _, name := range []string{"image", "missing"} {
+		c.Get(ctx, addr, name, io.Discard)
+		// the server ends the transfer after the client does
+		got = append(got, <-events, <-events)
+	}
+	c.Get(ctx, addr, "secret", io.Discard)
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+	for e := range events {
+		got = append(got, e)
+	}
+	// refused requests start no transfer
+	want := []string{"start image 1024", "complete image", "start missing 1024", "error missing"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServerHealthy(t *testing.T) {
+	unblock := make(chan struct{})
+	listening := make(chan net.Addr, 1)
+	s := &Server{
+		Handler:   &MemFS{},
+		RateLimit: &RateLimit{Requests: 0.001},
+		OnListen:  func(addr net.Addr) { listening <- addr },
+		Audit: func(a AuditRecord) {
+			if a.Result == AuditDropped {
+				// wedges the loop receiving requests
+				<-unblock
+			}
+		},
+	}
+	if s.Healthy(time.Second) {
+		t.Error("healthy before serving")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(conn) }()
+	if addr := <-listening; addr.String() != conn.LocalAddr().String() {
+		t.Errorf("listening on %v, want %v", addr, conn.LocalAddr())
+	}
+	if !s.Healthy(50 * time.Millisecond) {
+		t.Error("unhealthy while waiting for requests")
+	}
+
+	c := &Client{Timeout: 100 * time.Millisecond, Retries: 1}
+	c.Get(context.Background(), conn.LocalAddr().String(), "missing", io.Discard)
+	// the second request is dropped by the rate limit
+	go c.Get(context.Background(), conn.LocalAddr().String(), "missing", io.Discard)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Healthy(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("healthy while wedged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(unblock)
+	for !s.Healthy(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("unhealthy once unwedged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Close()
+	<-done
+	if s.Healthy(time.Second) {
+		t.Error("healthy once closed")
+	}
+}
@@ -0,0 +1,75 @@
+package tftp
+
+import (
+	"net"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Session is a transfer in progress of a server
+type Session struct {
+	Op          Opcode    // RRQ to send the file to the peer, WRQ to receive it
+	Filename    string    // requested file
+	Peer        net.Addr  // address of the client
+	Start       time.Time // time of the request
+	Transferred int64     // file data transferred so far
+	Size        int64     // file size, -1 if unknown
+
+	r *Request
+}
+
+// Abort aborts the transfer with an ERROR packet of code and message, as
+// Request.Abort. It does nothing once the transfer has ended.
+func (ss *Session) Abort(code ErrorCode, message string) {
+	if ss.r != nil {
+		ss.r.Abort(&Error{Code: code, Message: message})
+	}
+}
+
+// session tracks the progress of a transfer for Sessions
+type session struct {
+	r           *Request
+	start       time.Time
+	transferred atomic.Int64
+	size        atomic.Int64
+}
+
+// Sessions returns the unicast transfers in progress, oldest first
+func (s *Server) Sessions() []Session {
+	s.mu.Lock()
+	sessions := make([]Session, 0, len(s.sessions))
+	for ss := range s.sessions {
+		sessions = append(sessions, Session{
+			Op:          ss.r.Op,
+			Filename:    ss.r.Filename,
+			Peer:        ss.r.RemoteAddr,
+			Start:       ss.start,
+			Transferred: ss.transferred.Load(),
+			Size:        ss.size.Load(),
+			r:           ss.r,
+		})
+	}
+	s.mu.Unlock()
+	slices.SortFunc(sessions, func(a, b Session) int { return a.Start.Compare(b.Start) })
+	return sessions
+}
+
+// trackSession adds the transfer t of r to the sessions, returning the
+// function removing it
+func (s *Server) trackSession(t *transfer, r *Request) func() {
+	ss := &session{r: r, start: t.start}
+	ss.size.Store(-1)
+	t.session = ss
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[*session]struct{})
+	}
+	s.sessions[ss] = struct{}{}
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		delete(s.sessions, ss)
+		s.mu.Unlock()
+	}
+}
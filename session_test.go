@@ -0,0 +1,90 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// waitSessions waits until the sessions of s satisfy ok, returning them
+func waitSessions(t *testing.T, s *Server, ok func([]Session) bool) []Session {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sessions := s.Sessions()
+		if ok(sessions) {
+			return sessions
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got sessions %+v", sessions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerSessions(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+		w.SetSize(2000)
+		if _, err := w.Write(make([]byte, 1000)); err != nil {
+			return err
+		}
+		<-release
+		_, err := w.Write(make([]byte, 1000))
+		return err
+	})}
+	addr := startServer(t, s)
+	if sessions := s.Sessions(); len(sessions) != 0 {
+		t.Errorf("got sessions %+v before any request", sessions)
+	}
+
+	before := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		c := &Client{Timeout: time.Second}
+		done <- c.Get(context.Background(), addr.String(), "file", io.Discard)
+	}()
+	sessions := waitSessions(t, s, func(sessions []Session) bool {
+		return len(sessions) == 1 && sessions[0].Transferred >= 512
+	})
+	ss := sessions[0]
+	if ss.Op != RRQ || ss.Filename != "file" || ss.Peer == nil || ss.Size != 2000 || ss.Transferred > 1000 || ss.Start.Before(before.Add(-time.Second)) {
+		t.Errorf("got session %+v", ss)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	waitSessions(t, s, func(sessions []Session) bool { return len(sessions) == 0 })
+}
+
+func TestSessionAbort(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+		if _, err := w.Write(make([]byte, 1000)); err != nil {
+			return err
+		}
+		<-r.Context().Done()
+		_, err := w.Write(make([]byte, 1000))
+		return err
+	})}
+	addr := startServer(t, s)
+	done := make(chan error, 1)
+	go func() {
+		c := &Client{Timeout: time.Second}
+		done <- c.Get(context.Background(), addr.String(), "file", io.Discard)
+	}()
+	sessions := waitSessions(t, s, func(sessions []Session) bool {
+		return len(sessions) == 1 && sessions[0].Transferred >= 512
+	})
+	sessions[0].Abort(AccessViolation, "revoked")
+	var e *Error
+	if err := <-done; !errors.As(err, &e) || e.Code != AccessViolation || e.Message != "revoked" {
+		t.Errorf("got %v, want AccessViolation revoked", err)
+	}
+	waitSessions(t, s, func(sessions []Session) bool { return len(sessions) == 0 })
+	// aborting an ended transfer does nothing
+	sessions[0].Abort(AccessViolation, "revoked")
+}
@@ -0,0 +1,151 @@
+package tftp
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// demux routes the packets received on a listening conn to the transfers
+// sharing it, by peer address
+type demux struct {
+	conn  net.PacketConn
+	clock Clock // clock of the read deadlines of the transfers
+
+	mu       sync.Mutex
+	sessions map[string]*sessionConn
+}
+
+// newDemux returns a demux for conn, with read deadlines of clock, the
+// system clock if nil
+func newDemux(conn net.PacketConn, clock Clock) *demux {
+	return &demux{conn: conn, clock: orSystem(clock), sessions: make(map[string]*sessionConn)}
+}
+
+// deliver passes p to the transfer with peer, it returns false if there
+// is none
+func (d *demux) deliver(peer net.Addr, p []byte) bool {
+	d.mu.Lock()
+	c, ok := d.sessions[peer.String()]
+	d.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case c.packets <- p:
+	default:
+		// dropped like a full socket buffer would
+	}
+	return true
+}
+
+// open returns the conn of a new transfer with peer
+func (d *demux) open(peer net.Addr) *sessionConn {
+	c := &sessionConn{
+		d:       d,
+		peer:    peer,
+		packets: make(chan []byte, 64),
+		closed:  make(chan struct{}),
+	}
+	d.mu.Lock()
+	d.sessions[peer.String()] = c
+	d.mu.Unlock()
+	return c
+}
+
+// sessionConn is the net.PacketConn of a transfer on the listening conn,
+// it receives the packets from the peer of the transfer only
+type sessionConn struct {
+	d       *demux
+	peer    net.Addr
+	packets chan []byte
+	closed  chan struct{}
+	once    sync.Once
+
+	mu       sync.Mutex
+	deadline chan struct{} // closed when the read deadline passes, nil if none
+	timer    Timer
+}
+
+// ReadFrom reads the next packet from the peer
+func (c *sessionConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	select {
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-deadline:
+		return 0, nil, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case b := <-c.packets:
+		return copy(p, b), c.peer, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-deadline:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends p from the listening conn
+func (c *sessionConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.d.conn.WriteTo(p, addr)
+}
+
+// Close stops routing packets to the transfer
+func (c *sessionConn) Close() error {
+	c.once.Do(func() {
+		c.d.mu.Lock()
+		if c.d.sessions[c.peer.String()] == c {
+			delete(c.d.sessions, c.peer.String())
+		}
+		c.d.mu.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
+
+// LocalAddr returns the address of the listening conn
+func (c *sessionConn) LocalAddr() net.Addr {
+	return c.d.conn.LocalAddr()
+}
+
+// SetDeadline sets the read deadline, writes do not block
+func (c *sessionConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *sessionConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if t.IsZero() {
+		c.deadline = nil
+		return nil
+	}
+	deadline := make(chan struct{})
+	c.deadline = deadline
+	if d := t.Sub(c.d.clock.Now()); d > 0 {
+		c.timer = c.d.clock.AfterFunc(d, func() { close(deadline) })
+	} else {
+		close(deadline)
+	}
+	return nil
+}
+
+// SetWriteDeadline has no effect, writes do not block
+func (c *sessionConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestServerSinglePort(t *testing.T) {
+	s := &Server{Handler: &MemFS{Writable: true}, SinglePort: true}
+	addr := startServer(t, s)
+
+	// concurrent transfers share the listening port
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	errs := make(chan error, 4)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		go func() {
+			c := &Client{Timeout: time.Second, BlockSize: 1024, WindowSize: 2}
+			if err := c.Put(context.Background(), addr.String(), name, bytes.NewReader(content)); err != nil {
+				errs <- err
+				return
+			}
+			var got bytes.Buffer
+			err := c.Get(context.Background(), addr.String(), name, &got)
+			if err == nil && !bytes.Equal(got.Bytes(), content) {
+				t.Errorf("%s: got %d bytes, want %d", name, got.Len(), len(content))
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("a", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != DATA || from.String() != addr.String() {
+		t.Errorf("got %v from %v, want DATA from %v", p.opcode(), from, addr)
+	}
+
+	// Shutdown waits for the transfer in progress, which still receives
+	// its ACKs
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	for b := block(1); ; b++ {
+		conn.WriteTo(newACKPacket(b), from)
+		if len(packet(buf[:n]).data()) < defaultBlockSize {
+			break
+		}
+		if n, _, err = conn.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown got %v", err)
+	}
+}
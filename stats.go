@@ -0,0 +1,87 @@
+package tftp
+
+import (
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Stats describes a completed unicast transfer
+type Stats struct {
+	Op          Opcode        // RRQ or WRQ
+	Filename    string        // requested file
+	Peer        net.Addr      // address of the peer
+	Start       time.Time     // time of the request
+	Bytes       int64         // file data transferred
+	Duration    time.Duration // time from request to completion
+	Retransmits int           // timeouts and retransmissions of packets or windows
+	BlockSize   int           // negotiated block size
+	WindowSize  int           // negotiated window size
+	Err         error         // nil if the transfer completed
+}
+
+// ServerStats are totals over the transfers of a server
+type ServerStats struct {
+	Active      int64 // transfers in progress
+	Completed   int64 // transfers completed successfully
+	Failed      int64 // transfers failed
+	Bytes       int64 // file data transferred
+	Retransmits int64 // timeouts and retransmissions
+}
+
+// logStats logs a completed transfer
+func logStats(log *slog.Logger, st Stats) {
+	attrs := []any{
+		"bytes", st.Bytes,
+		"duration", st.Duration,
+		"retransmits", st.Retransmits,
+	}
+	if st.Err != nil {
+		log.Warn("transfer failed", append(attrs, "err", st.Err)...)
+		return
+	}
+	log.Info("transfer complete", attrs...)
+}
+
+// stats returns the statistics of the transfer
+func (t *transfer) stats(op Opcode, filename string, err error) Stats {
+	return Stats{
+		Op:          op,
+		Filename:    filename,
+		Peer:        t.peer,
+		Start:       t.start,
+		Bytes:       t.transferred,
+		Duration:    t.now().Sub(t.start),
+		Retransmits: t.retransmits,
+		BlockSize:   t.blksize,
+		WindowSize:  t.window,
+		Err:         err,
+	}
+}
+
+// Stats returns a snapshot of the totals over the transfers served
+func (s *Server) Stats() ServerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// record adds a completed transfer to the totals, reporting it to
+// OnComplete
+func (s *Server) record(t *transfer, st Stats) {
+	logStats(t.log, st)
+	t.done(st)
+	s.mu.Lock()
+	s.stats.Active--
+	if st.Err == nil {
+		s.stats.Completed++
+	} else {
+		s.stats.Failed++
+	}
+	s.stats.Bytes += st.Bytes
+	s.stats.Retransmits += int64(st.Retransmits)
+	s.mu.Unlock()
+	if s.OnComplete != nil {
+		s.OnComplete(st)
+	}
+}
@@ -0,0 +1,128 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = make([]byte, 3000)
+	s := files.server()
+	completed := make(chan Stats, 1)
+	s.OnComplete = func(st Stats) { completed <- st }
+	addr := startServer(t, s)
+
+	var client Stats
+	c := &Client{Timeout: time.Second, BlockSize: 1024, OnComplete: func(st Stats) { client = st }}
+	if err := c.Get(context.Background(), addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if client.Op != RRQ || client.Filename != "file" || client.Bytes != 3000 || client.BlockSize != 1024 || client.Err != nil {
+		t.Errorf("client got %+v", client)
+	}
+	st := <-completed
+	if st.Op != RRQ || st.Bytes != 3000 || st.BlockSize != 1024 || st.WindowSize != 1 || st.Err != nil || st.Peer == nil {
+		t.Errorf("server got %+v", st)
+	}
+	c.Get(context.Background(), addr.String(), "missing", io.Discard)
+	if st := <-completed; st.Err == nil {
+		t.Errorf("server got %+v, want error", st)
+	}
+
+	want := ServerStats{Completed: 1, Failed: 1, Bytes: 3000}
+	if got := s.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// the first DATA is retransmitted when it is not acknowledged
+	s = files.server()
+	s.Timeout = 50 * time.Millisecond
+	s.OnComplete = func(st Stats) { completed <- st }
+	addr = startServer(t, s)
+	conn := dialServer(t)
+	conn.WriteTo(newRRQPacket("file", Octet, nil), addr)
+	buf := make([]byte, 1024)
+	conn.ReadFrom(buf)
+	n, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for b := block(1); ; b++ {
+		conn.WriteTo(newACKPacket(b), peer)
+		if len(packet(buf[:n]).data()) < defaultBlockSize {
+			break
+		}
+		if n, _, err = conn.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := <-completed; st.Retransmits != 1 || st.Err != nil {
+		t.Errorf("server got %+v, want 1 retransmit", st)
+	}
+	want = ServerStats{Completed: 1, Bytes: 3000, Retransmits: 1}
+	if got := s.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// logBuffer is a bytes.Buffer safe for concurrent logging
+type logBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.String()
+}
+
+func TestLogger(t *testing.T) {
+	var serverLog, clientLog logBuffer
+	files := newMemFiles()
+	files.m["file"] = make([]byte, 3000)
+	s := files.server()
+	s.Logger = slog.New(slog.NewTextHandler(&serverLog, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	completed := make(chan Stats, 1)
+	s.OnComplete = func(st Stats) { completed <- st }
+	addr := startServer(t, s).String()
+	c := &Client{
+		BlockSize: 1024,
+		Logger:    slog.New(slog.NewTextHandler(&clientLog, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	if err := c.Get(context.Background(), addr, "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	c.Get(context.Background(), addr, "missing", io.Discard)
+	<-completed
+	<-completed
+	for _, want := range []string{
+		"msg=request peer=127.0.0.1:",
+		"op=RRQ filename=file mode=Octet",
+		`msg="options negotiated"`,
+		"blksize=1024",
+		`msg="transfer complete"`,
+		"bytes=3000",
+		`msg="transfer failed"`,
+	} {
+		if !strings.Contains(serverLog.String(), want) {
+			t.Errorf("server log has no %s:\n%s", want, serverLog.String())
+		}
+		if !strings.Contains(clientLog.String(), want) {
+			t.Errorf("client log has no %s:\n%s", want, clientLog.String())
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package tftp
+
+import (
+	"bytes"
+	"io/fs"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data the templates of TemplateHandler are executed
+// with
+type TemplateData struct {
+	Filename string           // requested filename
+	IP       netip.Addr       // IP address of the client
+	MAC      net.HardwareAddr // hardware address in the requested filename, nil if none
+	PXE      PXEMachine       // machine requesting a pxelinux configuration file, zero for other files
+	Request  *Request         // the request
+}
+
+// TemplateHandler returns a Handler serving files rendered on the fly by
+// the templates of t, such as per-client iPXE scripts or pxelinux
+// configuration files, with the TemplateData of the request. The size of
+// the rendered file is announced as its transfer size.
+//
+// A file is rendered by the template of t named by the requested filename
+// without leading slashes. A filename with an element holding a hardware
+// address, as in pxelinux.cfg/01-88-99-aa-bb-cc-dd or
+// hosts/88:99:aa:bb:cc:dd/boot.ipxe, is otherwise rendered by the template
+// named with this element replaced by default, as in pxelinux.cfg/default
+// or hosts/default/boot.ipxe. Requests for other files are refused with
+// FileNotFound, and writes are refused.
+func TemplateHandler(t *template.Template) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) error {
+		if r.Op != RRQ {
+			return &fs.PathError{Op: "write", Path: r.Filename, Err: fs.ErrPermission}
+		}
+		name := strings.TrimLeft(path.Clean("/"+r.Filename), "/")
+		data := TemplateData{Filename: r.Filename, Request: r}
+		if a, ok := r.RemoteAddr.(*net.UDPAddr); ok {
+			data.IP = a.AddrPort().Addr().Unmap()
+		}
+		data.PXE, _ = ParsePXEConfig(name)
+		elems := strings.Split(name, "/")
+		i, mac := pathMAC(elems)
+		data.MAC = mac
+		tmpl := t.Lookup(name)
+		if tmpl == nil && mac != nil {
+			elems[i] = "default"
+			tmpl = t.Lookup(strings.Join(elems, "/"))
+		}
+		if tmpl == nil {
+			return &fs.PathError{Op: "open", Path: r.Filename, Err: fs.ErrNotExist}
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return err
+		}
+		w.SetSize(int64(b.Len()))
+		_, err := w.Write(b.Bytes())
+		return err
+	})
+}
+
+// pathMAC returns the index of the first of elems holding a hardware
+// address, as 88:99:aa:bb:cc:dd, 88-99-aa-bb-cc-dd or prefixed by the
+// hardware type as in the pxelinux 01-88-99-aa-bb-cc-dd, and the address
+func pathMAC(elems []string) (int, net.HardwareAddr) {
+	for i, e := range elems {
+		if mac, err := net.ParseMAC(e); err == nil {
+			return i, mac
+		}
+		if len(e) > 3 && isHex(e[0]) && isHex(e[1]) && e[2] == '-' {
+			if mac, err := net.ParseMAC(e[3:]); err == nil {
+				return i, mac
+			}
+		}
+	}
+	return 0, nil
+}
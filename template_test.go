@@ -0,0 +1,62 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestTemplateHandler(t *testing.T) {
+	tmpl := template.Must(template.New("boot.ipxe").Parse("#!ipxe\nchain http://boot/{{.IP}}\n"))
+	template.Must(tmpl.New("pxelinux.cfg/default").Parse("append ks=http://boot/ks/{{.MAC}}\n"))
+	template.Must(tmpl.New("hosts/default/boot.ipxe").Parse("{{.Filename}} {{.MAC}}\n"))
+	template.Must(tmpl.New("fail").Parse("{{.Request.Nope}}"))
+	addr := startServer(t, &Server{Handler: TemplateHandler(tmpl)}).String()
+	var size int64
+	c := &Client{Timeout: time.Second, OnProgress: func(_, n int64) { size = n }}
+	for _, test := range []struct{ filename, want string }{
+		{"/boot.ipxe", "#!ipxe\nchain http://boot/127.0.0.1\n"},
+		{"pxelinux.cfg/01-88-99-aa-bb-cc-dd", "append ks=http://boot/ks/88:99:aa:bb:cc:dd\n"},
+		{"hosts/88-99-aa-bb-cc-dd/boot.ipxe", "hosts/88-99-aa-bb-cc-dd/boot.ipxe 88:99:aa:bb:cc:dd\n"},
+	} {
+		var got bytes.Buffer
+		if err := c.Get(context.Background(), addr, test.filename, &got); err != nil || got.String() != test.want {
+			t.Errorf("%s: got %q, %v, want %q", test.filename, got.String(), err, test.want)
+		}
+		if size != int64(len(test.want)) {
+			t.Errorf("%s: got size %d, want %d", test.filename, size, len(test.want))
+		}
+	}
+	for _, name := range []string{"pxelinux.cfg/C000025B", "hosts/boot.ipxe", "other"} {
+		if err := c.Get(context.Background(), addr, name, &bytes.Buffer{}); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("%s: got %v, want ErrFileNotFound", name, err)
+		}
+	}
+	if err := c.Get(context.Background(), addr, "fail", &bytes.Buffer{}); err == nil {
+		t.Error("served a failing template")
+	}
+	if err := c.Put(context.Background(), addr, "boot.ipxe", bytes.NewReader(nil)); !errors.Is(err, ErrAccessViolation) {
+		t.Errorf("put: got %v, want ErrAccessViolation", err)
+	}
+}
+
+func TestPathMAC(t *testing.T) {
+	for _, test := range []struct {
+		elems []string
+		i     int
+		mac   string
+	}{
+		{[]string{"pxelinux.cfg", "01-88-99-aa-bb-cc-dd"}, 1, "88:99:aa:bb:cc:dd"},
+		{[]string{"88:99:AA:BB:CC:DD", "boot.ipxe"}, 0, "88:99:aa:bb:cc:dd"},
+		{[]string{"pxelinux.cfg", "default"}, 0, ""},
+		{[]string{"01-88-99"}, 0, ""},
+	} {
+		i, mac := pathMAC(test.elems)
+		if i != test.i || mac.String() != test.mac {
+			t.Errorf("%q: got %d, %v, want %d, %s", test.elems, i, mac, test.i, test.mac)
+		}
+	}
+}
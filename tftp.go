@@ -2,28 +2,32 @@ package tftp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"strconv"
 	"strings"
 )
 
-// opcode is a TFTP packet opcode
-type opcode uint16
+// Opcode is a TFTP packet opcode
+type Opcode uint16
 
-//go:generate stringer -type=opcode
+//go:generate stringer -type=Opcode
 
-// opcode constants
+// Opcode constants
 const (
-	_     opcode = iota
+	_     Opcode = iota
 	RRQ          // RFC 1350 The TFTP Protocol (Revision 2)
 	WRQ          // RFC 1350 The TFTP Protocol (Revision 2)
 	DATA         // RFC 1350 The TFTP Protocol (Revision 2)
 	ACK          // RFC 1350 The TFTP Protocol (Revision 2)
 	ERROR        // RFC 1350 The TFTP Protocol (Revision 2)
 	OACK         // RFC 2347 TFTP option Extension
-	maxopcode
+	maxOpcode
 )
 
 // Mode is a TFTP transfer mode
@@ -53,27 +57,61 @@ const (
 	tsize             // RFC 2349 TFTP Timeout Interval and Transfer Size Options
 	multicast         // RFC 2090 TFTP Multicast option
 	windowsize        // RFC 7440 TFTP Windowsize option
+	rollover          // de facto block number rollover option
+	offset            // nonstandard option of this package resuming a download from a byte offset
 	maxOption
 )
 
+// modeNames are the names of the modes in packets, in the lower case of
+// RFC 1350 rather than the names of Mode.String
+var modeNames = [...]string{Octet: "octet", Netascii: "netascii", Mail: "mail"}
+
+// optionNames are the names of the options in packets
+var optionNames = [...]string{
+	blksize:    "blksize",
+	timeout:    "timeout",
+	tsize:      "tsize",
+	multicast:  "multicast",
+	windowsize: "windowsize",
+	rollover:   "rollover",
+	offset:     "x-offset",
+}
+
+// wireName returns the name of m in packets, empty for an invalid mode
+func (m Mode) wireName() string {
+	if int(m) >= len(modeNames) {
+		return ""
+	}
+	return modeNames[m]
+}
+
+// wireName returns the name of o in packets, empty for an unknown option
+func (o option) wireName() string {
+	if int(o) >= len(optionNames) {
+		return ""
+	}
+	return optionNames[o]
+}
+
 // block is a TFTP packet block number
 type block uint16
 
-// errorCode is the TFTP packet error code
-type errorCode uint16
+// ErrorCode is a TFTP error code
+type ErrorCode uint16
 
-//go:generate stringer -type=errorCode
+//go:generate stringer -type=ErrorCode
 
-// errorCode constants
+// ErrorCode constants
 const (
-	_                 errorCode = iota
-	FileNotFound                // RFC 1350 The TFTP Protocol (Revision 2)
-	AccessViolation             // RFC 1350 The TFTP Protocol (Revision 2)
-	DiskFull                    // RFC 1350 The TFTP Protocol (Revision 2)
-	IllegalOperation            // RFC 1350 The TFTP Protocol (Revision 2)
-	UnknownTransferID           // RFC 1350 The TFTP Protocol (Revision 2)
-	FileAlreadyExists           // RFC 1350 The TFTP Protocol (Revision 2)
-	NoSuchUser                  // RFC 1350 The TFTP Protocol (Revision 2)
+	NotDefined              ErrorCode = iota // RFC 1350 The TFTP Protocol (Revision 2)
+	FileNotFound                             // RFC 1350 The TFTP Protocol (Revision 2)
+	AccessViolation                          // RFC 1350 The TFTP Protocol (Revision 2)
+	DiskFull                                 // RFC 1350 The TFTP Protocol (Revision 2)
+	IllegalOperation                         // RFC 1350 The TFTP Protocol (Revision 2)
+	UnknownTransferID                        // RFC 1350 The TFTP Protocol (Revision 2)
+	FileAlreadyExists                        // RFC 1350 The TFTP Protocol (Revision 2)
+	NoSuchUser                               // RFC 1350 The TFTP Protocol (Revision 2)
+	OptionNegotiationFailed                  // RFC 2347 TFTP Option Extension
 	maxErrorCode
 )
 
@@ -83,9 +121,9 @@ type packet []byte
 var separator = []byte{0}
 
 // opcode gets the opcode
-func (p packet) opcode() (o opcode) {
+func (p packet) opcode() (o Opcode) {
 	if len(p) >= 2 {
-		o = opcode(binary.BigEndian.Uint16(p[:2]))
+		o = Opcode(binary.BigEndian.Uint16(p[:2]))
 	}
 	return
 }
@@ -108,73 +146,150 @@ func (p packet) mode() (m Mode) {
 	case RRQ, WRQ:
 		parts := bytes.SplitN(p[2:], separator, 3)
 		if len(parts) >= 3 {
-			switch strings.ToLower(string(parts[1])) {
-			case "octet":
-				m = Octet
-			case "netascii":
-				m = Netascii
-			case "mail":
-				m = Mail
-			}
-
+			m, _ = parseMode(string(parts[1]))
 		}
 	}
 	return
 }
 
-// Options gets the options
+// Options gets the known options with valid values
 func (p packet) options() (o map[option]int) {
-	opcode := p.opcode()
-	parts := bytes.Split(p[2:], separator)
-	fmt.Println(parts)
-	if len(parts) >= 2 {
-		switch opcode {
-		case RRQ, WRQ:
-			parts = parts[2:]
+	switch p.opcode() {
+	case RRQ, WRQ, OACK:
+		o, _ = parseOptions(p.rawOptions())
+	}
+	return
+}
+
+// parseOptions returns the known options with valid values, and an error
+// for a known option with a value that is not a decimal number. Other
+// invalid values are ignored.
+func parseOptions(raw map[string]string) (o map[option]int, err error) {
+	o = make(map[option]int)
+	for name, value := range raw {
+		option, ok := optionNamed(name)
+		if !ok {
+			continue
 		}
-		switch opcode {
-		case RRQ, WRQ, OACK:
-			o = make(map[option]int)
-			for len(parts) >= 2 {
-				var option option
-				var val int
-				var err error
-				name := strings.ToLower(string(parts[0]))
-				value := string(parts[1])
-				parts = parts[2:]
-				switch name {
-				case "blksize":
-					if val, err = strconv.Atoi(value); err != nil {
-						continue
-					}
-					option = blksize
-				case "timeout":
-					if val, err = strconv.Atoi(value); err != nil {
-						continue
-					}
-					option = timeout
-				case "tsize":
-					if val, err = strconv.Atoi(value); err != nil {
-						continue
-					}
-					option = tsize
-				case "multicast":
-					if len(value) != 0 {
-						continue
-					}
-					val = 0
-					option = multicast
-				case "windowsize":
-					if val, err = strconv.Atoi(value); err != nil {
-						continue
-					}
-					option = windowsize
-				default:
-					continue
-				}
-				o[option] = val
+		switch option {
+		case multicast:
+			if len(value) == 0 {
+				o[multicast] = 0
+			}
+			continue
+		case rollover:
+			if value != "0" && value != "1" {
+				continue
+			}
+		}
+		val, verr := parseNumber(value)
+		if verr != nil {
+			if err == nil {
+				err = fmt.Errorf("%w: option %s: %v", ErrMalformedPacket, name, verr)
 			}
+			continue
+		}
+		o[option] = val
+	}
+	return
+}
+
+// parseNumber returns the value of an option value that is a decimal
+// number
+func parseNumber(s string) (int, error) {
+	if s == "" {
+		return 0, errors.New("empty value")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, fmt.Errorf("%q is not a decimal number", s)
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is out of range", s)
+	}
+	return n, nil
+}
+
+// check returns an error if p is not a well formed packet. The message of
+// an ERROR, which ends the transfer anyway, need not be terminated.
+func (p packet) check() error {
+	if len(p) < 2 {
+		return fmt.Errorf("%w: missing opcode", ErrMalformedPacket)
+	}
+	switch op := p.opcode(); op {
+	case RRQ, WRQ:
+		_, _, _, err := unmarshalRequest(p, op)
+		return err
+	case DATA, ACK, ERROR:
+		if len(p) < 4 {
+			return fmt.Errorf("%w: truncated %v", ErrMalformedPacket, op)
+		}
+	case OACK:
+		return new(OptionAck).UnmarshalBinary(p)
+	default:
+		return fmt.Errorf("%w: unknown opcode %d", ErrMalformedPacket, op)
+	}
+	return nil
+}
+
+// knownOption reports whether name is the name of an option implemented
+// by this package
+func knownOption(name string) bool {
+	_, ok := optionNamed(name)
+	return ok
+}
+
+// optionNamed returns the option implemented by this package named name
+func optionNamed(name string) (option, bool) {
+	for o := option(1); o < maxOption; o++ {
+		if o.wireName() == name {
+			return o, true
+		}
+	}
+	return 0, false
+}
+
+// optionStrings returns the option values by name
+func optionStrings(options map[option]int) map[string]string {
+	raw := make(map[string]string, len(options))
+	for option, value := range options {
+		if option == multicast {
+			raw[option.wireName()] = ""
+		} else {
+			raw[option.wireName()] = strconv.Itoa(value)
+		}
+	}
+	return raw
+}
+
+// rawOptions gets the option values by lower case name
+func (p packet) rawOptions() (o map[string]string) {
+	opcode := p.opcode()
+	parts := bytes.Split(p[2:], separator)
+	switch opcode {
+	case RRQ, WRQ:
+		if len(parts) < 2 {
+			return
 		}
+		parts = parts[2:]
+	case OACK:
+	default:
+		return
+	}
+	o = make(map[string]string)
+	for len(parts) >= 2 {
+		o[strings.ToLower(string(parts[0]))] = string(parts[1])
+		parts = parts[2:]
+	}
+	return
+}
+
+// multicast gets the value of the multicast option in an OACK
+func (p packet) multicast() (v string, ok bool) {
+	if p.opcode() == OACK {
+		v, ok = p.rawOptions()["multicast"]
 	}
 	return
 }
@@ -192,11 +307,11 @@ func (p packet) block() (b block) {
 }
 
 // errorCode gets the error code
-func (p packet) errorCode(e errorCode) {
+func (p packet) errorCode() (e ErrorCode) {
 	if len(p) >= 4 {
 		switch p.opcode() {
 		case ERROR:
-			e = errorCode(binary.BigEndian.Uint16(p[2:4]))
+			e = ErrorCode(binary.BigEndian.Uint16(p[2:4]))
 		}
 	}
 	return
@@ -218,80 +333,74 @@ func (p packet) errorMessage() (e string) {
 	if len(p) >= 4 {
 		p = p[4:]
 		if i := bytes.IndexByte(p, 0); i != -1 {
-			e = string(p[:i])
+			p = p[:i]
 		}
+		e = string(p)
 	}
 	return
 }
 
-func writeOptions(out io.Writer, options map[option]int) {
-	for option, value := range options {
-		fmt.Fprintf(out, "%s\x00", option.String())
-		if option != multicast {
-			fmt.Fprintf(out, "%d\x00", value)
-		} else {
-			fmt.Fprintf(out, "\x00")
-		}
+// mustMarshal returns the encoding of a packet built internally, which
+// cannot fail
+func mustMarshal(p interface{ MarshalBinary() ([]byte, error) }) packet {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		panic(err)
 	}
-}
-
-func writeRequest(out io.Writer, opcode opcode, filename string, mode Mode, options map[option]int) {
-	binary.Write(out, binary.BigEndian, uint16(opcode))
-	fmt.Fprintf(out, "%s\x00", filename)
-	fmt.Fprintf(out, "%s\x00", mode.String())
-	writeOptions(out, options)
+	return b
 }
 
 // newRRQPacket returns a packet containing a new RRQ packet
 func newRRQPacket(filename string, mode Mode, options map[option]int) packet {
-	out := &bytes.Buffer{}
-	writeRequest(out, RRQ, filename, mode, options)
-	return out.Bytes()
+	return mustMarshal(&ReadRequest{Filename: filename, Mode: mode, Options: optionStrings(options)})
 }
 
-// newWRQPacket returns a packet containing a new RRQ packet
+// newWRQPacket returns a packet containing a new WRQ packet
 func newWRQPacket(filename string, mode Mode, options map[option]int) packet {
-	out := &bytes.Buffer{}
-	writeRequest(out, WRQ, filename, mode, options)
-	return out.Bytes()
+	return mustMarshal(&WriteRequest{Filename: filename, Mode: mode, Options: optionStrings(options)})
 }
 
 // newDATAPacket returns a packet containing a new DATA packet
 func newDATAPacket(block block, data []byte) packet {
-	out := &bytes.Buffer{}
-	binary.Write(out, binary.BigEndian, uint16(DATA))
-	binary.Write(out, binary.BigEndian, uint16(block))
-	out.Write(data)
-	return out.Bytes()
+	return mustMarshal(&Data{Block: uint16(block), Data: data})
 }
 
 // newACKPacket returns a packet containing a new ACK packet
 func newACKPacket(block block) packet {
-	out := &bytes.Buffer{}
-	binary.Write(out, binary.BigEndian, uint16(ACK))
-	binary.Write(out, binary.BigEndian, uint16(block))
-	return out.Bytes()
+	return mustMarshal(&Ack{Block: uint16(block)})
 }
 
 // newERRORPacket returns a packet containing a new ERROR packet
-func newERRORPacket(errorcode errorCode, errormessage string) packet {
-	out := &bytes.Buffer{}
-	binary.Write(out, binary.BigEndian, uint16(ERROR))
-	binary.Write(out, binary.BigEndian, uint16(errorcode))
-	fmt.Fprintf(out, "%s\x00", errormessage)
-	return out.Bytes()
+func newERRORPacket(errorcode ErrorCode, errormessage string) packet {
+	return mustMarshal(&Error{Code: errorcode, Message: strings.ReplaceAll(errormessage, "\x00", "")})
 }
 
 // newOACKPacket returns a packet containing a new OACK packet
 func newOACKPacket(options map[option]int) packet {
-	out := &bytes.Buffer{}
-	binary.Write(out, binary.BigEndian, uint16(OACK))
-	writeOptions(out, options)
-	return out.Bytes()
+	return mustMarshal(&OptionAck{Options: optionStrings(options)})
+}
+
+// oackPacket returns a packet containing a new OACK packet with options
+// and the acknowledged unknown options
+func (t *transfer) oackPacket(options map[option]int) packet {
+	raw := optionStrings(options)
+	maps.Copy(raw, t.extra)
+	t.acked = raw
+	return mustMarshal(&OptionAck{Options: raw})
+}
+
+// newMulticastOACKPacket returns a packet containing a new OACK packet with
+// the multicast option for a client of group
+func newMulticastOACKPacket(options map[option]int, group *net.UDPAddr, master bool) packet {
+	raw := optionStrings(options)
+	raw[multicast.wireName()] = multicastValue(group, master)
+	return mustMarshal(&OptionAck{Options: raw})
 }
 
-// ReadHandler is a handler function type for a read handler
-type ReadHandler func(filename string, mode Mode) (io.ReadCloser, error)
+// ReadHandler is a handler function type for a read handler, ctx is done
+// when the transfer ends or the server stops
+type ReadHandler func(ctx context.Context, filename string, mode Mode) (io.ReadCloser, error)
 
-// WriteHandler is a handler function type for a write handler
-type WriteHandler func(filename string, mode Mode) (io.WriteCloser, error)
+// WriteHandler is a handler function type for a write handler, ctx is done
+// when the transfer ends or the server stops
+type WriteHandler func(ctx context.Context, filename string, mode Mode) (io.WriteCloser, error)
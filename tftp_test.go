@@ -1,7 +1,9 @@
 package tftp
 
 import (
-	"fmt"
+	"errors"
+	"maps"
+	"strings"
 	"testing"
 )
 
@@ -16,7 +18,7 @@ var validPacketStrings = []string{
 }
 
 type parts struct {
-	opcode   opcode
+	opcode   Opcode
 	filename string
 	mode     Mode
 	block    block
@@ -33,25 +35,113 @@ var validParts = []parts{
 }
 
 func TestPacket(t *testing.T) {
-
 	for i, s := range validPacketStrings {
 		p := packet(s)
-		if p.opcode() != validParts[i].opcode {
-			fmt.Println(p.opcode().String())
-			t.Fail()
+		want := validParts[i]
+		if p.opcode() != want.opcode || p.filename() != want.filename || p.mode() != want.mode || p.block() != want.block {
+			t.Errorf("%q: got %v %q %v %d, want %v %q %v %d", s, p.opcode(), p.filename(), p.mode(), p.block(), want.opcode, want.filename, want.mode, want.block)
+		}
+		if err := p.check(); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+}
+
+func TestPacketCheck(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"\x00",
+		"\x00\x00",
+		"\x00\x07",
+		"\x00\x01test",
+		"\x00\x01test\x00octet",
+		"\x00\x01test\x00octet\x00blksize\x00",
+		"\x00\x01\x00octet\x00",
+		"\x00\x01test\x00binary\x00",
+		"\x00\x03\x00",
+		"\x00\x04\x00",
+		"\x00\x05\x00",
+		"\x00\x06blksize",
+	} {
+		if err := packet(s).check(); !errors.Is(err, ErrMalformedPacket) {
+			t.Errorf("%q: got %v, want ErrMalformedPacket", s, err)
+		}
+	}
+	// an unterminated ERROR message is accepted
+	if p := packet("\x00\x05\x00\x01message"); p.check() != nil || p.errorMessage() != "message" {
+		t.Errorf("got %v, %q", p.check(), p.errorMessage())
+	}
+}
+
+func TestWireNames(t *testing.T) {
+	for m := Octet; m < maxMode; m++ {
+		name := m.wireName()
+		if name != strings.ToLower(name) {
+			t.Errorf("%v: wire name %q not in lower case", m, name)
+		}
+		for _, s := range []string{name, strings.ToUpper(name)} {
+			if got, err := parseMode(s); err != nil || got != m {
+				t.Errorf("%q: got %v, %v, want %v", s, got, err, m)
+			}
 		}
-		if p.filename() != validParts[i].filename {
-			fmt.Println(p.filename())
-			t.Fail()
+		b, err := marshalRequest(RRQ, "file", m, nil)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if p.mode() != validParts[i].mode {
-			fmt.Println(p.mode().String())
-			t.Fail()
+		if want := "\x00\x01file\x00" + name + "\x00"; string(b) != want {
+			t.Errorf("%v: got %q, want %q", m, b, want)
 		}
-		if p.block() != validParts[i].block {
-			t.Fail()
+		if _, got, _, err := unmarshalRequest(b, RRQ); err != nil || got != m || packet(b).mode() != m {
+			t.Errorf("%v: got %v, %v", m, got, err)
 		}
-		fmt.Println(p.options())
+	}
+	if Mode(0).wireName() != "" || maxMode.wireName() != "" {
+		t.Error("wire name of an invalid mode")
 	}
 
+	options := make(map[option]int)
+	for o := blksize; o < maxOption; o++ {
+		if got, ok := optionNamed(o.wireName()); !ok || got != o {
+			t.Errorf("%v: wire name %q names %v", o, o.wireName(), got)
+		}
+		options[o] = 1
+	}
+	if offset.wireName() != "x-offset" {
+		t.Errorf("got %q, want x-offset", offset.wireName())
+	}
+	options[multicast] = 0
+	got, err := parseOptions(optionStrings(options))
+	if err != nil || !maps.Equal(got, options) {
+		t.Errorf("got %v, %v, want %v", got, err, options)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, test := range []struct {
+		raw  map[string]string
+		want map[option]int
+		ok   bool
+	}{
+		{map[string]string{"blksize": "1024", "tsize": "0"}, map[option]int{blksize: 1024, tsize: 0}, true},
+		{map[string]string{"unknown": "x", "rollover": "2", "multicast": "x"}, map[option]int{}, true},
+		{map[string]string{"blksize": "large", "tsize": "0"}, map[option]int{tsize: 0}, false},
+		{map[string]string{"timeout": ""}, map[option]int{}, false},
+		{map[string]string{"windowsize": "-1"}, map[option]int{}, false},
+		{map[string]string{"windowsize": "+1"}, map[option]int{}, false},
+		{map[string]string{"tsize": "99999999999999999999999"}, map[option]int{}, false},
+	} {
+		got, err := parseOptions(test.raw)
+		if (err == nil) != test.ok || len(got) != len(test.want) {
+			t.Errorf("%v: got %v, %v", test.raw, got, err)
+			continue
+		}
+		for o, v := range test.want {
+			if got[o] != v {
+				t.Errorf("%v: got %v, want %v", test.raw, got, test.want)
+			}
+		}
+		if err != nil && !errors.Is(err, ErrMalformedPacket) {
+			t.Errorf("%v: got %v, want ErrMalformedPacket", test.raw, err)
+		}
+	}
 }
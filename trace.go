@@ -0,0 +1,74 @@
+package tftp
+
+import (
+	"context"
+	"net"
+)
+
+// Trace is a set of hooks run at events of a transfer, for example to
+// record spans of a distributed trace. Any hook may be nil. Hooks are run
+// on the goroutine of the transfer.
+type Trace struct {
+	NegotiateStart func()                        // an OACK exchange starts
+	NegotiateDone  func(err error)               // an OACK exchange completed or failed
+	Retransmit     func(reason string)           // a packet or window is retransmitted
+	Progress       func(transferred, size int64) // file data was received or acknowledged
+	Done           func(Stats)                   // the transfer ended
+
+	// Send and Receive are called with each packet sent to and received
+	// from the peer of a unicast transfer, including the request. Packets
+	// are only valid during the call, DecodePacket decodes them.
+	Send    func(p []byte, peer net.Addr)
+	Receive func(p []byte, peer net.Addr)
+}
+
+// traceKey is the context key of a Trace
+type traceKey struct{}
+
+// WithTrace returns a context based on ctx that runs the hooks of trace
+// for the transfers of Client.Get and Client.Put, or for the transfer of a
+// request when returned by Server.TransferContext
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// ContextTrace returns the Trace of ctx, or nil
+func ContextTrace(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
+
+// negotiateStart runs the NegotiateStart hook
+func (t *transfer) negotiateStart() {
+	if t.trace != nil && t.trace.NegotiateStart != nil {
+		t.trace.NegotiateStart()
+	}
+}
+
+// negotiateDone runs the NegotiateDone hook
+func (t *transfer) negotiateDone(err error) {
+	if t.trace != nil && t.trace.NegotiateDone != nil {
+		t.trace.NegotiateDone(err)
+	}
+}
+
+// traceSend runs the Send hook
+func (t *transfer) traceSend(p packet, peer net.Addr) {
+	if t.trace != nil && t.trace.Send != nil {
+		t.trace.Send(p, peer)
+	}
+}
+
+// traceReceive runs the Receive hook
+func (t *transfer) traceReceive(p packet, peer net.Addr) {
+	if t.trace != nil && t.trace.Receive != nil {
+		t.trace.Receive(p, peer)
+	}
+}
+
+// done runs the Done hook
+func (t *transfer) done(st Stats) {
+	if t.trace != nil && t.trace.Done != nil {
+		t.trace.Done(st)
+	}
+}
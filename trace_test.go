@@ -0,0 +1,87 @@
+package tftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// traceEvents records the hooks run for a transfer
+type traceEvents struct {
+	sync.Mutex
+	negotiations, progress int
+	sent, received         []Opcode
+	done                   chan Stats
+}
+
+func newTraceEvents() *traceEvents {
+	return &traceEvents{done: make(chan Stats, 1)}
+}
+
+func (e *traceEvents) trace() *Trace {
+	return &Trace{
+		NegotiateStart: func() { e.Lock(); e.negotiations++; e.Unlock() },
+		Progress:       func(transferred, size int64) { e.Lock(); e.progress++; e.Unlock() },
+		Done:           func(st Stats) { e.done <- st },
+		Send:           func(p []byte, _ net.Addr) { e.add(&e.sent, p) },
+		Receive:        func(p []byte, _ net.Addr) { e.add(&e.received, p) },
+	}
+}
+
+// add appends the opcode of p to ops
+func (e *traceEvents) add(ops *[]Opcode, p []byte) {
+	e.Lock()
+	defer e.Unlock()
+	*ops = append(*ops, packet(p).opcode())
+}
+
+func TestTrace(t *testing.T) {
+	files := newMemFiles()
+	files.m["file"] = make([]byte, 3000)
+	s := files.server()
+	server := newTraceEvents()
+	s.TransferContext = func(r *Request) context.Context {
+		return WithTrace(r.Context(), server.trace())
+	}
+	addr := startServer(t, s)
+
+	client := newTraceEvents()
+	ctx := WithTrace(context.Background(), client.trace())
+	c := &Client{Timeout: time.Second, BlockSize: 1024}
+	if err := c.Get(ctx, addr.String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if st := <-client.done; st.Bytes != 3000 || st.Err != nil {
+		t.Errorf("client got %+v", st)
+	}
+	if st := <-server.done; st.Bytes != 3000 || st.Err != nil {
+		t.Errorf("server got %+v", st)
+	}
+	for name, e := range map[string]*traceEvents{"client": client, "server": server} {
+		e.Lock()
+		if e.negotiations != 1 || e.progress != 3 {
+			t.Errorf("%s got %d negotiations, %d progress, want 1, 3", name, e.negotiations, e.progress)
+		}
+		e.Unlock()
+	}
+	requests, replies := []Opcode{RRQ, ACK, ACK, ACK, ACK}, []Opcode{OACK, DATA, DATA, DATA}
+	if !slices.Equal(client.sent, requests) || !slices.Equal(client.received, replies) {
+		t.Errorf("client sent %v, received %v", client.sent, client.received)
+	}
+	if !slices.Equal(server.sent, replies) || !slices.Equal(server.received, requests) {
+		t.Errorf("server sent %v, received %v", server.sent, server.received)
+	}
+
+	// Done is run when the transfer fails to start
+	ctx = WithTrace(context.Background(), client.trace())
+	if err := c.Get(ctx, "localhost:bad", "file", io.Discard); err == nil {
+		t.Fatal("got no error")
+	}
+	if st := <-client.done; st.Err == nil {
+		t.Errorf("got %+v, want error", st)
+	}
+}
@@ -0,0 +1,165 @@
+// Package tracing records OpenTelemetry spans for TFTP transfers.
+//
+// Each transfer is recorded as a span carrying the filename, peer, block
+// size and bytes transferred, with child spans for the option negotiation
+// and for each burst of retransmissions.
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// instrumentationName names the tracer of this package
+const instrumentationName = "github.com/jochenvg/go.tftp/tracing"
+
+// Instrument records a span for each transfer of s with tp, the global
+// TracerProvider if nil. It wraps s.TransferContext and must be called
+// before s serves requests.
+func Instrument(s *tftp.Server, tp trace.TracerProvider) {
+	tracer := tracer(tp)
+	next := s.TransferContext
+	s.TransferContext = func(r *tftp.Request) context.Context {
+		ctx := r.Context()
+		if next != nil {
+			ctx = next(r)
+		}
+		return start(ctx, tracer, trace.SpanKindServer, r.Op, r.Filename)
+	}
+}
+
+// Context returns a context based on ctx that records a span for a
+// Client.Get, op RRQ, or Client.Put, op WRQ, of filename with tp, the
+// global TracerProvider if nil. The context must be used for a single
+// transfer.
+func Context(ctx context.Context, tp trace.TracerProvider, op tftp.Opcode, filename string) context.Context {
+	return start(ctx, tracer(tp), trace.SpanKindClient, op, filename)
+}
+
+// tracer returns the tracer of tp, or of the global TracerProvider
+func tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+// start starts the span of a transfer and returns a context with the span
+// and a tftp.Trace recording it
+func start(ctx context.Context, tracer trace.Tracer, kind trace.SpanKind, op tftp.Opcode, filename string) context.Context {
+	ctx, span := tracer.Start(ctx, "tftp "+op.String(), trace.WithSpanKind(kind), trace.WithAttributes(
+		attribute.String("tftp.op", op.String()),
+		attribute.String("tftp.filename", filename),
+	))
+	rec := &recorder{ctx: ctx, tracer: tracer, span: span}
+	return tftp.WithTrace(ctx, &tftp.Trace{
+		NegotiateStart: rec.negotiateStart,
+		NegotiateDone:  rec.negotiateDone,
+		Retransmit:     rec.retransmit,
+		Progress:       rec.progress,
+		Done:           rec.done,
+	})
+}
+
+// recorder records the spans of a transfer
+type recorder struct {
+	ctx    context.Context
+	tracer trace.Tracer
+	span   trace.Span
+
+	mu          sync.Mutex
+	negotiation trace.Span // open negotiation span, or nil
+	burst       trace.Span // open retransmission span, or nil
+	retransmits int        // retransmissions of the open burst
+}
+
+// negotiateStart opens the negotiation span
+func (r *recorder) negotiateStart() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, r.negotiation = r.tracer.Start(r.ctx, "negotiate")
+}
+
+// negotiateDone closes the negotiation span and any retransmission burst
+// during it
+func (r *recorder) negotiateDone(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endBurst()
+	if r.negotiation == nil {
+		return
+	}
+	if err != nil {
+		r.negotiation.RecordError(err)
+		r.negotiation.SetStatus(codes.Error, err.Error())
+	}
+	r.negotiation.End()
+	r.negotiation = nil
+}
+
+// retransmit opens a retransmission span unless one is open, and records
+// the retransmission as an event
+func (r *recorder) retransmit(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.burst == nil {
+		parent := r.ctx
+		if r.negotiation != nil {
+			parent = trace.ContextWithSpan(parent, r.negotiation)
+		}
+		_, r.burst = r.tracer.Start(parent, "retransmit")
+	}
+	r.retransmits++
+	r.burst.AddEvent("retransmit", trace.WithAttributes(attribute.String("tftp.reason", reason)))
+}
+
+// progress closes the retransmission span, as the peer is responding again
+func (r *recorder) progress(transferred, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endBurst()
+}
+
+// endBurst closes the retransmission span, if open
+func (r *recorder) endBurst() {
+	if r.burst == nil {
+		return
+	}
+	r.burst.SetAttributes(attribute.Int("tftp.retransmits", r.retransmits))
+	r.burst.End()
+	r.burst = nil
+	r.retransmits = 0
+}
+
+// done closes all spans of the transfer
+func (r *recorder) done(st tftp.Stats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endBurst()
+	if r.negotiation != nil {
+		r.negotiation.End()
+		r.negotiation = nil
+	}
+	attrs := []attribute.KeyValue{
+		attribute.Int64("tftp.bytes", st.Bytes),
+		attribute.Int("tftp.blksize", st.BlockSize),
+		attribute.Int("tftp.windowsize", st.WindowSize),
+		attribute.Int("tftp.retransmits", st.Retransmits),
+	}
+	if st.Peer != nil {
+		attrs = append(attrs, attribute.String("network.peer.address", st.Peer.String()))
+	}
+	r.span.SetAttributes(attrs...)
+	if st.Err != nil {
+		r.span.RecordError(st.Err)
+		r.span.SetStatus(codes.Error, st.Err.Error())
+	}
+	r.span.End()
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// attr returns the value of attribute key of span
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	completed := make(chan tftp.Stats, 1)
+	s := &tftp.Server{
+		ReadHandler: func(ctx context.Context, filename string, mode tftp.Mode) (io.ReadCloser, error) {
+			if filename != "file" {
+				return nil, errors.New("not found")
+			}
+			return io.NopCloser(bytes.NewReader(make([]byte, 3000))), nil
+		},
+		OnComplete: func(st tftp.Stats) { completed <- st },
+	}
+	Instrument(s, tp)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.Serve(conn)
+
+	c := &tftp.Client{Timeout: time.Second, BlockSize: 1024}
+	ctx := Context(context.Background(), tp, tftp.RRQ, "file")
+	if err := c.Get(ctx, conn.LocalAddr().String(), "file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	<-completed
+	c.Get(Context(context.Background(), tp, tftp.RRQ, "missing"), conn.LocalAddr().String(), "missing", io.Discard)
+	<-completed
+
+	var transfers, negotiations int
+	for _, span := range rec.Ended() {
+		switch span.Name() {
+		case "tftp RRQ":
+			transfers++
+			if attr(span, "tftp.filename").AsString() != "file" {
+				if span.Status().Code != codes.Error {
+					t.Errorf("%s: got status %v, want error", span.Name(), span.Status())
+				}
+				continue
+			}
+			if got := attr(span, "tftp.bytes").AsInt64(); got != 3000 {
+				t.Errorf("got %d bytes, want 3000", got)
+			}
+			if got := attr(span, "tftp.blksize").AsInt64(); got != 1024 {
+				t.Errorf("got blksize %d, want 1024", got)
+			}
+			if attr(span, "network.peer.address").AsString() == "" {
+				t.Error("got no peer")
+			}
+		case "negotiate":
+			negotiations++
+			if !span.Parent().IsValid() {
+				t.Error("negotiate span has no parent")
+			}
+		}
+	}
+	// client and server spans of two requests, the second refused before
+	// negotiation by the server
+	if transfers != 4 || negotiations != 3 {
+		t.Errorf("got %d transfer and %d negotiate spans, want 4 and 3", transfers, negotiations)
+	}
+}
+
+func TestRetransmitBurst(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	ctx := Context(context.Background(), tp, tftp.WRQ, "file")
+	trace := tftp.ContextTrace(ctx)
+	trace.Retransmit("timeout")
+	trace.Retransmit("timeout")
+	trace.Progress(512, -1)
+	trace.Retransmit("duplicate")
+	trace.Done(tftp.Stats{Op: tftp.WRQ, Filename: "file", Retransmits: 3})
+
+	var bursts []int64
+	for _, span := range rec.Ended() {
+		if span.Name() == "retransmit" {
+			bursts = append(bursts, attr(span, "tftp.retransmits").AsInt64())
+		}
+	}
+	if len(bursts) != 2 || bursts[0] != 2 || bursts[1] != 1 {
+		t.Errorf("got bursts %v, want [2 1]", bursts)
+	}
+}
@@ -0,0 +1,820 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// transfer defaults
+const (
+	defaultBlockSize = 512                    // RFC 1350 The TFTP Protocol (Revision 2)
+	minBlockSize     = 8                      // RFC 2348 TFTP Blocksize option
+	maxBlockSize     = 65464                  // RFC 2348 TFTP Blocksize option
+	defaultTimeout   = 5 * time.Second        // retransmission interval
+	defaultRetries   = 5                      // retransmissions before giving up
+	maxBackoff       = 30 * time.Second       // largest retransmission interval after backing off
+	minAdaptive      = 200 * time.Millisecond // smallest retransmission interval adapted to the RTT
+	maxWindowSize    = 65535                  // RFC 7440 TFTP Windowsize option
+	minTimeout       = 1                      // RFC 2349 TFTP Timeout Interval and Transfer Size Options
+	maxTimeout       = 255                    // RFC 2349 TFTP Timeout Interval and Transfer Size Options
+)
+
+// ErrTimeout is returned when the peer stops responding
+var ErrTimeout = errors.New("tftp: timeout")
+
+// ErrTransferAborted matches the errors returned when the peer aborts a
+// transfer with an ERROR packet, which also match the *Error received
+var ErrTransferAborted = errors.New("tftp: transfer aborted by peer")
+
+// ErrOptionNegotiation matches the errors returned when the options
+// acknowledged by the peer are invalid
+var ErrOptionNegotiation = errors.New("tftp: option negotiation failed")
+
+// discard is the logger used when none is configured
+var discard = slog.New(slog.DiscardHandler)
+
+// transfer is the state of a TFTP transfer with a single peer
+type transfer struct {
+	ctx       context.Context
+	clock     Clock
+	conn      net.PacketConn
+	peer      net.Addr
+	locked    bool // peer TID is established
+	connected bool // conn is connected to the peer TID
+	blksize   int
+	window    int   // negotiated windowsize
+	wrap      block // block number following 65535
+	timeout   time.Duration
+	retries   int // retransmissions of blocks and acknowledgements
+	buf       []byte
+
+	requestRetries int // retransmissions of the request or OACK
+
+	handshake time.Duration // longest wait for the first reply, unlimited if zero
+	idle      time.Duration // longest time without progress, unlimited if zero
+	replied   bool          // a reply was received from the peer
+	active    time.Time     // time of the first reply or the last progress
+
+	size        int64                         // file size, -1 if unknown
+	transferred int64                         // file data sent and acknowledged or received
+	onProgress  func(transferred, size int64) // progress callback, may be nil
+	start       time.Time                     // time the transfer started
+	retransmits int                           // timeouts and retransmissions
+	log         *slog.Logger
+	trace       *Trace                           // hooks, may be nil
+	throttle    func(ctx context.Context, n int) // waits to limit throughput, may be nil
+	extra       map[string]string                // unknown options acknowledged in the OACK
+	acked       map[string]string                // options of the OACK sent, nil if none
+	session     *session                         // progress reported to Server.Sessions, may be nil
+
+	batch batchConn      // batched I/O, nil if unavailable
+	wmsgs []ipv4.Message // messages for batched writes
+	rmsgs []ipv4.Message // messages for batched reads
+	queue []ipv4.Message // datagrams read but not yet received
+
+	gso      bool   // windows are sent with UDP segmentation offload
+	segments []byte // buffer for segmentation offload
+
+	adaptive bool          // timeout adapts to the measured RTT
+	sent     time.Time     // time of the last packet sent
+	srtt     time.Duration // smoothed RTT, zero until measured
+	rttvar   time.Duration // RTT variation
+}
+
+// newTransfer returns a new transfer with the peer on conn
+func newTransfer(ctx context.Context, conn net.PacketConn, peer net.Addr, locked bool) *transfer {
+	return &transfer{
+		ctx:     ctx,
+		conn:    conn,
+		peer:    peer,
+		locked:  locked,
+		blksize: defaultBlockSize,
+		window:  1,
+		timeout: defaultTimeout,
+		retries: defaultRetries,
+		buf:     make([]byte, 4+defaultBlockSize),
+		size:    -1,
+		clock:   SystemClock,
+		start:   time.Now(),
+		log:     discard,
+		trace:   ContextTrace(ctx),
+		batch:   newBatchConn(conn, peer, false),
+
+		adaptive:       true,
+		requestRetries: defaultRetries,
+	}
+}
+
+// setClock sets the clock of the transfer, restarting it
+func (t *transfer) setClock(c Clock) {
+	t.clock = orSystem(c)
+	t.start = t.now()
+}
+
+// now returns the time of the clock of the transfer
+func (t *transfer) now() time.Time {
+	return orSystem(t.clock).Now()
+}
+
+// setBlockSize sets the negotiated block size
+func (t *transfer) setBlockSize(n int) {
+	t.blksize = n
+	t.buf = make([]byte, 4+n)
+}
+
+// apply applies acknowledged options
+func (t *transfer) apply(oack map[option]int) {
+	if t.log.Enabled(t.ctx, slog.LevelDebug) {
+		attrs := make([]any, 0, len(oack))
+		for o, n := range oack {
+			attrs = append(attrs, slog.Int(o.wireName(), n))
+		}
+		t.log.Debug("options negotiated", attrs...)
+	}
+	if n, ok := oack[blksize]; ok {
+		t.setBlockSize(n)
+	}
+	if n, ok := oack[windowsize]; ok {
+		t.window = n
+	}
+	if n, ok := oack[timeout]; ok {
+		t.timeout = time.Duration(n) * time.Second
+		t.adaptive = false
+	}
+	if n, ok := oack[rollover]; ok {
+		t.wrap = block(n)
+	}
+}
+
+// next returns the block number following b, rolling over after 65535
+func (t *transfer) next(b block) block {
+	if b == 65535 {
+		return t.wrap
+	}
+	return b + 1
+}
+
+// progress records n more bytes of file data transferred
+func (t *transfer) progress(n int) {
+	t.transferred += int64(n)
+	if t.idle > 0 {
+		t.active = t.now()
+	}
+	if t.session != nil {
+		t.session.transferred.Store(t.transferred)
+		t.session.size.Store(t.size)
+	}
+	if t.onProgress != nil {
+		t.onProgress(t.transferred, t.size)
+	}
+	if t.trace != nil && t.trace.Progress != nil {
+		t.trace.Progress(t.transferred, t.size)
+	}
+	if t.throttle != nil {
+		t.throttle(t.ctx, n)
+	}
+}
+
+// retransmitted records the retransmission of p
+func (t *transfer) retransmitted(reason string, p packet) {
+	t.retransmits++
+	t.log.Debug("retransmit", "reason", reason, "opcode", p.opcode(), "block", p.block())
+	if t.trace != nil && t.trace.Retransmit != nil {
+		t.trace.Retransmit(reason)
+	}
+}
+
+// send sends a packet to the peer
+func (t *transfer) send(p packet) error {
+	t.sent = t.now()
+	t.traceSend(p, t.peer)
+	if t.connected {
+		_, err := t.conn.(io.Writer).Write(p)
+		return err
+	}
+	_, err := t.conn.WriteTo(p, t.peer)
+	return err
+}
+
+// measure updates the retransmission interval with the RTT of a reply to
+// the last packet sent as in RFC 6298 Computing TCP's Retransmission Timer.
+// Replies to retransmitted packets must not be measured as it is not known
+// which transmission they answer.
+func (t *transfer) measure() {
+	if !t.adaptive {
+		return
+	}
+	rtt := t.now().Sub(t.sent)
+	if t.srtt == 0 {
+		t.srtt, t.rttvar = rtt, rtt/2
+	} else {
+		t.rttvar = (3*t.rttvar + (t.srtt - rtt).Abs()) / 4
+		t.srtt = (7*t.srtt + rtt) / 8
+	}
+	t.timeout = min(max(t.srtt+4*t.rttvar, minAdaptive), maxBackoff)
+}
+
+// receive waits for the next packet from the peer until the deadline.
+// The packet is only valid until the next call to receive. It fails with
+// ErrTimeout once the handshake or idle timeout expires.
+func (t *transfer) receive(deadline time.Time) (packet, net.Addr, error) {
+	limit := t.limit()
+	if !limit.IsZero() && limit.Before(deadline) {
+		deadline = limit
+	}
+	if err := t.conn.SetReadDeadline(deadline); err != nil {
+		return nil, nil, err
+	}
+	if t.ctx.Err() != nil {
+		return nil, nil, t.cancel(context.Cause(t.ctx))
+	}
+	for {
+		b, addr, rerr := t.read()
+		if rerr != nil {
+			if t.ctx.Err() != nil {
+				rerr = t.cancel(context.Cause(t.ctx))
+			} else if isTimeout(rerr) && deadline.Equal(limit) {
+				rerr = t.expired()
+			}
+			return nil, nil, rerr
+		}
+		t.traceReceive(b, addr)
+		if t.locked && !t.connected && !sameAddr(addr, t.peer) {
+			// RFC 1350: answered with an error, without disturbing
+			// the transfer. Connected conns never see such packets.
+			if packet(b).opcode() != ERROR {
+				t.traceSend(unknownTIDPacket, addr)
+				t.conn.WriteTo(unknownTIDPacket, addr)
+			}
+			continue
+		}
+		p := packet(b)
+		if err := p.check(); err != nil {
+			t.lock(addr)
+			t.send(newERRORPacket(IllegalOperation, err.Error()))
+			return nil, nil, err
+		}
+		if !t.replied {
+			t.replied, t.active = true, t.now()
+		}
+		return p, addr, nil
+	}
+}
+
+// limit returns the time the handshake or idle timeout expires, zero if
+// unlimited
+func (t *transfer) limit() time.Time {
+	switch {
+	case !t.replied && t.handshake > 0:
+		return t.start.Add(t.handshake)
+	case t.replied && t.idle > 0:
+		return t.active.Add(t.idle)
+	}
+	return time.Time{}
+}
+
+// expired returns the error for an expired handshake or idle timeout
+func (t *transfer) expired() error {
+	if !t.replied {
+		return fmt.Errorf("%w: no reply in %v", ErrTimeout, t.handshake)
+	}
+	return fmt.Errorf("%w: no progress in %v", ErrTimeout, t.idle)
+}
+
+// unknownTIDPacket answers packets from other addresses than the peer
+var unknownTIDPacket = newERRORPacket(UnknownTransferID, "unknown transfer ID")
+
+// sameAddr reports whether a and b are the same address, without
+// allocating for UDP addresses
+func sameAddr(a, b net.Addr) bool {
+	ua, ok1 := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	if ok1 && ok2 {
+		return ua.Port == ub.Port && ua.IP.Equal(ub.IP) && ua.Zone == ub.Zone
+	}
+	return a.String() == b.String()
+}
+
+// lock establishes the peer TID from the first reply, connecting conn to
+// it where supported
+func (t *transfer) lock(addr net.Addr) {
+	if t.locked {
+		return
+	}
+	t.peer, t.locked = addr, true
+	if c, ok := t.conn.(*net.UDPConn); ok && connect(c, addr) == nil {
+		t.connected = true
+		t.batch = newBatchConn(c, addr, true)
+	}
+}
+
+// cancel aborts the transfer with the peer for a done context, err being
+// the cause
+func (t *transfer) cancel(err error) error {
+	if t.locked {
+		t.abort(err)
+	}
+	return err
+}
+
+// exchange sends p and waits for a reply accepted by match, retransmitting p
+// when no reply arrives in time. An ERROR reply aborts the exchange.
+func (t *transfer) exchange(p packet, match func(packet) bool) (packet, error) {
+	for try := 0; ; try++ {
+		if try > 0 {
+			t.retransmitted("no reply", p)
+		}
+		if err := t.send(p); err != nil {
+			return nil, err
+		}
+		deadline := t.now().Add(backoff(t.timeout, try))
+		for {
+			r, addr, err := t.receive(deadline)
+			if isTimeout(err) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if r.opcode() != ERROR && !match(r) {
+				continue
+			}
+			t.lock(addr)
+			if r.opcode() == ERROR {
+				return nil, peerError(r)
+			}
+			if try == 0 {
+				t.measure()
+			}
+			return r, nil
+		}
+		if try == t.requestRetries {
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// abort sends an ERROR packet for err to the peer
+func (t *transfer) abort(err error) {
+	t.send(errorPacket(err, NotDefined))
+}
+
+// errorPacket returns the ERROR packet reporting err to the peer, with
+// code if err maps to no other error code
+func errorPacket(err error, code ErrorCode) packet {
+	var e *Error
+	if errors.As(err, &e) {
+		return newERRORPacket(e.Code, e.Message)
+	}
+	if c := errorCodeOf(err); c != NotDefined {
+		code = c
+	}
+	return newERRORPacket(code, err.Error())
+}
+
+// errorCodeOf returns the error code reporting err to the peer, not
+// defined if it maps to none
+func errorCodeOf(err error) ErrorCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return FileNotFound
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return AccessViolation
+	case errors.Is(err, fs.ErrExist):
+		return FileAlreadyExists
+	case errors.Is(err, errTooLarge), errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT), errors.Is(err, syscall.EFBIG):
+		return DiskFull
+	case errors.Is(err, ErrOptionNegotiation):
+		return OptionNegotiationFailed
+	}
+	return 0
+}
+
+// watch interrupts pending reads when ctx is done, the returned function
+// stops watching
+func (t *transfer) watch() (stop func() bool) {
+	return context.AfterFunc(t.ctx, func() {
+		t.conn.SetReadDeadline(time.Unix(1, 0))
+	})
+}
+
+// backoff returns the time to wait for a reply after try retransmissions,
+// doubling timeout for each retransmission up to maxBackoff. Up to a tenth
+// is added at random so that peers that timed out together retransmit
+// apart.
+func backoff(timeout time.Duration, try int) time.Duration {
+	d := timeout
+	for i := 0; i < try && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff && timeout <= maxBackoff {
+		d = maxBackoff
+	}
+	return d + rand.N(d/10+1)
+}
+
+// peerError returns the error for an ERROR packet received from the peer
+func peerError(p packet) error {
+	return abortError{&Error{Code: p.errorCode(), Message: p.errorMessage()}}
+}
+
+// abortError is an *Error received from the peer
+type abortError struct {
+	err *Error
+}
+
+// Error returns the message of the *Error
+func (e abortError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the *Error and ErrTransferAborted
+func (e abortError) Unwrap() []error {
+	return []error{e.err, ErrTransferAborted}
+}
+
+// isTimeout reports whether err is a read deadline expiry
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// sender sends a stream of DATA packets to the peer. Up to windowsize
+// blocks are sent before waiting for an acknowledgement, unacknowledged
+// blocks are sent again when the peer times out or acknowledges only part
+// of the window.
+type sender struct {
+	*transfer
+	block    block       // last block sent
+	window   []unacked   // blocks sent but not yet acknowledged
+	unsent   int         // blocks at the end of the window not yet sent
+	outgoing []packet    // packets of the window being sent
+	pending  *buffer     // DATA packet of the block being buffered
+	data     []byte      // data buffered in pending
+	src      io.ReaderAt // source of the blocks read by readFrom
+	repeated bool        // the window was sent again for a duplicate ACK
+	err      error
+}
+
+// unacked is a block of the window of a sender
+type unacked struct {
+	block block
+	buf   *buffer // DATA packet, nil while a block of src is not in memory
+	off   int64   // offset of the data in src, -1 if written
+	n     int     // length of the data
+}
+
+// newSender returns a sender on t
+func newSender(t *transfer) *sender {
+	s := &sender{transfer: t}
+	s.alloc()
+	return s
+}
+
+// alloc starts buffering the next block in a new buffer, the DATA header
+// being filled in when it is sent
+func (s *sender) alloc() {
+	s.pending = getBuffer(4 + s.blksize)
+	s.data = s.pending.p[4:4]
+}
+
+// Write buffers p, sending each completed block
+func (s *sender) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if s.err != nil {
+			return n, s.err
+		}
+		c := copy(s.data[len(s.data):s.blksize], p)
+		s.data = s.data[:len(s.data)+c]
+		p = p[c:]
+		n += c
+		if len(s.data) == s.blksize {
+			s.flush(-1, false)
+		}
+	}
+	return n, s.err
+}
+
+// readFrom sends the blocks of src from offset off on, reading the
+// blocks sent again from src rather than keeping them in memory. The data
+// of the last, short block is buffered as if written. No data must be
+// buffered when it is called.
+func (s *sender) readFrom(src io.ReaderAt, off int64) (int64, error) {
+	s.src = src
+	var written int64
+	for s.err == nil {
+		n, err := src.ReadAt(s.pending.p[4:4+s.blksize], off)
+		s.data = s.pending.p[4 : 4+n]
+		written += int64(n)
+		if n < s.blksize {
+			if err == io.EOF {
+				err = nil
+			}
+			return written, err
+		}
+		s.flush(off, false)
+		off += int64(n)
+	}
+	return written, s.err
+}
+
+// flush adds the buffered block to the window, off being the offset of
+// its data in src or -1 if written. The blocks not yet sent are sent at
+// once when the window is full or the block is the last, waiting for an
+// acknowledgement when the window is full.
+func (s *sender) flush(off int64, last bool) {
+	s.block = s.next(s.block)
+	d, n := s.pending, len(s.data)
+	d.p = d.p[:4+n]
+	appendHeader(d.p[:0], DATA, uint16(s.block))
+	s.alloc()
+	s.window = append(s.window, unacked{block: s.block, buf: d, off: off, n: n})
+	s.unsent++
+	if len(s.window) < s.transfer.window && !last {
+		return
+	}
+	if s.err = s.sendWindow(len(s.window) - s.unsent); s.err != nil {
+		return
+	}
+	for s.err == nil && len(s.window) == s.transfer.window {
+		s.ack()
+	}
+}
+
+// sendWindow sends the blocks of the window from the i-th on, reading
+// the blocks of src again. The blocks of src are released once sent.
+func (s *sender) sendWindow(i int) error {
+	s.outgoing = s.outgoing[:0]
+	for j := range s.window[i:] {
+		u := &s.window[i+j]
+		if u.buf == nil {
+			if err := s.load(u); err != nil {
+				s.abort(err)
+				return err
+			}
+		}
+		s.outgoing = append(s.outgoing, u.buf.p)
+	}
+	s.unsent = 0
+	err := s.sendBatch(s.outgoing)
+	for j := range s.window[i:] {
+		if u := &s.window[i+j]; u.off >= 0 {
+			u.buf.free()
+			u.buf = nil
+		}
+	}
+	return err
+}
+
+// load reads the DATA packet of a block of src
+func (s *sender) load(u *unacked) error {
+	u.buf = getBuffer(4 + u.n)
+	appendHeader(u.buf.p[:0], DATA, uint16(u.block))
+	n, err := s.src.ReadAt(u.buf.p[4:], u.off)
+	if n == u.n {
+		return nil
+	}
+	u.buf.free()
+	u.buf = nil
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// header returns the header of the DATA packet of a block of the window
+func (u *unacked) header() packet {
+	return appendHeader(nil, DATA, uint16(u.block))
+}
+
+// ack waits for an acknowledgement of one or more blocks in the window,
+// sending the window again on timeout
+func (s *sender) ack() {
+	deadline := s.now().Add(s.timeout)
+	for try := 0; ; {
+		p, _, err := s.receive(deadline)
+		if isTimeout(err) {
+			if try == s.retries {
+				s.err = ErrTimeout
+				return
+			}
+			try++
+			s.retransmitted("timeout", s.window[0].header())
+			if s.err = s.resend(); s.err != nil {
+				return
+			}
+			deadline = s.now().Add(backoff(s.timeout, try))
+			continue
+		}
+		if err != nil {
+			s.err = err
+			return
+		}
+		switch p.opcode() {
+		case ERROR:
+			s.err = peerError(p)
+			return
+		case ACK:
+			n := s.acknowledged(p.block())
+			if n < 0 {
+				continue
+			}
+			if n == 0 {
+				// a duplicate ACK of the block before the window.
+				// Sending the window again for each would double the
+				// packets for every delayed ACK, the Sorcerer's
+				// Apprentice Syndrome of RFC 1123 4.2.3.1, so it is
+				// sent again on timeout only. A receiver of a window
+				// of several blocks sends one when it misses the
+				// first, which is answered once.
+				if s.transfer.window == 1 || s.repeated {
+					continue
+				}
+				s.repeated = true
+				s.retransmitted("block missing", s.window[0].header())
+				s.err = s.resend()
+				return
+			}
+			s.repeated = false
+			if try == 0 && n > 0 {
+				s.measure()
+			}
+			for _, u := range s.window[:n] {
+				s.progress(u.n)
+				if u.buf != nil {
+					u.buf.free()
+				}
+			}
+			s.window = s.window[:copy(s.window, s.window[n:])]
+			if len(s.window) > 0 {
+				s.retransmitted("partial acknowledgement", s.window[0].header())
+				s.err = s.resend()
+			}
+			return
+		}
+	}
+}
+
+// acknowledged returns the number of blocks in the window acknowledged by
+// an ACK of b, 0 for the block before the window and -1 for other blocks
+func (s *sender) acknowledged(b block) int {
+	for i, u := range s.window {
+		if u.block == b {
+			return i + 1
+		}
+	}
+	if s.next(b) == s.window[0].block {
+		return 0
+	}
+	return -1
+}
+
+// resend sends all blocks in the window again
+func (s *sender) resend() error {
+	return s.sendWindow(0)
+}
+
+// Close sends the final short block and waits until all blocks are
+// acknowledged, completing the transfer
+func (s *sender) Close() error {
+	if s.err == nil {
+		s.flush(-1, true)
+	}
+	for s.err == nil && len(s.window) > 0 {
+		s.ack()
+	}
+	if s.pending != nil {
+		s.pending.free()
+		s.pending = nil
+	}
+	return s.err
+}
+
+// receiver receives a stream of DATA packets from the peer, acknowledging
+// each window of blocks as more data is read
+type receiver struct {
+	*transfer
+	block    block   // last block received
+	ack      packet  // packet soliciting the next window
+	ackBuf   [4]byte // ACK packet reused for each window
+	due      bool    // ack must be sent before waiting for the next block
+	received int     // blocks received in the window
+	gap      bool    // a block was missed since the last one received
+	data     []byte
+	done     bool
+	err      error
+}
+
+// newReceiver returns a receiver on t, soliciting the first block with ack
+func newReceiver(t *transfer, ack packet) *receiver {
+	return &receiver{
+		transfer: t,
+		ack:      ack,
+		due:      true,
+	}
+}
+
+// accept accepts the next DATA block
+func (r *receiver) accept(d packet) {
+	r.block = d.block()
+	r.ack, _ = (&Ack{Block: uint16(r.block)}).AppendBinary(r.ackBuf[:0])
+	r.data = d.data()
+	r.done = len(r.data) < r.blksize
+	r.received++
+	r.progress(len(r.data))
+	r.due = r.received == r.transfer.window
+	r.gap = false
+}
+
+// Read reads data from received blocks
+func (r *receiver) Read(p []byte) (n int, err error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.wait()
+	}
+	n = copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// wait acknowledges the window when it is complete and waits for the next
+// block. The last block received in order is acknowledged again on timeout
+// or when a block arrives out of order. Until the peer replies, as to a
+// RRQ without options, the request is sent again up to requestRetries
+// times.
+func (r *receiver) wait() {
+	solicited := false // the next block answers an ACK sent only once
+	for try := 0; ; {
+		if r.due {
+			if r.err = r.send(r.ack); r.err != nil {
+				return
+			}
+			r.due, r.received = false, 0
+			solicited = try == 0
+		}
+		p, addr, err := r.receive(r.now().Add(backoff(r.timeout, try)))
+		if isTimeout(err) {
+			retries := r.retries
+			if !r.locked {
+				retries = r.requestRetries
+			}
+			if try >= retries {
+				r.err = ErrTimeout
+				return
+			}
+			try++
+			r.retransmitted("timeout", r.ack)
+			r.due = true
+			continue
+		}
+		if err != nil {
+			r.err = err
+			return
+		}
+		switch p.opcode() {
+		case ERROR:
+			r.lock(addr)
+			r.err = peerError(p)
+			return
+		case DATA:
+			if p.block() == r.next(r.block) {
+				r.lock(addr)
+				if solicited {
+					r.measure()
+				}
+				r.accept(p)
+				return
+			}
+			if ahead := p.block() - r.block; ahead > 1 && int(ahead) <= r.transfer.window && !r.gap {
+				r.gap, r.due = true, true
+				r.retransmitted("block missing", r.ack)
+			}
+		}
+	}
+}
+
+// Close acknowledges the final block, completing the transfer
+func (r *receiver) Close() error {
+	if r.err != nil {
+		return r.err
+	}
+	if !r.done {
+		return io.ErrUnexpectedEOF
+	}
+	return r.send(r.ack)
+}
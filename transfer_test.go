@@ -0,0 +1,375 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// transferPair returns two locked transfers with each other over loopback
+func transferPair(t *testing.T) (*transfer, *transfer) {
+	a, b := dialServer(t), dialServer(t)
+	a.SetDeadline(time.Time{})
+	b.SetDeadline(time.Time{})
+	ctx := context.Background()
+	return newTransfer(ctx, a, b.LocalAddr(), true), newTransfer(ctx, b, a.LocalAddr(), true)
+}
+
+// copyTransfer sends content from a to b, returning what b received
+func copyTransfer(t *testing.T, a, b *transfer, content []byte) []byte {
+	errc := make(chan error, 1)
+	go func() {
+		w := newSender(a)
+		if _, err := w.Write(content); err != nil {
+			errc <- err
+			return
+		}
+		errc <- w.Close()
+	}()
+	r := newReceiver(b, newACKPacket(0))
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestTransferRollover(t *testing.T) {
+	content := make([]byte, 8*70000+3)
+	for i := range content {
+		content[i] = byte(i / 8)
+	}
+	for _, wrap := range []int{0, 1} {
+		a, b := transferPair(t)
+		oack := map[option]int{blksize: 8, windowsize: 64, rollover: wrap}
+		a.apply(oack)
+		b.apply(oack)
+		got := copyTransfer(t, a, b, content)
+		if !bytes.Equal(got, content) {
+			t.Errorf("rollover %d: got %d bytes, want %d", wrap, len(got), len(content))
+		}
+	}
+}
+
+func TestTransferNext(t *testing.T) {
+	tr := &transfer{}
+	if b := tr.next(1); b != 2 {
+		t.Errorf("got %d, want 2", b)
+	}
+	if b := tr.next(65535); b != 0 {
+		t.Errorf("got %d, want 0", b)
+	}
+	tr.wrap = 1
+	if b := tr.next(65535); b != 1 {
+		t.Errorf("got %d, want 1", b)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, test := range []struct {
+		timeout time.Duration
+		try     int
+		want    time.Duration
+	}{
+		{time.Second, 0, time.Second},
+		{time.Second, 1, 2 * time.Second},
+		{time.Second, 3, 8 * time.Second},
+		{time.Second, 10, maxBackoff},
+		{time.Minute, 2, time.Minute},
+	} {
+		d := backoff(test.timeout, test.try)
+		if d < test.want || d > test.want+test.want/10 {
+			t.Errorf("%v try %d: got %v, want %v plus up to a tenth", test.timeout, test.try, d, test.want)
+		}
+	}
+}
+
+func TestTransferMeasure(t *testing.T) {
+	tr := &transfer{timeout: defaultTimeout, adaptive: true}
+	tr.sent = time.Now().Add(-time.Millisecond)
+	tr.measure()
+	if tr.timeout != minAdaptive {
+		t.Errorf("fast peer: got %v, want %v", tr.timeout, minAdaptive)
+	}
+	tr = &transfer{timeout: defaultTimeout, adaptive: true}
+	tr.sent = time.Now().Add(-time.Second)
+	tr.measure()
+	if tr.timeout < 3*time.Second || tr.timeout > 3100*time.Millisecond {
+		t.Errorf("slow peer: got %v, want 3s", tr.timeout)
+	}
+	tr = &transfer{timeout: defaultTimeout, adaptive: true, log: discard}
+	tr.apply(map[option]int{timeout: 2})
+	tr.sent = time.Now().Add(-time.Millisecond)
+	tr.measure()
+	if tr.timeout != 2*time.Second {
+		t.Errorf("negotiated: got %v, want 2s", tr.timeout)
+	}
+}
+
+// ackConn is a net.PacketConn acknowledging each DATA packet written
+type ackConn struct {
+	net.PacketConn
+	peer net.Addr
+	acks chan [4]byte
+}
+
+func newAckConn() *ackConn {
+	return &ackConn{peer: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1069}, acks: make(chan [4]byte, 1)}
+}
+
+func (c *ackConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.acks <- [4]byte{0, byte(ACK), p[2], p[3]}
+	return len(p), nil
+}
+
+func (c *ackConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	ack := <-c.acks
+	return copy(p, ack[:]), c.peer, nil
+}
+
+func (c *ackConn) SetReadDeadline(time.Time) error { return nil }
+
+func TestSenderAllocs(t *testing.T) {
+	c := newAckConn()
+	s := newSender(newTransfer(context.Background(), c, c.peer, true))
+	block := make([]byte, defaultBlockSize)
+	s.Write(block) // fills the pools
+	if n := testing.AllocsPerRun(100, func() { s.Write(block) }); n > 0 {
+		t.Errorf("got %v allocations per block, want 0", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkSender(b *testing.B) {
+	c := newAckConn()
+	s := newSender(newTransfer(context.Background(), c, c.peer, true))
+	block := make([]byte, defaultBlockSize)
+	b.ReportAllocs()
+	b.SetBytes(defaultBlockSize)
+	for b.Loop() {
+		s.Write(block)
+	}
+}
+
+func TestSenderDuplicateACK(t *testing.T) {
+	a, b := transferPair(t)
+	a.adaptive, a.timeout = false, time.Second
+	errc := make(chan error, 1)
+	go func() {
+		w := newSender(a)
+		w.Write(make([]byte, 2*512+10))
+		errc <- w.Close()
+	}()
+	buf := make([]byte, 1024)
+	read := func(want block) {
+		t.Helper()
+		b.conn.SetReadDeadline(time.Now().Add(time.Second / 2))
+		n, _, err := b.conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("block %d: %v", want, err)
+		}
+		if p := packet(buf[:n]); p.opcode() != DATA || p.block() != want {
+			t.Fatalf("got %v block %d, want DATA block %d", p.opcode(), p.block(), want)
+		}
+	}
+	peer := a.conn.LocalAddr()
+	read(1)
+	// a delayed ACK arriving twice does not send block 2 twice
+	b.conn.WriteTo(newACKPacket(1), peer)
+	b.conn.WriteTo(newACKPacket(1), peer)
+	read(2)
+	b.conn.SetReadDeadline(time.Now().Add(time.Second / 4))
+	if n, _, err := b.conn.ReadFrom(buf); err == nil {
+		t.Fatalf("got %v block %d after a duplicate ACK", packet(buf[:n]).opcode(), packet(buf[:n]).block())
+	}
+	b.conn.WriteTo(newACKPacket(2), peer)
+	read(3)
+	b.conn.WriteTo(newACKPacket(3), peer)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingReaderAt counts the reads of an io.ReaderAt
+type countingReaderAt struct {
+	io.ReaderAt
+	reads atomic.Int32
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads.Add(1)
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestSenderReaderAt(t *testing.T) {
+	a, b := transferPair(t)
+	a.adaptive, a.timeout, a.window = false, time.Second/4, 2
+	file := make([]byte, 2*512+100)
+	for i := range file {
+		file[i] = byte(i / 512)
+	}
+	src := &countingReaderAt{ReaderAt: bytes.NewReader(file)}
+	errc := make(chan error, 1)
+	go func() {
+		w := newSender(a)
+		if _, err := w.readFrom(src, 0); err != nil {
+			errc <- err
+			return
+		}
+		errc <- w.Close()
+	}()
+	buf := make([]byte, 1024)
+	read := func(want block) {
+		t.Helper()
+		b.conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := b.conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("block %d: %v", want, err)
+		}
+		p := packet(buf[:n])
+		if p.opcode() != DATA || p.block() != want {
+			t.Fatalf("got %v block %d, want DATA block %d", p.opcode(), p.block(), want)
+		}
+		start := int(want-1) * 512
+		if !bytes.Equal(p.data(), file[start:min(start+512, len(file))]) {
+			t.Errorf("block %d: wrong data", want)
+		}
+	}
+	peer := a.conn.LocalAddr()
+	read(1)
+	read(2)
+	// sent again on timeout, read again from the source
+	read(1)
+	read(2)
+	b.conn.WriteTo(newACKPacket(2), peer)
+	read(3)
+	b.conn.WriteTo(newACKPacket(3), peer)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	// blocks 1 and 2 twice, and the short block 3 once
+	if n := src.reads.Load(); n != 5 {
+		t.Errorf("got %d reads, want 5", n)
+	}
+}
+
+func TestReceiverDuplicateDATA(t *testing.T) {
+	a, b := transferPair(t)
+	b.adaptive, b.timeout = false, time.Second
+	done := make(chan []byte, 1)
+	go func() {
+		r := newReceiver(b, newACKPacket(0))
+		got, _ := io.ReadAll(r)
+		r.Close()
+		done <- got
+	}()
+	buf := make([]byte, 1024)
+	peer := b.conn.LocalAddr()
+	a.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := a.conn.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	// a duplicate block is delivered once and not acknowledged again
+	block1 := newDATAPacket(1, bytes.Repeat([]byte("a"), 512))
+	a.conn.WriteTo(block1, peer)
+	a.conn.WriteTo(block1, peer)
+	n, _, err := a.conn.ReadFrom(buf)
+	if err != nil || packet(buf[:n]).opcode() != ACK || packet(buf[:n]).block() != 1 {
+		t.Fatalf("got %v block %d, %v, want ACK 1", packet(buf[:n]).opcode(), packet(buf[:n]).block(), err)
+	}
+	a.conn.SetReadDeadline(time.Now().Add(time.Second / 4))
+	if _, _, err := a.conn.ReadFrom(buf); err == nil {
+		t.Fatal("duplicate block acknowledged")
+	}
+	a.conn.WriteTo(newDATAPacket(2, []byte("b")), peer)
+	if got := <-done; len(got) != 513 {
+		t.Errorf("got %d bytes, want 513", len(got))
+	}
+}
+
+func TestTransferUnknownTID(t *testing.T) {
+	a, b := transferPair(t)
+	other := dialServer(t)
+	errc := make(chan error, 1)
+	go func() {
+		w := newSender(a)
+		w.Write(make([]byte, 100))
+		errc <- w.Close()
+	}()
+	buf := make([]byte, 1024)
+	if _, _, err := b.conn.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	// a packet from another port is answered without disturbing the
+	// transfer, an ERROR is not answered
+	other.WriteTo(newERRORPacket(NotDefined, "stray"), a.conn.LocalAddr())
+	other.WriteTo(newACKPacket(1), a.conn.LocalAddr())
+	n, _, err := other.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := packet(buf[:n]); p.opcode() != ERROR || p.errorCode() != UnknownTransferID {
+		t.Errorf("got %v %v, want ERROR UnknownTransferID", p.opcode(), p.errorCode())
+	}
+	b.conn.WriteTo(newACKPacket(1), a.conn.LocalAddr())
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := other.ReadFrom(buf); err == nil {
+		t.Error("ERROR packet answered")
+	}
+}
+
+func TestBatchConn(t *testing.T) {
+	v4, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v4.Close()
+	peer4 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 69}
+	peer6 := &net.UDPAddr{IP: net.IPv6loopback, Port: 69}
+	if newBatchConn(v4, peer4, false) == nil || newBatchConn(v4, peer6, false) != nil {
+		t.Error("IPv4 socket: wrong batching")
+	}
+	if v6, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6unspecified}); err == nil {
+		defer v6.Close()
+		if newBatchConn(v6, peer6, false) == nil || newBatchConn(v6, peer4, false) != nil {
+			t.Error("dual stack socket: wrong batching")
+		}
+		if newBatchConn(v6, peer4, true) == nil {
+			t.Error("connected dual stack socket: IPv4 peer not batched")
+		}
+	}
+	if newBatchConn(capture(v4, &PcapWriter{w: io.Discard}), peer4, false) != nil {
+		t.Error("batching a wrapped conn")
+	}
+
+	// windows are sent and received in batches
+	a, b := transferPair(t)
+	if a.batch == nil || b.batch == nil {
+		t.Fatal("loopback transfers not batched")
+	}
+	content := make([]byte, 100*512+100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	oack := map[option]int{windowsize: 16}
+	a.apply(oack)
+	b.apply(oack)
+	if got := copyTransfer(t, a, b, content); !bytes.Equal(got, content) {
+		t.Errorf("got %d bytes, want %d", len(got), len(content))
+	}
+}
@@ -0,0 +1,24 @@
+package tftp
+
+import (
+	"context"
+	"net"
+)
+
+// Transport creates the packet conns of transfers, to run TFTP over
+// other transports than UDP sockets
+type Transport interface {
+	// ResolveAddr returns the address of the server addr of a client
+	ResolveAddr(ctx context.Context, addr string) (net.Addr, error)
+
+	// ListenPacket returns a new conn for a transfer with peer, on the
+	// host of the listening address local of a server, or on any address
+	// if local is nil
+	ListenPacket(ctx context.Context, local, peer net.Addr) (net.PacketConn, error)
+}
+
+// isUDPAddr reports whether addr is a UDP address
+func isUDPAddr(addr net.Addr) bool {
+	_, ok := addr.(*net.UDPAddr)
+	return ok
+}
@@ -0,0 +1,76 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// loopbackUDP is a Transport of loopback UDP sockets counting the conns
+// it creates
+type loopbackUDP struct {
+	conns atomic.Int32
+}
+
+func (l *loopbackUDP) ResolveAddr(ctx context.Context, addr string) (net.Addr, error) {
+	return net.ResolveUDPAddr("udp", addr)
+}
+
+func (l *loopbackUDP) ListenPacket(ctx context.Context, local, peer net.Addr) (net.PacketConn, error) {
+	l.conns.Add(1)
+	return net.ListenPacket("udp", "127.0.0.1:0")
+}
+
+func TestTransport(t *testing.T) {
+	st, ct := &loopbackUDP{}, &loopbackUDP{}
+	s := &Server{Handler: &MemFS{Writable: true}, Transport: st}
+	addr := startServer(t, s).String()
+	c := &Client{Timeout: time.Second, Transport: ct}
+	content := bytes.Repeat([]byte("data"), 300)
+	if err := c.Put(context.Background(), addr, "file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := c.Get(context.Background(), addr, "file", &got); err != nil || !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("got %d bytes, %v, want %d", got.Len(), err, len(content))
+	}
+	if n, m := st.conns.Load(), ct.conns.Load(); n != 2 || m != 2 {
+		t.Errorf("got %d server and %d client conns, want 2 each", n, m)
+	}
+}
+
+// namedAddr is an address of another network than UDP
+type namedAddr string
+
+func (a namedAddr) Network() string { return "test" }
+func (a namedAddr) String() string  { return string(a) }
+
+// namedConn is a conn with an address of another network than UDP
+type namedConn struct {
+	net.PacketConn
+}
+
+func (c namedConn) LocalAddr() net.Addr {
+	return namedAddr("server")
+}
+
+func TestServeOtherTransport(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &MemFS{}
+	m.Set("file", []byte("data"))
+	s := &Server{Handler: m}
+	go s.Serve(namedConn{conn})
+	t.Cleanup(func() { s.Close() })
+	// without Transport, transfers are served from conn
+	var got bytes.Buffer
+	c := &Client{Timeout: time.Second}
+	if err := c.Get(context.Background(), conn.LocalAddr().String(), "file", &got); err != nil || got.String() != "data" {
+		t.Errorf("got %q, %v, want data", got.String(), err)
+	}
+}
@@ -0,0 +1,55 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrChecksumMismatch is returned by GetVerified when the file read does
+// not have the expected digest
+var ErrChecksumMismatch = errors.New("tftp: checksum mismatch")
+
+// GetVerified reads filename from the server at addr into w as Get does,
+// and verifies that the digest of the file written to w with the hash
+// function newHash, such as sha256.New or md5.New, is sum, as the UDP
+// checksum does not catch all corruption of large files. A file with
+// another digest is read again, up to VerifyRetries times, if w is an
+// io.Seeker, rewound to where the file started and truncated there if it
+// has a Truncate method as *os.File does. It fails with
+// ErrChecksumMismatch once the retries are exhausted.
+func (c *Client) GetVerified(ctx context.Context, addr, filename string, w io.Writer, newHash func() hash.Hash, sum []byte) error {
+	seeker, _ := w.(io.Seeker)
+	var start int64
+	if seeker != nil {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	for retries := 0; ; retries++ {
+		h := newHash()
+		if err := c.Get(ctx, addr, filename, io.MultiWriter(w, h)); err != nil {
+			return err
+		}
+		if bytes.Equal(h.Sum(nil), sum) {
+			return nil
+		}
+		if retries >= c.VerifyRetries || seeker == nil {
+			return ErrChecksumMismatch
+		}
+		if c.Logger != nil {
+			c.Logger.Warn("checksum mismatch, transferring again", "peer", addr, "filename", filename)
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return ErrChecksumMismatch
+		}
+		if t, ok := w.(interface{ Truncate(size int64) error }); ok {
+			if err := t.Truncate(start); err != nil {
+				return err
+			}
+		}
+	}
+}
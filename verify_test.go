@@ -0,0 +1,65 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientGetVerified(t *testing.T) {
+	content := bytes.Repeat([]byte("firmware"), 1000)
+	var corrupt atomic.Int32
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) error {
+		b := content
+		if corrupt.Add(-1) >= 0 {
+			// a corrupted copy, shorter than the file
+			b = append([]byte{'F'}, content[1:len(content)-100]...)
+		}
+		_, err := w.Write(b)
+		return err
+	})}
+	addr := startServer(t, s).String()
+	sum := sha256.Sum256(content)
+	c := &Client{Timeout: time.Second, VerifyRetries: 2}
+	ctx := context.Background()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "firmware"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	corrupt.Store(2)
+	if err := c.GetVerified(ctx, addr, "firmware", f, sha256.New, sum[:]); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(f.Name()); !bytes.Equal(b, content) {
+		t.Errorf("got %d bytes, want the file read again", len(b))
+	}
+
+	corrupt.Store(3)
+	f.Seek(0, 0)
+	if err := c.GetVerified(ctx, addr, "firmware", f, sha256.New, sum[:]); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("retries exhausted: got %v, want ErrChecksumMismatch", err)
+	}
+
+	// a writer that cannot be rewound is not read again
+	corrupt.Store(1)
+	var buf bytes.Buffer
+	if err := c.GetVerified(ctx, addr, "firmware", &buf, sha256.New, sum[:]); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("no seeker: got %v, want ErrChecksumMismatch", err)
+	}
+
+	md := md5.Sum(content)
+	buf.Reset()
+	err = c.GetAll(ctx, []GetRequest{{Addr: addr, Filename: "firmware", Writer: &buf, Hash: md5.New, Sum: md[:]}}, 1)
+	if err != nil || !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("GetAll: got %d bytes, %v", buf.Len(), err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package watch invalidates the files of a tftp.Cache when the files they
+// are read from change on disk, so that clients are not served a stale
+// file after a deploy.
+//
+// Changes are noticed with file system notifications from fsnotify, or by
+// polling the directory tree where notifications are unavailable, as on
+// network file systems.
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// defaultInterval is the polling interval if none is given
+const defaultInterval = 2 * time.Second
+
+// Watch invalidates the files of c read from the directory tree root when
+// they are written, replaced or removed, until ctx is done. Changes are
+// noticed with Notify, or with Poll every interval, 2 seconds if zero, if
+// notifications are unavailable. The files cached before the watch is
+// established are evicted.
+func Watch(ctx context.Context, c *tftp.Cache, root string, interval time.Duration) error {
+	err := Notify(ctx, c, root)
+	if errors.Is(err, errUnavailable) {
+		return Poll(ctx, c, root, interval)
+	}
+	return err
+}
+
+// errUnavailable is returned by Notify when notifications are unavailable
+var errUnavailable = errors.New("watch: file system notifications unavailable")
+
+// Notify invalidates the files of c read from the directory tree root as
+// file system notifications report changes, until ctx is done. A removed
+// or renamed directory, and lost notifications, evict all files. The
+// files cached before the watch is established are evicted.
+func Notify(ctx context.Context, c *tftp.Cache, root string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Join(errUnavailable, err)
+	}
+	defer w.Close()
+	if err := addTree(w, root); err != nil {
+		return err
+	}
+	c.Clear()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if err := handle(w, c, root, ev); err != nil {
+				return err
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				return err
+			}
+			c.Clear()
+		}
+	}
+}
+
+// handle invalidates the files of c changed as reported by ev
+func handle(w *fsnotify.Watcher, c *tftp.Cache, root string, ev fsnotify.Event) error {
+	if ev.Has(fsnotify.Create) {
+		if fi, err := os.Lstat(ev.Name); err == nil && fi.IsDir() {
+			// a directory moved into place replaces the files below it
+			c.Clear()
+			return addTree(w, ev.Name)
+		}
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		if ev.Name == root || isWatched(w, ev.Name) {
+			c.Clear()
+			return nil
+		}
+	}
+	if name, ok := cacheName(root, ev.Name); ok {
+		c.Invalidate(name)
+	}
+	return nil
+}
+
+// isWatched reports whether the directory dir is watched by w
+func isWatched(w *fsnotify.Watcher, dir string) bool {
+	for _, d := range w.WatchList() {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree watches the directories of the tree dir
+func addTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path != dir {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return w.Add(path)
+	})
+}
+
+// cacheName returns the name the file path below root is cached under
+func cacheName(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// Poll invalidates the files of c read from the directory tree root that
+// were changed or created since the last scan of the tree, scanning it
+// every interval, 2 seconds if zero, until ctx is done. A file changes
+// when its size, modification time or identity does, as when it is
+// replaced by another. The files cached before the first scan are evicted.
+func Poll(ctx context.Context, c *tftp.Cache, root string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	files, err := scan(root)
+	if err != nil {
+		return err
+	}
+	c.Clear()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+		next, err := scan(root)
+		if err != nil {
+			return err
+		}
+		for name, fi := range files {
+			if nfi, ok := next[name]; !ok || changed(fi, nfi) {
+				c.Invalidate(name)
+			}
+		}
+		for name := range next {
+			// created since the last scan, and maybe cached before changing
+			if _, ok := files[name]; !ok {
+				c.Invalidate(name)
+			}
+		}
+		files = next
+	}
+}
+
+// scan returns the regular files of the tree root by cache name
+func scan(root string) (map[string]fs.FileInfo, error) {
+	files := make(map[string]fs.FileInfo)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path != root {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if name, ok := cacheName(root, path); ok {
+			files[name] = fi
+		}
+		return nil
+	})
+	return files, err
+}
+
+// changed reports whether the file described by fi was changed as
+// described by nfi
+func changed(fi, nfi fs.FileInfo) bool {
+	return fi.Size() != nfi.Size() || !fi.ModTime().Equal(nfi.ModTime()) || !os.SameFile(fi, nfi)
+}
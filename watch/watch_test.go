@@ -0,0 +1,105 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tftp "github.com/jochenvg/go.tftp"
+)
+
+// responseWriter is a tftp.ResponseWriter collecting the file in memory
+type responseWriter struct {
+	bytes.Buffer
+}
+
+func (w *responseWriter) SetSize(int64) {}
+
+// get returns the file name served by c
+func get(t *testing.T, c *tftp.Cache, name string) string {
+	t.Helper()
+	var w responseWriter
+	if err := c.ServeTFTP(&w, &tftp.Request{Op: tftp.RRQ, Filename: name}); err != nil {
+		t.Fatal(err)
+	}
+	return w.String()
+}
+
+// eventually fails the test unless file name served by c becomes want
+func eventually(t *testing.T, c *tftp.Cache, name, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := get(t, c, name)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: got %q, want %q", name, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testWatch tests that the files of a cache are invalidated by watch
+func testWatch(t *testing.T, watch func(ctx context.Context, c *tftp.Cache, root string) error) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("pxelinux.0", "loader v1")
+	write("images/kernel", "kernel v1")
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	c := &tftp.Cache{Handler: &tftp.Dir{Root: root}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watch(ctx, c, dir) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// written in place
+	eventually(t, c, "pxelinux.0", "loader v1")
+	write("pxelinux.0", "loader v2, longer")
+	eventually(t, c, "pxelinux.0", "loader v2, longer")
+
+	// replaced by a rename in a subdirectory
+	eventually(t, c, "images/kernel", "kernel v1")
+	write("images/kernel.new", "kernel v2")
+	if err := os.Rename(filepath.Join(dir, "images/kernel.new"), filepath.Join(dir, "images/kernel")); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, c, "images/kernel", "kernel v2")
+
+	// in a directory created after the watch
+	write("new/initrd", "initrd v1")
+	eventually(t, c, "new/initrd", "initrd v1")
+	write("new/initrd", "initrd v2, longer")
+	eventually(t, c, "new/initrd", "initrd v2, longer")
+}
+
+func TestNotify(t *testing.T) {
+	testWatch(t, Notify)
+}
+
+func TestPoll(t *testing.T) {
+	testWatch(t, func(ctx context.Context, c *tftp.Cache, root string) error {
+		return Poll(ctx, c, root, 20*time.Millisecond)
+	})
+}